@@ -0,0 +1,184 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Hawkbawk/falcon/lib/compose"
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/spf13/cobra"
+)
+
+// composeOverrideCmd represents the compose-override command
+var composeOverrideCmd = &cobra.Command{
+	Use:   "compose-override",
+	Short: "Points a Docker Compose project's containers at falcon's DNS",
+	Long: `falcon compose-override writes a Docker Compose override file next to the compose file in the
+current directory, like docker-compose.override.yml for docker-compose.yml, which docker compose
+reads automatically. It sets dns for every service to the address falcon's DNS listens on, so
+that containers resolve falcon's domains to the loopback address, the same as the host machine,
+whatever DNS settings Docker gives them. Docker's own DNS still answers for service names first.
+
+With --extra-hosts, each service also gets an extra_hosts entry for every hostname falcon knows
+about without asking Docker: the dashboard, routes and hosts. Since Docker only lets containers
+use DNS servers on port 53, that's all falcon can do if dns-port is set to another port.
+
+falcon only overwrites override files it wrote itself. Use --print to see the override file
+without writing it, or to merge it into one of your own.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+		}
+
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		}
+
+		// Point containers at the DNS server falcon up started, if it's been run.
+		backend := recordedBackend(j, cfg)
+		recorded := recordedConfig(j, cfg)
+		extraHosts, _ := cmd.Flags().GetBool("extra-hosts")
+
+		settings, err := overrideSettings(recorded, listenAddresses(backend, recorded), extraHosts)
+		if err != nil {
+			logger.LogError("Unable to point containers at falcon's DNS due to the following error:\n%v", err)
+		}
+
+		path, _ := cmd.Flags().GetString("file")
+		if path == "" {
+			if path, err = compose.Find("."); err != nil {
+				logger.LogError("Unable to find a compose file due to the following error:\n%v", err)
+			}
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			logger.LogError("Unable to read %v due to the following error:\n%v", path, err)
+		}
+		services, err := compose.Services(contents)
+		if err != nil {
+			logger.LogError("Unable to read the services in %v due to the following error:\n%v", path, err)
+		}
+
+		override, err := compose.Override(services, settings)
+		if err != nil {
+			logger.LogError("Unable to create the override file due to the following error:\n%v", err)
+		}
+
+		if printOnly, _ := cmd.Flags().GetBool("print"); printOnly {
+			fmt.Print(string(override))
+			return
+		}
+
+		overridePath := compose.OverridePath(path)
+		if existing, err := os.ReadFile(overridePath); err == nil && !compose.Generated(existing) {
+			logger.LogError("%v already exists, and falcon didn't write it. Run falcon compose-override --print and merge the output into it instead.", overridePath)
+		} else if err != nil && !os.IsNotExist(err) {
+			logger.LogError("Unable to read %v due to the following error:\n%v", overridePath, err)
+		}
+
+		if err := os.WriteFile(overridePath, override, 0644); err != nil {
+			logger.LogError("Unable to write %v due to the following error:\n%v", overridePath, err)
+		}
+
+		for _, note := range settings.Notes {
+			logger.LogInfo("%v", note)
+		}
+		logger.LogInfo("Wrote %v. Run docker compose up to recreate the containers with it.", filepath.Clean(overridePath))
+	},
+}
+
+// overrideSettings works out the settings that point containers at falcon's DNS, listening on the
+// specified addresses, with the specified config. Containers can only use DNS servers on port 53,
+// so with any other port, and with extraHosts, the hostnames falcon knows about without asking
+// Docker are added to each container's /etc/hosts instead.
+func overrideSettings(cfg config.Config, addresses listen.Addresses, extraHosts bool) (compose.Settings, error) {
+	settings := compose.Settings{}
+
+	dnsIP := net.ParseIP(addresses.DNSIP)
+	if dnsIP == nil || dnsIP.IsUnspecified() {
+		// It listens on every interface, including the loopback address.
+		dnsIP = net.ParseIP(cfg.LoopbackAddress)
+	}
+	if dnsIP.IsLoopback() {
+		return settings, fmt.Errorf("falcon's DNS listens on %v, which is each container's own loopback address rather than the host machine's. Set dns-bind-address to an address containers can reach", dnsIP)
+	}
+
+	if addresses.DNSPort == listen.DefaultDNSPort {
+		settings.DNS = []string{dnsIP.String()}
+	} else {
+		extraHosts = true
+		settings.Notes = append(settings.Notes, fmt.Sprintf("falcon's DNS listens on port %v, but containers can only use DNS servers on port 53, so only the hostnames falcon knows about now are added to extra_hosts.", addresses.DNSPort))
+	}
+
+	if cfg.DNSServer == config.DNSServerBuiltin && len(cfg.Upstream.Servers) == 0 {
+		settings.Notes = append(settings.Notes, "falcon's DNS server doesn't have any upstream servers, so containers can't look up any other domains. Set upstream.servers, like falcon config set upstream.servers 1.1.1.1.")
+	}
+
+	if extraHosts {
+		settings.ExtraHosts = knownHosts(cfg)
+	}
+
+	return settings, nil
+}
+
+// knownHosts returns an extra_hosts entry pointing each hostname falcon knows about without asking
+// Docker at the loopback address: the dashboard under each TLD, and every route and host.
+func knownHosts(cfg config.Config) []string {
+	hostnames := make(map[string]bool)
+	for _, tld := range cfg.TLDs {
+		hostnames[proxy.DashboardHostname(tld)] = true
+	}
+	for _, route := range cfg.Routes {
+		hostnames[route.Host] = true
+	}
+	for _, host := range cfg.Hosts {
+		hostnames[host] = true
+	}
+
+	entries := make([]string, 0, len(hostnames))
+	for hostname := range hostnames {
+		entries = append(entries, fmt.Sprintf("%v:%v", hostname, cfg.LoopbackAddress))
+	}
+	sort.Strings(entries)
+
+	return entries
+}
+
+func init() {
+	rootCmd.AddCommand(composeOverrideCmd)
+
+	composeOverrideCmd.Flags().StringP("file", "f", "", "the compose file to write an override file for, instead of the one in the current directory")
+	composeOverrideCmd.Flags().Bool("extra-hosts", false, "also add the hostnames falcon knows about to extra_hosts")
+	composeOverrideCmd.Flags().Bool("print", false, "print the override file instead of writing it")
+}
@@ -0,0 +1,272 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/networking"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows, changes and checks falcon's settings",
+	Long: `falcon config lets you manage the settings in your config file (~/.falcon.yaml by
+default) without editing the YAML by hand. Settings can also come from FALCON_* environment
+variables and flags, which override the config file.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Shows every setting falcon is using and where each one comes from",
+	Long: `falcon config show lists the value falcon uses for every setting, after merging the
+config file, FALCON_* environment variables and flags, along with where each value comes from.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		file := readConfigFile()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+
+		for _, key := range config.Keys() {
+			value, _ := cfg.Value(key)
+			fmt.Fprintf(w, "%v\t%v\t%v\n", key, formatValue(value), source(file, key))
+		}
+
+		w.Flush()
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Sets a setting in the config file",
+	Long: `falcon config set checks the specified value and writes it to the config file. Lists
+like tlds can be separated with commas or spaces, and routes and upstream.domains are written as
+YAML, like '[{host: api.docker, url: "http://host.docker.internal:3000"}]'. Run falcon down and
+falcon up for the change to take effect if falcon is already up.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, value := args[0], args[1]
+		file := readConfigFile()
+
+		if err := file.Set(key, value); err != nil {
+			logger.LogError("Unable to set %v:\n%v", key, err)
+		}
+
+		// Only refuse to write the file if the new value is the problem, so that one bad setting
+		// doesn't stop the rest from being fixed.
+		if err := file.Validate(); err != nil {
+			if errs, ok := err.(config.Errors); !ok {
+				logger.LogError("Unable to set %v:\n%v", key, err)
+			} else if problems := errs.For(key); len(problems) > 0 {
+				logger.LogError("Unable to set %v:\n%v", key, problems)
+			}
+		}
+
+		writeConfigFile(file)
+		logger.LogInfo("Set %v in %v.", key, file.Path)
+		warnIfOverridden(key)
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Removes a setting from the config file, so that its default is used",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := args[0]
+		file := readConfigFile()
+
+		if !file.Unset(key) {
+			logger.LogInfo("%v isn't set in %v.", key, file.Path)
+			return
+		}
+
+		writeConfigFile(file)
+		logger.LogInfo("Removed %v from %v.", key, file.Path)
+		warnIfOverridden(key)
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks every setting and lists any problems",
+	Long: `falcon config validate checks the settings falcon would use, after merging the config
+file, FALCON_* environment variables and flags, and lists every setting with a bad value, along
+with any keys in the config file that falcon doesn't know about. It exits with a non-zero exit
+code if it finds any problems.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		problems := validateConfig(loadConfig(), readConfigFile())
+
+		if len(problems) == 0 {
+			color.Green("✔ falcon's config is valid")
+			return
+		}
+
+		for _, problem := range problems {
+			color.Red("✘ %v", problem)
+		}
+		os.Exit(1)
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Prints the path of the config file",
+	Long: `falcon config path prints the path of the config file falcon reads, which is where
+falcon config set writes to. The file doesn't have to exist yet.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(configPath())
+	},
+}
+
+// configPath returns the path of the config file falcon reads, or where it's created if there isn't
+// one yet.
+func configPath() string {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	cobra.CheckErr(err)
+
+	return filepath.Join(home, ".falcon.yaml")
+}
+
+func readConfigFile() *config.File {
+	file, err := config.ReadFile(configPath())
+	if err != nil {
+		logger.LogError("Unable to read the config file due to the following error:\n%v", err)
+	}
+
+	return file
+}
+
+func writeConfigFile(file *config.File) {
+	if err := file.Write(); err != nil {
+		logger.LogError("Unable to write the config file due to the following error:\n%v", err)
+	}
+}
+
+// validateConfig returns every problem with the specified config and the keys in the specified
+// config file that falcon doesn't know about.
+func validateConfig(cfg config.Config, file *config.File) config.Errors {
+	problems := config.Errors{}
+
+	if errs, ok := cfg.Validate().(config.Errors); ok {
+		problems = append(problems, errs...)
+	}
+
+	if cfg.Backend != "" {
+		if _, err := networking.Select(cfg.Backend); err != nil {
+			problems = append(problems, &config.Error{Key: "network-backend", Err: err})
+		}
+	}
+
+	for _, key := range file.Unknown() {
+		problems = append(problems, &config.Error{Key: key, Err: fmt.Errorf("isn't a config key falcon knows about")})
+	}
+
+	return problems
+}
+
+// flagName returns the name of the flag that sets the specified key, which is the key itself for
+// everything but the TLDs.
+func flagName(key string) string {
+	if key == "tlds" {
+		return "tld"
+	}
+
+	return key
+}
+
+// envName returns the name of the environment variable that sets the specified key.
+func envName(key string) string {
+	return "FALCON_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// source describes where the value of the specified key comes from, in the order viper looks for
+// it: a flag, an environment variable, the config file and finally the default.
+func source(file *config.File, key string) string {
+	if flag := rootCmd.PersistentFlags().Lookup(flagName(key)); flag != nil && flag.Changed {
+		return fmt.Sprintf("flag (--%v)", flag.Name)
+	} else if _, ok := os.LookupEnv(envName(key)); ok {
+		return fmt.Sprintf("env (%v)", envName(key))
+	} else if file.Has(key) {
+		return "file"
+	}
+
+	return "default"
+}
+
+// warnIfOverridden lets the user know when an environment variable overrides the config file, so
+// they aren't surprised when a change doesn't seem to do anything.
+func warnIfOverridden(key string) {
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		logger.LogInfo("Note that %v is set, which overrides the config file.", envName(key))
+	}
+}
+
+// formatValue formats the specified value for falcon config show.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	case []string:
+		return "[" + strings.Join(v, ", ") + "]"
+	case []config.Route:
+		routes := make([]string, 0, len(v))
+		for _, route := range v {
+			routes = append(routes, fmt.Sprintf("%v -> %v", route.Host, route.URL))
+		}
+		return "[" + strings.Join(routes, ", ") + "]"
+	case []config.Forward:
+		forwards := make([]string, 0, len(v))
+		for _, forward := range v {
+			forwards = append(forwards, fmt.Sprintf("%v -> %v", forward.Domain, strings.Join(forward.Servers, " ")))
+		}
+		return "[" + strings.Join(forwards, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd, configSetCmd, configUnsetCmd, configValidateCmd, configPathCmd)
+}
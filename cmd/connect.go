@@ -0,0 +1,96 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/network"
+	"github.com/spf13/cobra"
+)
+
+// connectCmd represents the connect command
+var connectCmd = &cobra.Command{
+	Use:   "connect",
+	Short: "Connects running containers to falcon's Docker network",
+	Long: `falcon connect connects the running containers that network.connect selects to falcon's
+Docker network, so that Traefik can reach them directly. With labelled, those are the containers
+with a traefik.enable=true or falcon.connect=true label, and with all, it's every container.
+falcon up does this once the proxy is running. With --watch, falcon connect keeps running and
+connects each selected container as soon as it starts, until you press Ctrl-C.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		} else if cfg.Network.Connect == config.ConnectNone {
+			logger.LogError("network.connect is none, so there's nothing to connect. Set it to labelled or all first.")
+		}
+
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		ctx, cancel := dockerContext(cfg)
+		defer cancel()
+
+		connected, err := network.ConnectAll(ctx, client, cfg)
+		for _, name := range connected {
+			logger.LogInfo("Connected %v to the %v network.", name, cfg.Network.Name)
+		}
+		if err != nil {
+			logger.LogError("Unable to connect containers to the %v network due to the following error:\n%v", cfg.Network.Name, cancelledError(ctx, cfg, err))
+		}
+
+		if watch, _ := cmd.Flags().GetBool("watch"); !watch {
+			return
+		}
+
+		// Watching carries on for as long as the user wants, so it isn't limited by docker-timeout.
+		watchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger.LogInfo("Watching for containers to connect to the %v network. Press Ctrl-C to stop.", cfg.Network.Name)
+		err = network.Watch(watchCtx, client, cfg, func(name string, err error) {
+			if err != nil {
+				logger.LogInfo("Unable to connect %v to the %v network: %v", name, cfg.Network.Name, err)
+			} else {
+				logger.LogInfo("Connected %v to the %v network.", name, cfg.Network.Name)
+			}
+		})
+		if err != nil {
+			logger.LogError("Unable to keep watching for containers due to the following error:\n%v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(connectCmd)
+
+	connectCmd.Flags().Bool("watch", false, "keep connecting containers as they start until interrupted")
+}
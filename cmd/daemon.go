@@ -0,0 +1,134 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/daemon"
+	"github.com/Hawkbawk/falcon/lib/dns"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/traefik"
+	"github.com/spf13/cobra"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps falcon running, reacting to containers as they start and stop",
+	Long: `falcon daemon keeps running after falcon up, watching Docker for containers starting and
+stopping until you press Ctrl-C. If the proxy or dnsmasq container dies, it's started again, unless
+falcon down stopped it, in which case the daemon stops too. As other containers start and stop,
+the ones network.connect selects are connected to falcon's network, the DNS records for containers
+are updated, and the routes that appear and disappear are logged. Traefik picks up each
+container's routes from its labels itself.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+		} else if j.Empty() {
+			logger.LogError("falcon isn't up, so there's nothing to keep running. Run falcon up first.")
+		}
+
+		// Keep things running the way falcon up set them up, no matter what's configured now.
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		}
+		recorded := recordedConfig(j, cfg)
+		addresses := listenAddresses(recordedBackend(j, cfg), recorded)
+
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		// The daemon carries on for as long as the user wants, so it isn't limited by docker-timeout.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger.LogInfo("Watching Docker for containers starting and stopping. Press Ctrl-C to stop.")
+		err = daemon.Run(ctx, client, recorded, daemon.Hooks{
+			Restart: func(ctx context.Context, name string) error {
+				restartCtx, cancel := context.WithTimeout(ctx, recorded.DockerTimeout)
+				defer cancel()
+				return restartContainer(restartCtx, client, recorded, addresses, name)
+			},
+			Wanted: func(name string) bool {
+				// falcon down may have run since the daemon started, so the journal is read again.
+				current, err := journal.Open(journal.DefaultPath)
+				if err != nil {
+					logger.LogInfo("Unable to read the journal of changes falcon has made: %v", err)
+					return false
+				}
+				_, ok := current.Lookup(containerStep(name))
+				return ok
+			},
+			Log: logger.LogInfo,
+		})
+		if err != nil {
+			logger.LogError("Unable to keep watching Docker due to the following error:\n%v", err)
+		}
+	},
+}
+
+// restartContainer starts the falcon container with the specified name again, and waits until it
+// answers, just like falcon up does.
+func restartContainer(ctx context.Context, client docker.DockerClient, cfg config.Config, addresses listen.Addresses, name string) error {
+	switch name {
+	case proxy.ContainerName:
+		if err := proxy.Start(ctx, client, cfg, addresses, false); err != nil {
+			return err
+		}
+		return proxy.WaitUntilReady(ctx, cfg, addresses.HTTP(), traefik.Get)
+	case dnsmasq.ContainerName:
+		if err := dnsmasq.Start(ctx, client, cfg, addresses, false); err != nil {
+			return err
+		}
+		return dnsmasq.WaitUntilReady(ctx, cfg, addresses.DNS(), dns.Query)
+	default:
+		return fmt.Errorf("%v isn't one of falcon's containers", name)
+	}
+}
+
+// containerStep returns the name of the falcon up step that starts the falcon container with the
+// specified name.
+func containerStep(name string) string {
+	if name == dnsmasq.ContainerName {
+		return "dnsmasq-container"
+	}
+	return "proxy-container"
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dns"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/networking"
+	"github.com/Hawkbawk/falcon/lib/records"
+	"github.com/spf13/cobra"
+)
+
+// dnsCmd represents the dns command
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Runs falcon's built-in DNS server and keeps DNS records for containers",
+	Long: `falcon dns groups the commands for falcon's built-in DNS server, which answers DNS queries
+for falcon's domains when dns-server is builtin, instead of the dnsmasq container, and for the DNS
+records falcon keeps for individual containers.`,
+}
+
+// dnsServeCmd represents the dns serve command
+var dnsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Answers DNS queries for falcon's domains until interrupted",
+	Long: `falcon dns serve answers DNS queries for every hostname under falcon's TLDs with the
+loopback address, on the same address and port dnsmasq would listen on, until you press Ctrl-C.
+Hostnames with a DNS record of their own, from falcon dns records, get the container's address
+instead. Queries for any other hostname are forwarded to the servers in upstream, or get an
+NXDOMAIN answer if there aren't any. falcon up runs this in the background when
+dns-server is builtin, so you only need to run it yourself to see what it's doing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		}
+
+		backend, err := networking.Select(cfg.Backend)
+		if err != nil {
+			logger.LogError("Couldn't choose a networking backend:\n%v", err)
+		}
+		addresses := listenAddresses(backend, cfg)
+
+		server := dns.Server{
+			TLDs:     cfg.TLDs,
+			Address:  net.ParseIP(cfg.LoopbackAddress),
+			Upstream: serverAddresses(cfg.Upstream.Servers),
+		}
+		for _, forward := range cfg.Upstream.Domains {
+			server.Forwards = append(server.Forwards, dns.Forward{Domain: forward.Domain, Servers: serverAddresses(forward.Servers)})
+		}
+		if cfg.Records.Containers != config.RecordsNone {
+			server.Hosts = dns.NewHosts(records.Path(cfg))
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// falcon up finds a server that's already running from its PID file, so that falcon down can
+		// stop it.
+		if err := os.MkdirAll(filepath.Dir(dnsServerFile("dns.pid")), 0755); err != nil {
+			logger.LogError("Unable to create %v due to the following error:\n%v", filepath.Dir(dnsServerFile("dns.pid")), err)
+		}
+		removePIDFile, err := dns.WritePIDFile(dnsServerFile("dns.pid"))
+		if err != nil {
+			logger.LogError("Unable to write falcon's DNS server's PID file due to the following error:\n%v", err)
+		}
+
+		logger.LogInfo("Answering DNS queries on %v. Press Ctrl-C to stop.", addresses.DNS())
+		err = server.ListenAndServe(ctx, addresses.DNS())
+		removePIDFile()
+		if err != nil {
+			logger.LogError("Unable to answer DNS queries on %v due to the following error:\n%v", addresses.DNS(), err)
+		}
+	},
+}
+
+// serverAddresses returns the address, with its port, of each of the specified upstream DNS servers,
+// like "10.0.0.53:53". The servers have already been validated.
+func serverAddresses(servers []string) []string {
+	addresses := make([]string, 0, len(servers))
+
+	for _, server := range servers {
+		if ip, port, err := config.SplitServer(server); err == nil {
+			addresses = append(addresses, net.JoinHostPort(ip, fmt.Sprint(port)))
+		}
+	}
+
+	return addresses
+}
+
+// dnsRecordsCmd represents the dns records command
+var dnsRecordsCmd = &cobra.Command{
+	Use:   "records",
+	Short: "Updates the DNS records for individual containers",
+	Long: `falcon dns records gives each running container with a falcon.dns label its own DNS records,
+which resolve straight to the container's address instead of the proxy, so that services that
+don't speak HTTP, like databases, can be reached by name too. With records.containers set to
+compose, containers started by Docker Compose get records for their service names as well.
+falcon up does this once the proxy is running. With --watch, falcon dns records keeps running and
+updates the records whenever a container starts, stops or is connected to or disconnected from a
+network, until you press Ctrl-C.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := loadConfig()
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		} else if cfg.Records.Containers == config.RecordsNone {
+			logger.LogError("records.containers is none, so there aren't any records to keep. Set it to labelled or compose first.")
+		}
+
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		ctx, cancel := dockerContext(cfg)
+		defer cancel()
+
+		if err := updateRecords(ctx, client, cfg); err != nil {
+			logger.LogError("Unable to update the DNS records due to the following error:\n%v", cancelledError(ctx, cfg, err))
+		}
+
+		if watch, _ := cmd.Flags().GetBool("watch"); !watch {
+			return
+		}
+
+		// Watching carries on for as long as the user wants, so it isn't limited by docker-timeout.
+		watchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger.LogInfo("Watching for containers to update the DNS records for. Press Ctrl-C to stop.")
+		err = records.Watch(watchCtx, client, cfg, func(written []records.Record, problems []string, err error) {
+			if err != nil {
+				logger.LogInfo("Unable to update the DNS records: %v", err)
+				return
+			}
+
+			logger.LogInfo("Updated the DNS records:")
+			records.Log(written, problems, logger.LogInfo)
+		})
+		if err != nil {
+			logger.LogError("Unable to keep watching for containers due to the following error:\n%v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsServeCmd)
+	dnsCmd.AddCommand(dnsRecordsCmd)
+
+	dnsRecordsCmd.Flags().Bool("watch", false, "keep updating the records as containers start and stop until interrupted")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/doctor"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Finds common problems that stop falcon from working and explains how to fix them",
+	Long: `falcon doctor checks Docker, mkcert, falcon's ports, the loopback address and resolver
+settings, dnsmasq and the proxy, suggesting a fix for each problem it finds. It exits with a
+non-zero exit code if any check fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+		}
+
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		cfg := loadConfig()
+		backend := recordedBackend(j, cfg)
+		recorded := recordedConfig(j, cfg)
+		env := doctor.NewEnvironment(client, backend, recorded, listenAddresses(backend, recorded))
+		ctx, cancel := dockerContext(cfg)
+		defer cancel()
+
+		results := doctor.Run(doctor.Checks(ctx, env))
+		printResults(results)
+
+		if doctor.Failed(results) {
+			os.Exit(1)
+		}
+	},
+}
+
+// printResults prints each result, coloured by how serious it is, along with its suggested fix.
+func printResults(results []doctor.Result) {
+	for _, result := range results {
+		print := color.Green
+		switch result.Level {
+		case doctor.Warning:
+			print = color.Yellow
+		case doctor.Failure:
+			print = color.Red
+		}
+
+		print("[%v] %v: %v", strings.ToUpper(string(result.Level)), result.Check, result.Message)
+		if result.Fix != "" {
+			fmt.Printf("       fix: %v\n", result.Fix)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
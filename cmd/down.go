@@ -16,11 +16,14 @@ limitations under the License.
 package cmd
 
 import (
-	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/config"
 	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/domains"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/listen"
 	"github.com/Hawkbawk/falcon/lib/logger"
 	"github.com/Hawkbawk/falcon/lib/networking"
-	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
 	"github.com/spf13/cobra"
 )
 
@@ -29,27 +32,93 @@ var downCmd = &cobra.Command{
 	Use:   "down",
 	Short: "Restores networking to normal and stops the proxy and dnsmasq containers",
 	Long: `Running the down command will restore all machine networking to normal. It will also
-	stop and remove the proxy and dnsmasq container.`,
+	stop and remove the proxy and dnsmasq container. Only the changes falcon up recorded
+	making are undone.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := networking.Clean(); err != nil {
-			logger.LogError("Unable to restore networking:\n%v", err)
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
 		}
+
+		// Always undo changes with the backend and config that made them, no matter what's
+		// configured now.
+		cfg := loadConfig()
+		backend := recordedBackend(j, cfg)
+		recorded := recordedConfig(j, cfg)
+
 		client, err := docker.NewDockerClient()
 		if err != nil {
 			logger.LogError("Unable to connect to Docker server due to the following error:\n%v", err)
 		}
 
-		logger.LogInfo("Stopping the dnsmasq container...")
-		if err := dnsmasq.Stop(client); err != nil {
-			logger.LogError("Unable to stop the dnsmasq container:\n%v", err)
+		ctx, stop := interruptContext()
+		defer stop()
+
+		logger.LogInfo("Restoring networking using the %v backend...", backend.Name())
+		if j.Empty() {
+			// There's no record of what falcon up did, so undo everything it could have done.
+			err = j.UndoAll(upSteps(ctx, backend, client, recorded, listenAddresses(backend, recorded), false))
+		} else {
+			err = j.Undo(upSteps(ctx, backend, client, recorded, listenAddresses(backend, recorded), false))
 		}
-		logger.LogInfo("Stopping the falcon proxy container...")
-		if err := proxy.Stop(client); err != nil {
-			logger.LogError("Unable to stop the proxy container:\n%v", err)
+
+		if err != nil {
+			logger.LogError("%v", cancelledError(ctx, cfg, err))
 		}
 	},
 }
 
+// recordedBackend returns the networking backend falcon up used, according to the journal. If
+// falcon up hasn't been run, the backend in the specified config is returned instead.
+func recordedBackend(j *journal.Journal, cfg config.Config) networking.Backend {
+	backendName := cfg.Backend
+	if j.Backend != "" {
+		backendName = j.Backend
+	}
+
+	backend, err := networking.Select(backendName)
+	if err != nil {
+		logger.LogError("Couldn't choose a networking backend:\n%v", err)
+	}
+
+	return backend
+}
+
+// recordedConfig returns the specified config with the TLDs, loopback address, listening addresses
+// and DNS server falcon up set things up for, according to the journal. If falcon up hasn't been run,
+// the config is returned as is. Journals from before these were configurable don't have them, but
+// those were always set up for the default TLD and loopback address, listening on every interface.
+func recordedConfig(j *journal.Journal, cfg config.Config) config.Config {
+	if j.Empty() {
+		return cfg
+	}
+
+	cfg.TLDs = domains.Normalize(j.TLDs)
+
+	cfg.LoopbackAddress = loopback.DefaultAddress
+	if j.LoopbackAddress != "" {
+		cfg.LoopbackAddress = j.LoopbackAddress
+	}
+
+	addresses := listen.Legacy()
+	if j.Addresses != nil {
+		addresses = *j.Addresses
+	}
+	cfg.ProxyBindAddress = addresses.ProxyIP
+	cfg.HTTPPort = addresses.HTTPPort
+	cfg.HTTPSPort = addresses.HTTPSPort
+	cfg.DNSBindAddress = addresses.DNSIP
+	cfg.DNSPort = addresses.DNSPort
+
+	if _, ok := j.Lookup("dns-server"); ok {
+		cfg.DNSServer = config.DNSServerBuiltin
+	} else if _, ok := j.Lookup("dnsmasq-container"); ok {
+		cfg.DNSServer = config.DNSServerDnsmasq
+	}
+
+	return cfg
+}
+
 func init() {
 	rootCmd.AddCommand(downCmd)
 
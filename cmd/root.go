@@ -22,9 +22,17 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
-
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
 	"github.com/spf13/cobra"
 
 	"github.com/spf13/viper"
@@ -56,7 +64,28 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.falcon.yaml)")
-	rootCmd.PersistentFlags()
+	rootCmd.PersistentFlags().String("network-backend", "", "networking backend to use: macos, resolved, networkmanager or hosts (default is to detect one)")
+	cobra.CheckErr(viper.BindPFlag("network-backend", rootCmd.PersistentFlags().Lookup("network-backend")))
+	rootCmd.PersistentFlags().StringSlice("tld", []string{"docker"}, "top-level domain(s) to resolve to falcon, like docker or test. Repeat or comma-separate for several")
+	cobra.CheckErr(viper.BindPFlag("tlds", rootCmd.PersistentFlags().Lookup("tld")))
+	rootCmd.PersistentFlags().String("loopback-address", loopback.DefaultAddress, "private address falcon's domains resolve to, which is added to the loopback interface")
+	cobra.CheckErr(viper.BindPFlag("loopback-address", rootCmd.PersistentFlags().Lookup("loopback-address")))
+	rootCmd.PersistentFlags().String("proxy-bind-address", "", "address the proxy listens on (default is the address falcon's domains resolve to)")
+	cobra.CheckErr(viper.BindPFlag("proxy-bind-address", rootCmd.PersistentFlags().Lookup("proxy-bind-address")))
+	rootCmd.PersistentFlags().Int("http-port", listen.DefaultHTTPPort, "host port the proxy listens on for HTTP requests")
+	cobra.CheckErr(viper.BindPFlag("http-port", rootCmd.PersistentFlags().Lookup("http-port")))
+	rootCmd.PersistentFlags().Int("https-port", listen.DefaultHTTPSPort, "host port the proxy listens on for HTTPS requests")
+	cobra.CheckErr(viper.BindPFlag("https-port", rootCmd.PersistentFlags().Lookup("https-port")))
+	rootCmd.PersistentFlags().String("dns-bind-address", "", "address dnsmasq listens on (default is the address falcon's domains resolve to)")
+	cobra.CheckErr(viper.BindPFlag("dns-bind-address", rootCmd.PersistentFlags().Lookup("dns-bind-address")))
+	rootCmd.PersistentFlags().Int("dns-port", listen.DefaultDNSPort, "host port dnsmasq listens on for DNS queries")
+	cobra.CheckErr(viper.BindPFlag("dns-port", rootCmd.PersistentFlags().Lookup("dns-port")))
+	rootCmd.PersistentFlags().String("dns-server", string(config.DNSServerDnsmasq), "what answers DNS queries for falcon's domains: dnsmasq or builtin")
+	cobra.CheckErr(viper.BindPFlag("dns-server", rootCmd.PersistentFlags().Lookup("dns-server")))
+	rootCmd.PersistentFlags().Bool("auto-hostnames", false, "give containers without Traefik labels a hostname like <service>.<project>.docker")
+	cobra.CheckErr(viper.BindPFlag("auto-hostnames", rootCmd.PersistentFlags().Lookup("auto-hostnames")))
+	rootCmd.PersistentFlags().Duration("docker-timeout", config.DefaultDockerTimeout, "how long to wait for Docker before giving up, like 30s or 5m")
+	cobra.CheckErr(viper.BindPFlag("docker-timeout", rootCmd.PersistentFlags().Lookup("docker-timeout")))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -80,6 +109,9 @@ func initConfig() {
 	}
 
 	viper.SetEnvPrefix("FALCON")
+	// Lets keys like network-backend and images.proxy be set with environment variables like
+	// FALCON_NETWORK_BACKEND and FALCON_IMAGES_PROXY.
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
@@ -87,3 +119,67 @@ func initConfig() {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// loadConfig reads falcon's config from the config file, FALCON_* environment variables and flags.
+func loadConfig() config.Config {
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		logger.LogError("Unable to read falcon's config due to the following error:\n%v", err)
+	}
+
+	return cfg
+}
+
+// dockerContext returns a context for talking to Docker that's cancelled once the configured
+// docker-timeout runs out or falcon is interrupted with Ctrl-C. It's for commands that only talk to
+// Docker, so that the timeout covers the whole command.
+func dockerContext(cfg config.Config) (context.Context, context.CancelFunc) {
+	ctx, stop := interruptContext()
+	ctx, cancel := context.WithTimeout(ctx, cfg.DockerTimeout)
+
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// interruptContext returns a context that's cancelled once falcon is interrupted with Ctrl-C. After
+// that, a second Ctrl-C kills falcon straight away, in case undoing what it already did hangs too.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return ctx, stop
+}
+
+// withDockerTimeout calls do with a context derived from ctx that's cancelled once the configured
+// docker-timeout runs out, for commands that do more than talk to Docker, like asking for a sudo
+// password or waiting for a container to answer, which the timeout shouldn't cover. If the timeout
+// runs out, the error says so.
+func withDockerTimeout(ctx context.Context, cfg config.Config, do func(ctx context.Context) error) error {
+	dockerCtx, cancel := context.WithTimeout(ctx, cfg.DockerTimeout)
+	defer cancel()
+
+	err := do(dockerCtx)
+	if err != nil && ctx.Err() == nil && dockerCtx.Err() == context.DeadlineExceeded {
+		return cancelledError(dockerCtx, cfg, err)
+	}
+	return err
+}
+
+// cancelledError explains why ctx was cancelled, if it was, since Docker's errors only say that
+// the context was cancelled or its deadline was exceeded.
+func cancelledError(ctx context.Context, cfg config.Config, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%v\nDocker didn't finish within %v. Set docker-timeout to wait longer", err, cfg.DockerTimeout)
+	case context.Canceled:
+		return fmt.Errorf("%v\nfalcon was interrupted before it finished", err)
+	default:
+		return err
+	}
+}
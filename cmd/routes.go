@@ -0,0 +1,118 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/traefik"
+	"github.com/spf13/cobra"
+)
+
+// routesCmd represents the routes command
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Lists every hostname falcon proxies and the container behind it",
+	Long: `falcon routes looks at the Traefik labels of every running container and lists each
+hostname they ask to be proxied, along with the container and port requests are sent to and
+whether TLS is enabled. With --check, it also asks Traefik whether it's actually routing each one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		cfg := loadConfig()
+		ctx, cancel := dockerContext(cfg)
+		defer cancel()
+
+		containers, err := client.ListContainers(ctx)
+		if err != nil {
+			logger.LogError("Unable to list the running containers due to the following error:\n%v", cancelledError(ctx, cfg, err))
+		}
+
+		routes := traefik.Routes(containers, cfg.AutoHostnames, cfg.TLDs)
+
+		check, _ := cmd.Flags().GetBool("check")
+		if check {
+			j, err := journal.Open(journal.DefaultPath)
+			if err != nil {
+				logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+			}
+
+			recorded := recordedConfig(j, cfg)
+			address := listenAddresses(recordedBackend(j, cfg), recorded).HTTP()
+			routers, err := traefik.Routers(func(path string) ([]byte, error) {
+				return traefik.Get(ctx, address, proxy.DashboardHostname(recorded.TLDs[0]), path)
+			})
+			if err != nil {
+				logger.LogError("Unable to ask Traefik which routes it knows about due to the following error:\n%v", err)
+			}
+			routes = traefik.WithStatus(routes, routers)
+		}
+
+		printRoutes(routes, check)
+	},
+}
+
+// printRoutes prints the routes as a table, including their status if Traefik was asked for it.
+func printRoutes(routes []traefik.Route, withStatus bool) {
+	if len(routes) == 0 {
+		fmt.Println("No running containers have any routes. Add Traefik labels to a container to proxy it.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	header := "HOSTNAME\tCONTAINER\tPORT\tTLS"
+	if withStatus {
+		header += "\tSTATUS"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, route := range routes {
+		port := route.Port
+		if port == "" {
+			port = "-"
+		}
+
+		row := fmt.Sprintf("%v\t%v\t%v\t%v", route.Hostname, route.Container, port, route.TLS)
+		if withStatus {
+			row += "\t" + route.Status
+		}
+		fmt.Fprintln(w, row)
+	}
+
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(routesCmd)
+
+	routesCmd.Flags().Bool("check", false, "ask Traefik whether it's routing each hostname")
+}
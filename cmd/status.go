@@ -0,0 +1,109 @@
+/*
+Copyright © 2021 Ryan Hawkins ryanlarryhawkins@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/Hawkbawk/falcon/lib/dns"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/status"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows whether every part of falcon is up and working",
+	Long: `falcon status checks that the proxy container is running, that dnsmasq or falcon's own DNS
+server answers DNS queries, that the networking changes made by falcon up are in place, and that
+falcon's domains actually resolve. It exits with a non-zero exit code if anything isn't working,
+and can print its report as JSON with --json for use in scripts.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+		}
+
+		// Check the networking set up by the backend and config falcon up used, if it's been run.
+		cfg := loadConfig()
+		backend := recordedBackend(j, cfg)
+		recorded := recordedConfig(j, cfg)
+
+		client, err := docker.NewDockerClient()
+		if err != nil {
+			logger.LogError("Unable to connect to the Docker server:\n%v", err)
+		}
+
+		ctx, cancel := dockerContext(cfg)
+		defer cancel()
+
+		queryDNS := func(hostname string) ([]string, error) {
+			return dns.Query(ctx, listenAddresses(backend, recorded).DNS(), hostname)
+		}
+
+		summary := status.Check(ctx, client, backend, recorded, net.LookupHost, queryDNS)
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(summary, "", "  ")
+			if err != nil {
+				logger.LogError("Unable to format the status as JSON:\n%v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printSummary(summary)
+		}
+
+		if !summary.Healthy {
+			os.Exit(1)
+		}
+	},
+}
+
+// printSummary prints each component and its details, in green if it's healthy or red if not.
+func printSummary(summary status.Summary) {
+	for _, component := range summary.Components {
+		print := color.Green
+		mark := "✔"
+		if !component.Healthy {
+			print = color.Red
+			mark = "✘"
+		}
+
+		print("%v %v", mark, component.Name)
+		for _, detail := range component.Details {
+			fmt.Printf("    %v\n", detail)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().Bool("json", false, "print the status as JSON")
+}
@@ -38,7 +38,7 @@ container by putting a label that matches the format:
 			logger.LogError("You must specify a hostname and only a hostname!")
 		}
 
-		if err := proxy.EnableTlsForHost(args[0]); err != nil {
+		if err := proxy.EnableTlsForHost(loadConfig().TLS.Dir, args[0]); err != nil {
 			logger.LogError("Unable to enable TLS for the specified host:\n%v", err)
 		}
 	},
@@ -22,44 +22,418 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dns"
 	"github.com/Hawkbawk/falcon/lib/dnsmasq"
 	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/domains"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/listen"
 	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/network"
 	"github.com/Hawkbawk/falcon/lib/networking"
 	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/records"
+	"github.com/Hawkbawk/falcon/lib/traefik"
+	"github.com/docker/docker/api/types"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 // upCmd represents the up command
 var upCmd = &cobra.Command{
 	Use:   "up",
 	Short: "Sets up networking and starts the dnsmasq and proxy container",
-	Long: `falcon up sets up your local networking to point all requests to *.docker to resolve
-to localhost:80, and then starts the dnsmasq and proxy container.
+	Long: `falcon up sets up your local networking to point all requests to *.docker (or whichever
+TLDs you've configured) to resolve to localhost:80, and then starts the dnsmasq and proxy container.
 The proxy container (running Traefik) then takes these requests and acts as
-a reverse-proxy, determining to which container the request should go to.`,
+a reverse-proxy, determining to which container the request should go to.
+If any of this fails, falcon undoes whatever it already changed.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := networking.Configure(); err != nil {
-			logger.LogError("Couldn't configure networking:\n%v", err)
+		j, err := journal.Open(journal.DefaultPath)
+		if err != nil {
+			logger.LogError("Unable to read the journal of changes falcon has made:\n%v", err)
+		}
+
+		cfg := loadConfig()
+		if offline, _ := cmd.Flags().GetBool("offline"); offline {
+			cfg.Pull = config.PullNever
+		}
+		if err := cfg.Validate(); err != nil {
+			logger.LogError("Your config has the following problems:\n%v", err)
+		}
+
+		backend, err := networking.Select(cfg.Backend)
+		if err != nil {
+			logger.LogError("Couldn't choose a networking backend:\n%v", err)
+		} else if !j.Empty() && j.Backend != backend.Name() {
+			logger.LogError("falcon is already up using the %v networking backend. Run falcon down first.", j.Backend)
 		}
+
+		addresses := listenAddresses(backend, cfg)
+
 		client, err := docker.NewDockerClient()
 		if err != nil {
 			logger.LogError("Unable to connect to the Docker server:\n%v", err)
 		}
 
-		logger.LogInfo("Starting the dnsmasq container...")
-		if err := dnsmasq.Start(client); err != nil {
-			logger.LogError("Unable to start the dnsmasq container: \n%v", err)
-		}
+		ctx, stop := interruptContext()
+		defer stop()
+
+		recreate, _ := cmd.Flags().GetBool("force-recreate")
+		steps := upSteps(ctx, backend, client, cfg, addresses, recreate)
 
-		logger.LogInfo("Starting the proxy container...")
-		if err := proxy.Start(client); err != nil {
-			logger.LogError("Unable to start the dnsmasq container:\n%v", err)
+		// The old setup is undone with the steps for the config it was set up with, since it may
+		// have used a different DNS server, and so different steps.
+		recorded := recordedConfig(j, cfg)
+		oldSteps := upSteps(ctx, backend, client, recorded, listenAddresses(backend, recorded), false)
+
+		if j.Interrupted() {
+			logger.LogInfo("The last falcon up didn't finish, so undoing the changes it made first...")
+			if err := j.Undo(oldSteps); err != nil {
+				logger.LogError("%v", err)
+			}
+		} else if changed := setupChanges(j, backend, cfg, addresses); !j.Empty() && len(changed) > 0 {
+			logger.LogInfo("Since %v, undoing the old setup first...", strings.Join(changed, " and "))
+			if err := j.Undo(oldSteps); err != nil {
+				logger.LogError("%v", err)
+			}
 		}
 
+		logger.LogInfo("Configuring networking using the %v backend...", backend.Name())
+		j.Backend = backend.Name()
+		j.TLDs = cfg.TLDs
+		j.LoopbackAddress = cfg.LoopbackAddress
+		j.Addresses = &addresses
+		if err := j.Apply(steps); err != nil {
+			logger.LogError("%v", cancelledError(ctx, cfg, err))
+		}
 	},
 }
 
+// upSteps returns every change falcon up makes, in the order it makes them. Networking is set up
+// first, and then falcon's Docker network is created and the dnsmasq and proxy containers are
+// started on it with the specified config, listening on the specified addresses. Containers whose
+// configuration has changed are recreated, and so are the others if recreate is true. Each
+// container has to start answering within the configured ready-timeout before falcon up moves on.
+// Once they're up, the containers the connect policy selects are connected to the network too. Each
+// thing falcon asks Docker to do, like starting a container, has the configured docker-timeout to
+// finish, and everything stops once ctx is done, except stopping the containers, so that falcon up
+// can clean up after being interrupted.
+func upSteps(ctx context.Context, backend networking.Backend, client docker.DockerClient, cfg config.Config, addresses listen.Addresses, recreate bool) []journal.Step {
+	return append(backend.Steps(cfg),
+		journal.Step{
+			Name:        "network",
+			Description: "create falcon's Docker network",
+			Apply: func() (journal.Changes, error) {
+				logger.LogInfo("Creating the %v Docker network...", cfg.Network.Name)
+				if err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+					return network.Create(ctx, client, cfg)
+				}); err != nil {
+					return journal.Changes{}, err
+				}
+				return journal.Changes{Network: cfg.Network.Name}, nil
+			},
+			Undo: func(changes journal.Changes) error {
+				// Without a record of the network, the configured one is removed, which is only
+				// done if falcon created it.
+				name := changes.Network
+				if name == "" {
+					name = cfg.Network.Name
+				}
+				logger.LogInfo("Removing the %v Docker network...", name)
+				return withDockerTimeout(undoContext(ctx), cfg, func(ctx context.Context) error {
+					return network.Remove(ctx, client, name)
+				})
+			},
+		},
+		dnsStep(ctx, client, cfg, addresses, recreate),
+		journal.Step{
+			Name:        "proxy-container",
+			Description: "start the proxy container",
+			Apply: func() (journal.Changes, error) {
+				logger.LogInfo("Starting the proxy container...")
+				if err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+					return proxy.Start(ctx, client, cfg, addresses, recreate)
+				}); err != nil {
+					return journal.Changes{}, err
+				}
+				logger.LogInfo("Waiting for the proxy to answer...")
+				if err := proxy.WaitUntilReady(ctx, cfg, addresses.HTTP(), traefik.Get); err != nil {
+					return journal.Changes{}, err
+				}
+				return startedContainer(ctx, client, cfg, proxy.ContainerName)
+			},
+			Undo: func(journal.Changes) error {
+				logger.LogInfo("Stopping the falcon proxy container...")
+				return withDockerTimeout(undoContext(ctx), cfg, func(ctx context.Context) error {
+					return proxy.Stop(ctx, client)
+				})
+			},
+		},
+		journal.Step{
+			Name:        "connect-containers",
+			Description: "connect containers to falcon's Docker network",
+			Apply: func() (journal.Changes, error) {
+				var connected []string
+				err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+					var err error
+					connected, err = network.ConnectAll(ctx, client, cfg)
+					return err
+				})
+				for _, name := range connected {
+					logger.LogInfo("Connected %v to the %v network.", name, cfg.Network.Name)
+				}
+				return journal.Changes{}, err
+			},
+			// Removing the network disconnects every container from it.
+			Undo: func(journal.Changes) error { return nil },
+		},
+		journal.Step{
+			Name:        "dns-records",
+			Description: "write DNS records for individual containers",
+			Apply: func() (journal.Changes, error) {
+				if err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+					return updateRecords(ctx, client, cfg)
+				}); err != nil {
+					return journal.Changes{}, err
+				} else if cfg.Records.Containers == config.RecordsNone {
+					return journal.Changes{}, nil
+				}
+				return journal.Changes{CreatedFiles: []string{records.Path(cfg)}}, nil
+			},
+			Undo: func(changes journal.Changes) error {
+				for _, path := range changes.CreatedFiles {
+					if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	)
+}
+
+// updateRecords writes the DNS records for the running containers the configured record policy
+// selects, and lists them along with any that had to be left out.
+func updateRecords(ctx context.Context, client docker.DockerClient, cfg config.Config) error {
+	written, problems, err := records.Update(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+
+	records.Log(written, problems, logger.LogInfo)
+	return nil
+}
+
+// dnsStep returns the step that starts whatever answers DNS queries for falcon's domains with the
+// specified config: the dnsmasq container, or falcon's own DNS server.
+func dnsStep(ctx context.Context, client docker.DockerClient, cfg config.Config, addresses listen.Addresses, recreate bool) journal.Step {
+	if cfg.DNSServer == config.DNSServerBuiltin {
+		return journal.Step{
+			Name:        "dns-server",
+			Description: "start falcon's DNS server",
+			Apply: func() (journal.Changes, error) {
+				return startDNSServer(ctx, cfg, addresses)
+			},
+			Undo: func(changes journal.Changes) error {
+				if changes.PID == 0 {
+					return nil
+				}
+				logger.LogInfo("Stopping falcon's DNS server...")
+				return dns.StopBackground(changes.PID, dnsServeArgs)
+			},
+		}
+	}
+
+	return journal.Step{
+		Name:        "dnsmasq-container",
+		Description: "start the dnsmasq container",
+		Apply: func() (journal.Changes, error) {
+			logger.LogInfo("Starting the dnsmasq container...")
+			if err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+				return dnsmasq.Start(ctx, client, cfg, addresses, recreate)
+			}); err != nil {
+				return journal.Changes{}, err
+			}
+			logger.LogInfo("Waiting for dnsmasq to answer DNS queries...")
+			if err := dnsmasq.WaitUntilReady(ctx, cfg, addresses.DNS(), dns.Query); err != nil {
+				return journal.Changes{}, err
+			}
+			return startedContainer(ctx, client, cfg, dnsmasq.ContainerName)
+		},
+		Undo: func(journal.Changes) error {
+			logger.LogInfo("Stopping the dnsmasq container...")
+			return withDockerTimeout(undoContext(ctx), cfg, func(ctx context.Context) error {
+				return dnsmasq.Stop(ctx, client)
+			})
+		},
+	}
+}
+
+// dnsServeArgs are the arguments that run falcon's DNS server, which are also how falcon down tells
+// that the process it started is still the server before stopping it.
+var dnsServeArgs = []string{"dns", "serve"}
+
+// dnsServerFile returns the path of the file with the specified name that falcon's DNS server keeps
+// next to the journal.
+func dnsServerFile(name string) string {
+	return filepath.Join(filepath.Dir(journal.DefaultPath), name)
+}
+
+// startDNSServer runs falcon dns serve in the background for the specified config, listening on
+// the specified addresses, and waits for it to answer. Its output goes to dns.log next to the
+// journal. If falcon's domains already resolve there, like when falcon up is run again, the server
+// that's already running is left running, and recorded so that falcon down stops it.
+func startDNSServer(ctx context.Context, cfg config.Config, addresses listen.Addresses) (journal.Changes, error) {
+	hostname := proxy.DashboardHostname(cfg.TLDs[0])
+
+	queryCtx, cancel := context.WithTimeout(ctx, time.Second)
+	addrs, err := dns.Query(queryCtx, addresses.DNS(), hostname)
+	cancel()
+	if err == nil && len(addrs) > 0 && addrs[0] == cfg.LoopbackAddress {
+		logger.LogInfo("falcon's DNS server is already running.")
+		pid := dns.ReadPIDFile(dnsServerFile("dns.pid"))
+		if running, err := dns.IsRunning(pid, dnsServeArgs); err != nil || !running {
+			logger.LogInfo("It wasn't started by falcon up, so falcon down won't stop it.")
+			return journal.Changes{}, nil
+		}
+		return journal.Changes{PID: pid}, nil
+	}
+
+	// The server runs in the background as the current user, so find out now if it can't listen.
+	if err := dns.CheckListen(addresses.DNS()); err != nil {
+		return journal.Changes{}, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return journal.Changes{}, err
+	}
+
+	logPath := dnsServerFile("dns.log")
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return journal.Changes{}, err
+	}
+
+	// The server reads the same config file and environment, but everything that decides what it
+	// answers is passed along too, in case it was set with flags.
+	command := append([]string{executable}, dnsServeArgs...)
+	command = append(command,
+		"--tld", strings.Join(cfg.TLDs, ","),
+		"--loopback-address", cfg.LoopbackAddress,
+		"--dns-bind-address", addresses.DNSIP,
+		"--dns-port", fmt.Sprint(addresses.DNSPort),
+	)
+	if cfgFile != "" {
+		command = append(command, "--config", cfgFile)
+	}
+
+	logger.LogInfo("Starting falcon's DNS server...")
+	pid, err := dns.StartBackground(command, logPath)
+	if err != nil {
+		return journal.Changes{}, err
+	}
+
+	logger.LogInfo("Waiting for falcon's DNS server to answer DNS queries...")
+	if err := dns.WaitUntilResolves(ctx, cfg.ReadyTimeout, addresses.DNS(), hostname, cfg.LoopbackAddress, dns.Query); err != nil {
+		// The step isn't recorded when it fails, so nothing else would stop the server.
+		dns.StopBackground(pid, dnsServeArgs)
+		return journal.Changes{}, fmt.Errorf("falcon's DNS server didn't answer DNS queries on %v: %v. See %v to find out why", addresses.DNS(), err, logPath)
+	}
+
+	return journal.Changes{PID: pid}, nil
+}
+
+// undoContext returns ctx, or a context that's never done if ctx is already done, so that the
+// changes made before falcon was interrupted can still be undone.
+func undoContext(ctx context.Context) context.Context {
+	if ctx.Err() == nil {
+		return ctx
+	}
+
+	return context.Background()
+}
+
+// listenAddresses returns where the proxy and dnsmasq listen with the specified backend and config.
+func listenAddresses(backend networking.Backend, cfg config.Config) listen.Addresses {
+	return cfg.Addresses(networking.Address(backend, cfg.LoopbackAddress))
+}
+
+// setupChanges describes each way the TLDs, loopback address, Docker network and DNS server in the
+// specified config, and the specified listening addresses, differ from the ones falcon up last used, according
+// to the journal.
+func setupChanges(j *journal.Journal, backend networking.Backend, cfg config.Config, addresses listen.Addresses) []string {
+	changed := make([]string, 0)
+	previous := recordedConfig(j, cfg)
+
+	if !domains.Same(previous.TLDs, cfg.TLDs) {
+		changed = append(changed, fmt.Sprintf("the TLDs have changed from %v to %v", strings.Join(previous.TLDs, ", "), strings.Join(cfg.TLDs, ", ")))
+	}
+
+	if previous.LoopbackAddress != cfg.LoopbackAddress {
+		changed = append(changed, fmt.Sprintf("the loopback address has changed from %v to %v", previous.LoopbackAddress, cfg.LoopbackAddress))
+	}
+
+	if record, ok := j.Lookup("network"); ok && record.Changes.Network != "" && record.Changes.Network != cfg.Network.Name {
+		changed = append(changed, fmt.Sprintf("the Docker network has changed from %v to %v", record.Changes.Network, cfg.Network.Name))
+	}
+
+	if previous.DNSServer != cfg.DNSServer {
+		changed = append(changed, fmt.Sprintf("the DNS server has changed from %v to %v", previous.DNSServer, cfg.DNSServer))
+	}
+
+	if listenAddresses(backend, previous) != addresses {
+		changed = append(changed, "the addresses the proxy and dnsmasq listen on have changed")
+	}
+
+	return changed
+}
+
+// startedContainer describes the container with the specified name so that it can be recorded in
+// the journal, giving Docker the configured docker-timeout to answer. The containers are always
+// stopped by name, so this is only used to tell the user which container and image falcon started.
+// The image's repo digest is recorded when it has one, since that identifies exactly which image
+// was pulled, and its ID otherwise.
+func startedContainer(ctx context.Context, client docker.DockerClient, cfg config.Config, name string) (journal.Changes, error) {
+	var container *types.Container
+	var digests []string
+	err := withDockerTimeout(ctx, cfg, func(ctx context.Context) error {
+		var err error
+		if container, err = client.GetContainer(ctx, name); err != nil || container == nil {
+			return err
+		}
+		digests, _ = client.ImageDigests(ctx, container.ImageID)
+		return nil
+	})
+
+	if err != nil {
+		return journal.Changes{}, err
+	} else if container == nil {
+		return journal.Changes{}, fmt.Errorf("the %v container isn't running after being started", name)
+	}
+
+	digest := container.ImageID
+	if len(digests) > 0 {
+		digest = digests[0]
+	}
+
+	return journal.Changes{Container: &journal.Container{
+		Name:        name,
+		ID:          container.ID,
+		Image:       container.Image,
+		ImageDigest: digest,
+	}}, nil
+}
+
 func init() {
 	rootCmd.AddCommand(upCmd)
 
@@ -72,4 +446,16 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// upCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	upCmd.Flags().String("pull", string(config.PullAlways), "when to pull the proxy and dnsmasq images: always, if-not-present or never")
+	cobra.CheckErr(viper.BindPFlag("pull", upCmd.Flags().Lookup("pull")))
+	upCmd.Flags().Duration("ready-timeout", config.DefaultReadyTimeout, "how long to wait for the proxy and dnsmasq to start answering, like 30s or 1m")
+	cobra.CheckErr(viper.BindPFlag("ready-timeout", upCmd.Flags().Lookup("ready-timeout")))
+	upCmd.Flags().Bool("force-recreate", false, "recreate the proxy and dnsmasq containers even if their configuration hasn't changed")
+	upCmd.Flags().Bool("offline", false, "use the images already on this machine instead of pulling them, like --pull never. --no-pull does the same")
+	upCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "no-pull" {
+			name = "offline"
+		}
+		return pflag.NormalizedName(name)
+	})
 }
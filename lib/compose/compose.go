@@ -0,0 +1,113 @@
+// The compose package writes Docker Compose override files that point every container in a project
+// at falcon's DNS, so that containers resolve falcon's domains the same way the host machine does,
+// whatever DNS settings Docker gives them on each OS.
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Header starts every override file falcon writes, so that falcon can tell which files are safe to
+// overwrite.
+const Header = "# Written by falcon compose-override. falcon rewrites this file, so don't edit it.\n"
+
+// DefaultFiles are the names of the compose files Docker Compose looks for, in the order it looks for
+// them.
+var DefaultFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Settings are what an override file sets for every service.
+type Settings struct {
+	// DNS are the DNS servers each container asks about everything Docker's own DNS doesn't know.
+	DNS []string
+	// ExtraHosts are added to each container's /etc/hosts, like "api.docker:192.168.40.1".
+	ExtraHosts []string
+	// Notes explain anything about the settings that needs explaining. They're written as comments
+	// after the header.
+	Notes []string
+}
+
+type service struct {
+	DNS        []string `yaml:"dns,omitempty"`
+	ExtraHosts []string `yaml:"extra_hosts,omitempty"`
+}
+
+type file struct {
+	Services map[string]service `yaml:"services"`
+}
+
+// Find returns the path of the compose file in the specified directory, checking for each of the
+// default names in turn.
+func Find(dir string) (string, error) {
+	for _, name := range DefaultFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("there's no %v in %v", strings.Join(DefaultFiles, ", "), dir)
+}
+
+// OverridePath returns the path of the override file Docker Compose reads along with the compose
+// file at the specified path, like docker-compose.override.yml for docker-compose.yml.
+func OverridePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".override" + ext
+}
+
+// Services returns the names of the services in the specified compose file, sorted.
+func Services(contents []byte) ([]string, error) {
+	project := struct {
+		Services map[string]interface{} `yaml:"services"`
+	}{}
+	if err := yaml.Unmarshal(contents, &project); err != nil {
+		return nil, err
+	} else if len(project.Services) == 0 {
+		return nil, fmt.Errorf("it doesn't have any services")
+	}
+
+	services := make([]string, 0, len(project.Services))
+	for name := range project.Services {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+
+	return services, nil
+}
+
+// Override creates an override file that gives each of the specified services the specified
+// settings.
+func Override(services []string, settings Settings) ([]byte, error) {
+	override := file{Services: make(map[string]service, len(services))}
+	for _, name := range services {
+		override.Services[name] = service{DNS: settings.DNS, ExtraHosts: settings.ExtraHosts}
+	}
+
+	contents, err := yaml.Marshal(&override)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	result.WriteString(Header)
+	for _, note := range settings.Notes {
+		fmt.Fprintf(&result, "# %v\n", note)
+	}
+	result.Write(contents)
+
+	return result.Bytes(), nil
+}
+
+// Generated reports whether the specified override file was written by falcon.
+func Generated(contents []byte) bool {
+	return bytes.HasPrefix(contents, []byte(Header))
+}
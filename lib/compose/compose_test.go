@@ -0,0 +1,89 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compose", func() {
+	Describe("Find", func() {
+		It("finds the compose file Docker Compose would use", func() {
+			dir := GinkgoT().TempDir()
+			for _, name := range []string{"docker-compose.yml", "compose.yaml"} {
+				Expect(os.WriteFile(filepath.Join(dir, name), []byte("services: {}\n"), 0644)).To(Succeed())
+			}
+
+			Expect(Find(dir)).To(Equal(filepath.Join(dir, "compose.yaml")))
+		})
+
+		It("returns an error if there isn't one", func() {
+			_, err := Find(GinkgoT().TempDir())
+			Expect(err).To(MatchError(ContainSubstring("there's no compose.yaml")))
+		})
+	})
+
+	Describe("OverridePath", func() {
+		It("puts override before the extension", func() {
+			Expect(OverridePath("/app/docker-compose.yml")).To(Equal("/app/docker-compose.override.yml"))
+			Expect(OverridePath("compose.yaml")).To(Equal("compose.override.yaml"))
+		})
+	})
+
+	Describe("Services", func() {
+		It("lists the services, sorted", func() {
+			Expect(Services([]byte("services:\n  web:\n    image: nginx\n  db:\n    image: postgres\n"))).To(Equal([]string{"db", "web"}))
+		})
+
+		It("returns an error if there aren't any services", func() {
+			_, err := Services([]byte("volumes:\n  data: {}\n"))
+			Expect(err).To(MatchError("it doesn't have any services"))
+		})
+
+		It("returns an error if the file isn't YAML", func() {
+			_, err := Services([]byte("services: [\n"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Override", func() {
+		It("gives every service the settings", func() {
+			contents, err := Override([]string{"web", "db"}, Settings{DNS: []string{"192.168.40.1"}, ExtraHosts: []string{"api.docker:192.168.40.1"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(contents)).To(Equal(Header + `services:
+  db:
+    dns:
+    - 192.168.40.1
+    extra_hosts:
+    - api.docker:192.168.40.1
+  web:
+    dns:
+    - 192.168.40.1
+    extra_hosts:
+    - api.docker:192.168.40.1
+`))
+			Expect(Generated(contents)).To(BeTrue())
+		})
+
+		It("writes the notes as comments after the header", func() {
+			contents, err := Override([]string{"web"}, Settings{DNS: []string{"192.168.40.1"}, Notes: []string{"Read this."}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(HavePrefix(Header + "# Read this.\nservices:\n"))
+		})
+
+		It("leaves out settings that are empty", func() {
+			contents, err := Override([]string{"web"}, Settings{DNS: []string{"192.168.40.1"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).NotTo(ContainSubstring("extra_hosts"))
+		})
+	})
+
+	Describe("Generated", func() {
+		It("doesn't claim files someone else wrote", func() {
+			Expect(Generated([]byte("services:\n  web:\n    ports: [\"3000:3000\"]\n"))).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,800 @@
+// The config package defines every setting falcon reads from ~/.falcon.yaml, FALCON_* environment
+// variables and flags, fills in defaults for the ones that aren't set, and checks that they're all
+// usable before anything is changed on the host machine.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Hawkbawk/falcon/lib/domains"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+	"github.com/docker/distribution/reference"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// The images falcon runs when none are configured.
+const (
+	DefaultProxyImage = "hawkbawk/falcon-proxy"
+	DefaultDNSImage   = "4km3/dnsmasq:2.85-r2"
+)
+
+// DefaultDockerTimeout is how long a command waits for Docker when no other timeout is configured.
+// It's long enough to pull both images on a slow connection.
+const DefaultDockerTimeout = 5 * time.Minute
+
+// DefaultReadyTimeout is how long falcon up waits for the containers to answer when no other
+// timeout is configured.
+const DefaultReadyTimeout = 30 * time.Second
+
+// DefaultNetwork is the name of the Docker network falcon creates when no other name is configured.
+const DefaultNetwork = "falcon"
+
+// DefaultRecordsDir is where falcon writes the DNS records for individual containers when no other
+// directory is configured.
+var DefaultRecordsDir = filepath.Join(os.Getenv("HOME"), ".falcon", "hosts")
+
+// DefaultTLSDir is where falcon keeps certificates and the proxy's dynamic config when no other
+// directory is configured.
+var DefaultTLSDir = filepath.Join(os.Getenv("HOME"), ".falcon")
+
+// Config is every setting falcon has. The mapstructure tags are the keys used in ~/.falcon.yaml,
+// which are also the names of the flags and, uppercased with dashes and dots replaced by
+// underscores and prefixed with FALCON_, the environment variables.
+type Config struct {
+	// Backend is the name of the networking backend to use. If it's empty, one is detected.
+	Backend string `mapstructure:"network-backend"`
+	// TLDs are the top-level domains pointed at falcon. The first one is the primary TLD.
+	TLDs []string `mapstructure:"tlds"`
+	// LoopbackAddress is the private address falcon's domains resolve to.
+	LoopbackAddress string `mapstructure:"loopback-address"`
+	// ProxyBindAddress and DNSBindAddress are where the proxy and dnsmasq listen. If they're empty,
+	// they listen on the address the networking backend points falcon's domains at.
+	ProxyBindAddress string `mapstructure:"proxy-bind-address"`
+	DNSBindAddress   string `mapstructure:"dns-bind-address"`
+	HTTPPort         int    `mapstructure:"http-port"`
+	HTTPSPort        int    `mapstructure:"https-port"`
+	DNSPort          int    `mapstructure:"dns-port"`
+	// DNSServer decides what answers DNS queries for falcon's domains.
+	DNSServer DNSServer `mapstructure:"dns-server"`
+	Upstream  Upstream  `mapstructure:"upstream"`
+	// AutoHostnames gives containers without any Traefik labels a hostname automatically.
+	AutoHostnames bool `mapstructure:"auto-hostnames"`
+	// Hosts are extra hostnames the hosts backend adds to /etc/hosts, since it can't do wildcards.
+	Hosts  []string `mapstructure:"hosts"`
+	Routes []Route  `mapstructure:"routes"`
+	Images Images   `mapstructure:"images"`
+	// Pull decides when the images are pulled.
+	Pull PullPolicy `mapstructure:"pull"`
+	// DockerTimeout is how long falcon waits for Docker before giving up, so that a Docker server
+	// that's stopped responding doesn't leave falcon hanging. Commands that do more than talk to
+	// Docker, like falcon up, give each thing they ask Docker to do a timeout of its own.
+	DockerTimeout time.Duration `mapstructure:"docker-timeout"`
+	// ReadyTimeout is how long falcon up waits for dnsmasq and the proxy to start answering after
+	// their containers start.
+	ReadyTimeout time.Duration `mapstructure:"ready-timeout"`
+	Network      Network       `mapstructure:"network"`
+	Records      Records       `mapstructure:"records"`
+	TLS          TLS           `mapstructure:"tls"`
+}
+
+// Route sends requests for a hostname to a service that isn't a container, like a dev server
+// running on the host machine.
+type Route struct {
+	Host string `mapstructure:"host"`
+	// URL is where requests are sent. It has to be reachable from inside the proxy container, so
+	// services on the host machine are reached through host.docker.internal.
+	URL string `mapstructure:"url"`
+}
+
+// Images are the images falcon runs its containers from. Each one can be pinned by digest, like
+// "hawkbawk/falcon-proxy@sha256:...", so that a new push to the registry doesn't change it.
+type Images struct {
+	Proxy string `mapstructure:"proxy"`
+	DNS   string `mapstructure:"dnsmasq"`
+}
+
+// Upstream decides where dnsmasq and falcon's DNS server send queries for domains that aren't
+// falcon's.
+type Upstream struct {
+	// Servers are the DNS servers queries are forwarded to, like "1.1.1.1" or "10.0.0.53:5353". If
+	// there aren't any, dnsmasq uses the ones Docker gives it, and falcon's DNS server answers that
+	// the domain doesn't exist.
+	Servers []string `mapstructure:"servers"`
+	// Domains send queries for a domain, and every domain under it, to servers of their own, like a
+	// company's internal domains to the resolver on its VPN.
+	Domains []Forward `mapstructure:"domains"`
+}
+
+// Forward sends queries for a domain, and every domain under it, to its own DNS servers.
+type Forward struct {
+	Domain  string   `mapstructure:"domain"`
+	Servers []string `mapstructure:"servers"`
+}
+
+// SplitServer splits the specified upstream DNS server, like "10.0.0.53" or "10.0.0.53:5353", into
+// its IP address and port. The port is 53 unless one is specified.
+func SplitServer(server string) (string, int, error) {
+	if ip := net.ParseIP(server); ip != nil {
+		return ip.String(), 53, nil
+	}
+
+	invalid := fmt.Errorf("%q isn't an IP address, or an IP address and port like 10.0.0.53:5353", server)
+
+	host, rawPort, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", 0, invalid
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(rawPort)
+	if ip == nil || err != nil || validatePort(port) != nil {
+		return "", 0, invalid
+	}
+
+	return ip.String(), port, nil
+}
+
+// DNSServer is what answers DNS queries for falcon's domains.
+type DNSServer string
+
+const (
+	// DNSServerDnsmasq runs the dnsmasq container.
+	DNSServerDnsmasq DNSServer = "dnsmasq"
+	// DNSServerBuiltin runs falcon's own DNS server in the background instead, which doesn't need
+	// an image or any extra capabilities.
+	DNSServerBuiltin DNSServer = "builtin"
+)
+
+// DNSServers are every DNS server, in the order they're listed to users.
+var DNSServers = []DNSServer{DNSServerDnsmasq, DNSServerBuiltin}
+
+// PullPolicy decides when the images falcon runs are pulled.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image every time, so that a tag like latest is kept up to date. Docker
+	// only downloads the layers that changed. Images pinned by digest that are already on the
+	// machine can't be out of date, so they aren't pulled again.
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent only pulls images that aren't on the machine yet.
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never pulls images, so every image has to be on the machine already.
+	PullNever PullPolicy = "never"
+)
+
+// PullPolicies are every pull policy, in the order they're listed to users.
+var PullPolicies = []PullPolicy{PullAlways, PullIfNotPresent, PullNever}
+
+// Network describes the Docker network falcon creates and runs the proxy and dnsmasq on, so that
+// Traefik can reach the containers connected to it directly.
+type Network struct {
+	Name string `mapstructure:"name"`
+	// Subnet is the network's subnet, like "172.30.0.0/24". If it's empty, Docker picks one.
+	Subnet string `mapstructure:"subnet"`
+	// Connect decides which other containers are connected to the network.
+	Connect ConnectPolicy `mapstructure:"connect"`
+}
+
+// ConnectPolicy decides which containers falcon connects to its network.
+type ConnectPolicy string
+
+const (
+	// ConnectNone leaves every container alone, so only containers started on the network, like
+	// with docker run --network falcon, are on it.
+	ConnectNone ConnectPolicy = "none"
+	// ConnectLabelled connects containers Traefik routes to because of their traefik.enable=true
+	// label, or that ask for it with a falcon.connect=true label.
+	ConnectLabelled ConnectPolicy = "labelled"
+	// ConnectAll connects every container.
+	ConnectAll ConnectPolicy = "all"
+)
+
+// ConnectPolicies are every connect policy, in the order they're listed to users.
+var ConnectPolicies = []ConnectPolicy{ConnectNone, ConnectLabelled, ConnectAll}
+
+// Records describes the DNS records falcon keeps for individual containers, which resolve straight
+// to the container's own address rather than the proxy, so that services that don't speak HTTP, like
+// databases, can be reached by name too.
+type Records struct {
+	// Containers decides which containers get records.
+	Containers RecordPolicy `mapstructure:"containers"`
+	// Dir holds the hosts file falcon writes the records to. It's mounted into the dnsmasq container,
+	// which reads every hosts file in it.
+	Dir string `mapstructure:"dir"`
+}
+
+// RecordPolicy decides which containers falcon keeps DNS records for.
+type RecordPolicy string
+
+const (
+	// RecordsNone doesn't keep any records, so every hostname under falcon's TLDs resolves to the
+	// proxy.
+	RecordsNone RecordPolicy = "none"
+	// RecordsLabelled keeps records for the hostnames containers ask for with a falcon.dns label.
+	RecordsLabelled RecordPolicy = "labelled"
+	// RecordsCompose also keeps records for the service names of containers started by Docker
+	// Compose.
+	RecordsCompose RecordPolicy = "compose"
+)
+
+// RecordPolicies are every record policy, in the order they're listed to users.
+var RecordPolicies = []RecordPolicy{RecordsNone, RecordsLabelled, RecordsCompose}
+
+// TLS describes where falcon tls keeps the certificates it creates.
+type TLS struct {
+	// Dir holds the certificates and the Traefik dynamic config that lists them. It's mounted into
+	// the proxy container, which loads every config file in it.
+	Dir string `mapstructure:"dir"`
+}
+
+// Defaults returns the config falcon uses when nothing is configured.
+func Defaults() Config {
+	return Config{
+		TLDs:            []string{domains.DefaultTLD},
+		LoopbackAddress: loopback.DefaultAddress,
+		HTTPPort:        listen.DefaultHTTPPort,
+		HTTPSPort:       listen.DefaultHTTPSPort,
+		DNSPort:         listen.DefaultDNSPort,
+		DNSServer:       DNSServerDnsmasq,
+		Upstream:        Upstream{Servers: []string{}, Domains: []Forward{}},
+		Hosts:           []string{},
+		Routes:          []Route{},
+		Images:          Images{Proxy: DefaultProxyImage, DNS: DefaultDNSImage},
+		Pull:            PullAlways,
+		DockerTimeout:   DefaultDockerTimeout,
+		ReadyTimeout:    DefaultReadyTimeout,
+		Network:         Network{Name: DefaultNetwork, Connect: ConnectNone},
+		Records:         Records{Containers: RecordsLabelled, Dir: DefaultRecordsDir},
+		TLS:             TLS{Dir: DefaultTLSDir},
+	}
+}
+
+// Load reads the config from the specified viper instance, which should already have the config
+// file, environment and flags set up. Settings that aren't set anywhere get their default. The
+// config isn't validated, so that commands that don't change anything still work with a bad one.
+func Load(v *viper.Viper) (Config, error) {
+	// Viper only looks up environment variables for keys it already knows about, so every key
+	// needs a default, even the nested ones.
+	for key, value := range defaults() {
+		v.SetDefault(key, value)
+	}
+
+	c := Config{}
+	if err := v.Unmarshal(&c, viper.DecodeHook(fromString)); err != nil {
+		return Config{}, err
+	}
+
+	return c.normalize(), nil
+}
+
+// defaults flattens the default config into viper's dotted keys, like "images.proxy".
+func defaults() map[string]interface{} {
+	d := Defaults()
+	result := make(map[string]interface{})
+
+	for _, key := range Keys() {
+		result[key], _ = d.Value(key)
+	}
+
+	return result
+}
+
+// Keys returns every config key, like "images.proxy", in the order they're declared in Config.
+func Keys() []string {
+	return keysOf(reflect.TypeOf(Config{}), "")
+}
+
+func keysOf(t reflect.Type, prefix string) []string {
+	keys := make([]string, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, keysOf(field.Type, key+".")...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// IsKey reports whether the specified key is one of the config keys.
+func IsKey(key string) bool {
+	_, ok := lookup(reflect.ValueOf(Config{}), key)
+	return ok
+}
+
+// lookup finds the field of the specified config that holds the specified key.
+func lookup(v reflect.Value, key string) (reflect.Value, bool) {
+	parts := strings.SplitN(key, ".", 2)
+
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("mapstructure") != parts[0] {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() != reflect.Struct && len(parts) == 1 {
+			return field, true
+		} else if field.Kind() == reflect.Struct && len(parts) == 2 {
+			return lookup(field, parts[1])
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// Value returns the value of the specified key in the config.
+func (c Config) Value(key string) (interface{}, error) {
+	field, ok := lookup(reflect.ValueOf(c), key)
+
+	if !ok {
+		return nil, unknownKey(key)
+	}
+
+	return field.Interface(), nil
+}
+
+// Parse converts a value for the specified key written on the command line into the type the key
+// holds. Lists can be separated with commas or whitespace, except for routes and upstream.domains,
+// which are written as YAML, like "[{host: api.docker, url: http://host.docker.internal:3000}]".
+func Parse(key string, value string) (interface{}, error) {
+	field, ok := lookup(reflect.ValueOf(Config{}), key)
+
+	if !ok {
+		return nil, unknownKey(key)
+	}
+
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &Error{Key: key, Err: fmt.Errorf("%q isn't a duration like 30s or 5m", value)}
+		}
+		return duration.String(), nil
+	case field.Kind() == reflect.String:
+		return value, nil
+	case field.Kind() == reflect.Int:
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &Error{Key: key, Err: fmt.Errorf("%q isn't a number", value)}
+		}
+		return number, nil
+	case field.Kind() == reflect.Bool:
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &Error{Key: key, Err: fmt.Errorf("%q isn't true or false", value)}
+		}
+		return enabled, nil
+	case field.Type() == reflect.TypeOf([]string{}):
+		return splitList(value), nil
+	default:
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			return nil, &Error{Key: key, Err: fmt.Errorf("%q isn't valid YAML: %v", value, err)}
+		}
+		return parsed, nil
+	}
+}
+
+func unknownKey(key string) error {
+	return fmt.Errorf("there's no config key named %q. Run falcon config show to see every key", key)
+}
+
+// fromString decodes settings that are written as strings but aren't strings. Lists set with
+// environment variables, like FALCON_TLDS="docker test", are split on whitespace or commas, and
+// durations like "30s" are parsed.
+func fromString(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+
+	switch to {
+	case reflect.TypeOf([]string{}):
+		return splitList(data.(string)), nil
+	case reflect.TypeOf(time.Duration(0)):
+		return time.ParseDuration(strings.TrimSpace(data.(string)))
+	default:
+		return data, nil
+	}
+}
+
+// splitList splits the specified list on commas or whitespace.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// normalize trims whitespace from every setting, and replaces anything that's been left empty
+// with its default.
+func (c Config) normalize() Config {
+	d := Defaults()
+
+	c.Backend = strings.TrimSpace(c.Backend)
+	c.TLDs = domains.Normalize(c.TLDs)
+	c.LoopbackAddress = orDefault(c.LoopbackAddress, d.LoopbackAddress)
+	c.ProxyBindAddress = strings.TrimSpace(c.ProxyBindAddress)
+	c.DNSBindAddress = strings.TrimSpace(c.DNSBindAddress)
+	c.DNSServer = DNSServer(strings.ToLower(orDefault(string(c.DNSServer), string(d.DNSServer))))
+	c.Images.Proxy = orDefault(c.Images.Proxy, d.Images.Proxy)
+	c.Images.DNS = orDefault(c.Images.DNS, d.Images.DNS)
+	c.Pull = PullPolicy(strings.ToLower(orDefault(string(c.Pull), string(d.Pull))))
+	c.Network.Name = orDefault(c.Network.Name, d.Network.Name)
+	c.Network.Subnet = strings.TrimSpace(c.Network.Subnet)
+	c.Network.Connect = ConnectPolicy(strings.ToLower(orDefault(string(c.Network.Connect), string(d.Network.Connect))))
+	c.Records.Containers = RecordPolicy(strings.ToLower(orDefault(string(c.Records.Containers), string(d.Records.Containers))))
+	c.Records.Dir = expandHome(orDefault(c.Records.Dir, d.Records.Dir))
+	c.TLS.Dir = expandHome(orDefault(c.TLS.Dir, d.TLS.Dir))
+
+	if c.HTTPPort == 0 {
+		c.HTTPPort = d.HTTPPort
+	}
+	if c.HTTPSPort == 0 {
+		c.HTTPSPort = d.HTTPSPort
+	}
+	if c.DNSPort == 0 {
+		c.DNSPort = d.DNSPort
+	}
+	if c.DockerTimeout == 0 {
+		c.DockerTimeout = d.DockerTimeout
+	}
+	if c.ReadyTimeout == 0 {
+		c.ReadyTimeout = d.ReadyTimeout
+	}
+
+	hosts := make([]string, 0, len(c.Hosts))
+	for _, host := range c.Hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	c.Hosts = hosts
+
+	c.Upstream.Servers = trimList(c.Upstream.Servers)
+	forwards := make([]Forward, 0, len(c.Upstream.Domains))
+	for _, forward := range c.Upstream.Domains {
+		domain := strings.Trim(strings.ToLower(strings.TrimSpace(forward.Domain)), ".")
+		forwards = append(forwards, Forward{Domain: domain, Servers: trimList(forward.Servers)})
+	}
+	c.Upstream.Domains = forwards
+
+	routes := make([]Route, 0, len(c.Routes))
+	for _, route := range c.Routes {
+		routes = append(routes, Route{Host: strings.ToLower(strings.TrimSpace(route.Host)), URL: strings.TrimSpace(route.URL)})
+	}
+	c.Routes = routes
+
+	return c
+}
+
+// trimList trims whitespace from each item in the specified list, leaving out the empty ones.
+func trimList(list []string) []string {
+	trimmed := make([]string, 0, len(list))
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			trimmed = append(trimmed, item)
+		}
+	}
+
+	return trimmed
+}
+
+func orDefault(value string, defaultValue string) string {
+	if value = strings.TrimSpace(value); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+// expandHome replaces a leading ~ in the specified path with the user's home directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+
+	return path
+}
+
+// Addresses returns where the proxy and dnsmasq listen. Bind addresses that aren't configured
+// default to the specified IP, which should be the address the networking backend points falcon's
+// domains at.
+func (c Config) Addresses(defaultIP string) listen.Addresses {
+	return listen.Addresses{
+		ProxyIP:   orDefault(c.ProxyBindAddress, defaultIP),
+		HTTPPort:  c.HTTPPort,
+		HTTPSPort: c.HTTPSPort,
+		DNSIP:     orDefault(c.DNSBindAddress, defaultIP),
+		DNSPort:   c.DNSPort,
+	}
+}
+
+// Error is a problem with the value of a single config key.
+type Error struct {
+	Key string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors is every problem found with a config.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// For returns the errors about the specified key, including any about the items in it when it's a
+// list, like "routes[0].url", or the keys in it when it's a section, like "images.proxy".
+func (e Errors) For(key string) Errors {
+	result := Errors{}
+
+	for _, err := range e {
+		if err.Key == key || strings.HasPrefix(err.Key, key+"[") || strings.HasPrefix(err.Key, key+".") {
+			result = append(result, err)
+		}
+	}
+
+	return result
+}
+
+// Validate checks every setting, returning Errors naming each key that has a bad value. The
+// backend isn't checked here, since only the networking package knows which ones exist.
+func (c Config) Validate() error {
+	errs := Errors{}
+	add := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, &Error{Key: key, Err: err})
+		}
+	}
+
+	add("tlds", domains.Validate(c.TLDs))
+	add("loopback-address", loopback.Validate(c.LoopbackAddress))
+	add("proxy-bind-address", validateIP(c.ProxyBindAddress))
+	add("dns-bind-address", validateIP(c.DNSBindAddress))
+	add("http-port", validatePort(c.HTTPPort))
+	add("https-port", validatePort(c.HTTPSPort))
+	add("dns-port", validatePort(c.DNSPort))
+
+	add("dns-server", validateDNSServer(c.DNSServer))
+
+	for i, server := range c.Upstream.Servers {
+		_, _, err := SplitServer(server)
+		add(fmt.Sprintf("upstream.servers[%v]", i), err)
+	}
+	for i, forward := range c.Upstream.Domains {
+		add(fmt.Sprintf("upstream.domains[%v].domain", i), validateForwardDomain(forward.Domain, c.TLDs))
+		if len(forward.Servers) == 0 {
+			add(fmt.Sprintf("upstream.domains[%v].servers", i), fmt.Errorf("there aren't any servers to send %v to", forward.Domain))
+		}
+		for j, server := range forward.Servers {
+			_, _, err := SplitServer(server)
+			add(fmt.Sprintf("upstream.domains[%v].servers[%v]", i, j), err)
+		}
+	}
+
+	if c.HTTPPort == c.HTTPSPort {
+		add("https-port", fmt.Errorf("%v is also the http-port. The proxy needs a different port for each", c.HTTPSPort))
+	}
+
+	for i, host := range c.Hosts {
+		add(fmt.Sprintf("hosts[%v]", i), validateHostname(host))
+	}
+
+	for i, route := range c.Routes {
+		add(fmt.Sprintf("routes[%v].host", i), validateHostname(route.Host))
+		add(fmt.Sprintf("routes[%v].url", i), validateURL(route.URL))
+	}
+
+	add("images.proxy", validateImage(c.Images.Proxy))
+	add("images.dnsmasq", validateImage(c.Images.DNS))
+	add("pull", validatePullPolicy(c.Pull))
+
+	if c.DockerTimeout < time.Second {
+		add("docker-timeout", fmt.Errorf("%v is too short. Use a duration like 30s or 5m", c.DockerTimeout))
+	}
+	if c.ReadyTimeout < time.Second {
+		add("ready-timeout", fmt.Errorf("%v is too short. Use a duration like 30s or 1m", c.ReadyTimeout))
+	}
+
+	add("network.name", validateNetworkName(c.Network.Name))
+	add("network.subnet", validateSubnet(c.Network.Subnet))
+	add("network.connect", validateConnectPolicy(c.Network.Connect))
+	add("records.containers", validateRecordPolicy(c.Records.Containers))
+
+	if !filepath.IsAbs(c.Records.Dir) {
+		add("records.dir", fmt.Errorf("%q isn't an absolute path", c.Records.Dir))
+	}
+
+	if !filepath.IsAbs(c.TLS.Dir) {
+		add("tls.dir", fmt.Errorf("%q isn't an absolute path", c.TLS.Dir))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// validateDNSServer checks that the specified DNS server is one falcon knows.
+func validateDNSServer(server DNSServer) error {
+	names := make([]string, 0, len(DNSServers))
+
+	for _, s := range DNSServers {
+		if server == s {
+			return nil
+		}
+		names = append(names, string(s))
+	}
+
+	return fmt.Errorf("%q isn't a DNS server. Use %v", server, strings.Join(names, ", "))
+}
+
+// validatePullPolicy checks that the specified pull policy is one falcon knows.
+func validatePullPolicy(policy PullPolicy) error {
+	names := make([]string, 0, len(PullPolicies))
+
+	for _, p := range PullPolicies {
+		if policy == p {
+			return nil
+		}
+		names = append(names, string(p))
+	}
+
+	return fmt.Errorf("%q isn't a pull policy. Use %v", policy, strings.Join(names, ", "))
+}
+
+// validateConnectPolicy checks that the specified connect policy is one falcon knows.
+func validateConnectPolicy(policy ConnectPolicy) error {
+	names := make([]string, 0, len(ConnectPolicies))
+
+	for _, p := range ConnectPolicies {
+		if policy == p {
+			return nil
+		}
+		names = append(names, string(p))
+	}
+
+	return fmt.Errorf("%q isn't a connect policy. Use %v", policy, strings.Join(names, ", "))
+}
+
+// validateRecordPolicy checks that the specified record policy is one falcon knows.
+func validateRecordPolicy(policy RecordPolicy) error {
+	names := make([]string, 0, len(RecordPolicies))
+
+	for _, p := range RecordPolicies {
+		if policy == p {
+			return nil
+		}
+		names = append(names, string(p))
+	}
+
+	return fmt.Errorf("%q isn't a record policy. Use %v", policy, strings.Join(names, ", "))
+}
+
+// networkName matches the names Docker accepts for networks.
+var networkName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateNetworkName checks that Docker can create a network with the specified name. Docker's
+// built-in networks can't be used, since falcon removes its network when it's done with it.
+func validateNetworkName(name string) error {
+	switch {
+	case !networkName.MatchString(name):
+		return fmt.Errorf("%q isn't a valid network name. Use letters, digits, _, . and -", name)
+	case name == "bridge" || name == "host" || name == "none":
+		return fmt.Errorf("%q is one of Docker's own networks. Pick a name of your own", name)
+	}
+
+	return nil
+}
+
+// validateSubnet checks that the specified subnet is in CIDR notation. An empty one is fine, since
+// it means Docker picks one.
+func validateSubnet(subnet string) error {
+	if subnet == "" {
+		return nil
+	}
+
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("%q isn't a subnet like 172.30.0.0/24", subnet)
+	} else if !ip.Equal(ipNet.IP) {
+		return fmt.Errorf("%q isn't the start of a subnet. Did you mean %v?", subnet, ipNet)
+	}
+
+	return nil
+}
+
+// validateIP checks that the specified bind address is an IP address. An empty one is fine, since
+// it means the default is used.
+func validateIP(ip string) error {
+	if ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("%q isn't a valid IP address", ip)
+	}
+
+	return nil
+}
+
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%v isn't a valid port", port)
+	}
+
+	return nil
+}
+
+// validateHostname checks that the specified hostname is made up of valid DNS labels.
+func validateHostname(hostname string) error {
+	labels := strings.Split(hostname, ".")
+
+	if hostname == "" || domains.Validate(labels) != nil {
+		return fmt.Errorf("%q isn't a valid hostname", hostname)
+	}
+
+	return nil
+}
+
+// validateForwardDomain checks that the specified domain is one queries can be forwarded for, which
+// rules out falcon's own TLDs and every domain under them, since falcon answers for those itself.
+func validateForwardDomain(domain string, tlds []string) error {
+	if err := validateHostname(domain); err != nil {
+		return err
+	}
+
+	for _, tld := range tlds {
+		if domain == tld || strings.HasSuffix(domain, "."+tld) {
+			return fmt.Errorf("%q is under falcon's %v TLD, which falcon answers for itself", domain, tld)
+		}
+	}
+
+	return nil
+}
+
+// validateURL checks that the specified URL is somewhere the proxy can send HTTP requests.
+func validateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q isn't an http:// or https:// URL", rawURL)
+	}
+
+	return nil
+}
+
+// validateImage checks that the specified image is a reference Docker can pull, like
+// "hawkbawk/falcon-proxy" or "4km3/dnsmasq:2.85-r2".
+func validateImage(image string) error {
+	if _, err := reference.ParseNormalizedNamed(image); err != nil {
+		return fmt.Errorf("%q isn't a valid image reference: %v", image, err)
+	}
+
+	return nil
+}
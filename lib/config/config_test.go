@@ -0,0 +1,309 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Hawkbawk/falcon/lib/listen"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Config", func() {
+	var v *viper.Viper
+
+	BeforeEach(func() {
+		v = viper.New()
+		v.SetConfigType("yaml")
+		v.SetEnvPrefix("FALCON")
+		v.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+		v.AutomaticEnv()
+	})
+
+	Describe("Load", func() {
+		It("uses the defaults when nothing is configured", func() {
+			Expect(Load(v)).To(Equal(Defaults()))
+		})
+
+		It("reads every key from the config file", func() {
+			Expect(v.ReadConfig(strings.NewReader(`
+network-backend: hosts
+tlds: [Test, docker]
+loopback-address: 10.254.254.254
+proxy-bind-address: 0.0.0.0
+http-port: 8080
+https-port: 8443
+dns-port: 5353
+dns-server: Builtin
+upstream:
+  servers: [1.1.1.1, " 10.0.0.53:5353"]
+  domains:
+    - domain: Corp.Example.com.
+      servers: [10.8.0.1]
+auto-hostnames: true
+hosts: [App.docker]
+routes:
+  - host: api.test
+    url: http://host.docker.internal:3000
+images:
+  proxy: registry.example.com/falcon-proxy:1.0
+  dnsmasq: registry.example.com/dnsmasq:2.85
+pull: If-Not-Present
+docker-timeout: 90s
+ready-timeout: 1m
+network:
+  name: dev
+  subnet: 172.30.0.0/24
+  connect: Labelled
+records:
+  containers: Compose
+  dir: ~/hosts
+tls:
+  dir: ~/certs
+`))).To(Succeed())
+
+			cfg, err := Load(v)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg).To(Equal(Config{
+				Backend:          "hosts",
+				TLDs:             []string{"test", "docker"},
+				LoopbackAddress:  "10.254.254.254",
+				ProxyBindAddress: "0.0.0.0",
+				HTTPPort:         8080,
+				HTTPSPort:        8443,
+				DNSPort:          5353,
+				DNSServer:        DNSServerBuiltin,
+				Upstream:         Upstream{Servers: []string{"1.1.1.1", "10.0.0.53:5353"}, Domains: []Forward{{Domain: "corp.example.com", Servers: []string{"10.8.0.1"}}}},
+				AutoHostnames:    true,
+				Hosts:            []string{"app.docker"},
+				Routes:           []Route{{Host: "api.test", URL: "http://host.docker.internal:3000"}},
+				Images:           Images{Proxy: "registry.example.com/falcon-proxy:1.0", DNS: "registry.example.com/dnsmasq:2.85"},
+				Pull:             "if-not-present",
+				DockerTimeout:    90 * time.Second,
+				ReadyTimeout:     time.Minute,
+				Network:          Network{Name: "dev", Subnet: "172.30.0.0/24", Connect: ConnectLabelled},
+				Records:          Records{Containers: RecordsCompose, Dir: filepath.Join(os.Getenv("HOME"), "hosts")},
+				TLS:              TLS{Dir: filepath.Join(os.Getenv("HOME"), "certs")},
+			}))
+		})
+
+		It("reads keys from FALCON_ environment variables", func() {
+			env := map[string]string{
+				"FALCON_TLDS":           "docker test",
+				"FALCON_HTTP_PORT":      "8080",
+				"FALCON_IMAGES_DNSMASQ": "registry.example.com/dnsmasq:2.85",
+			}
+			for key, value := range env {
+				Expect(os.Setenv(key, value)).To(Succeed())
+				DeferCleanup(os.Unsetenv, key)
+			}
+
+			cfg, err := Load(v)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.TLDs).To(Equal([]string{"docker", "test"}))
+			Expect(cfg.HTTPPort).To(Equal(8080))
+			Expect(cfg.Images.DNS).To(Equal("registry.example.com/dnsmasq:2.85"))
+		})
+
+		It("replaces empty values with their defaults", func() {
+			Expect(v.ReadConfig(strings.NewReader(`
+tlds: []
+loopback-address: " "
+http-port: 0
+images:
+  proxy: ""
+`))).To(Succeed())
+
+			Expect(Load(v)).To(Equal(Defaults()))
+		})
+
+		It("returns an error if a key has the wrong type", func() {
+			Expect(v.ReadConfig(strings.NewReader("http-port: eighty\n"))).To(Succeed())
+
+			Expect(Load(v)).Error().To(MatchError(ContainSubstring("http-port")))
+		})
+
+		It("returns an error if a duration can't be parsed", func() {
+			Expect(v.ReadConfig(strings.NewReader("docker-timeout: forever\n"))).To(Succeed())
+
+			Expect(Load(v)).Error().To(MatchError(ContainSubstring("docker-timeout")))
+		})
+	})
+
+	Describe("Keys", func() {
+		It("lists every key, including nested ones", func() {
+			Expect(Keys()).To(ContainElements("network-backend", "tlds", "routes", "images.proxy", "images.dnsmasq", "tls.dir"))
+			Expect(Keys()).NotTo(ContainElement("images"))
+		})
+	})
+
+	Describe("Value", func() {
+		It("returns the value of a key", func() {
+			Expect(Defaults().Value("images.dnsmasq")).To(Equal(DefaultDNSImage))
+			Expect(Defaults().Value("http-port")).To(Equal(80))
+		})
+
+		It("returns an error for unknown keys", func() {
+			Expect(Defaults().Value("images")).Error().To(HaveOccurred())
+			Expect(Defaults().Value("images.nginx")).Error().To(HaveOccurred())
+		})
+	})
+
+	Describe("Addresses", func() {
+		It("binds to the specified IP unless a bind address is configured", func() {
+			cfg := Defaults()
+			cfg.DNSBindAddress = "127.0.0.1"
+
+			Expect(cfg.Addresses("192.168.40.1")).To(Equal(listen.Addresses{
+				ProxyIP:   "192.168.40.1",
+				HTTPPort:  80,
+				HTTPSPort: 443,
+				DNSIP:     "127.0.0.1",
+				DNSPort:   53,
+			}))
+		})
+	})
+
+	Describe("SplitServer", func() {
+		It("uses port 53 unless one is specified", func() {
+			for server, expected := range map[string][]interface{}{
+				"1.1.1.1":        {"1.1.1.1", 53},
+				"10.0.0.53:5353": {"10.0.0.53", 5353},
+				"fd00::1":        {"fd00::1", 53},
+				"[fd00::1]:5353": {"fd00::1", 5353},
+			} {
+				ip, port, err := SplitServer(server)
+				Expect(err).NotTo(HaveOccurred())
+				Expect([]interface{}{ip, port}).To(Equal(expected), server)
+			}
+		})
+
+		It("rejects hostnames and bad ports", func() {
+			for _, server := range []string{"dns.example.com", "1.1.1.1:0", "1.1.1.1:dns", ""} {
+				_, _, err := SplitServer(server)
+				Expect(err).To(HaveOccurred(), server)
+			}
+		})
+	})
+
+	Describe("Validate", func() {
+		var cfg Config
+
+		BeforeEach(func() {
+			cfg = Defaults()
+		})
+
+		// keys returns the key named by each of the errors Validate returns.
+		keys := func(err error) []string {
+			var errs Errors
+			Expect(errors.As(err, &errs)).To(BeTrue())
+
+			result := make([]string, 0, len(errs))
+			for _, e := range errs {
+				result = append(result, e.Key)
+			}
+			return result
+		}
+
+		It("accepts the defaults", func() {
+			Expect(cfg.Validate()).To(Succeed())
+		})
+
+		It("names the key with a bad TLD or loopback address", func() {
+			cfg.TLDs = []string{"co.uk"}
+			cfg.LoopbackAddress = "127.0.0.2"
+
+			Expect(keys(cfg.Validate())).To(Equal([]string{"tlds", "loopback-address"}))
+		})
+
+		It("names the key with a bad bind address or port", func() {
+			cfg.DNSBindAddress = "localhost"
+			cfg.HTTPSPort = 70000
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"dns-bind-address", "https-port"}))
+			Expect(err).To(MatchError(ContainSubstring(`dns-bind-address: "localhost" isn't a valid IP address`)))
+		})
+
+		It("rejects timeouts shorter than a second", func() {
+			cfg.DockerTimeout = 60
+			cfg.ReadyTimeout = 500 * time.Millisecond
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"docker-timeout", "ready-timeout"}))
+			Expect(err).To(MatchError(ContainSubstring("docker-timeout: 60ns is too short")))
+		})
+
+		It("rejects DNS servers falcon doesn't know", func() {
+			cfg.DNSServer = "bind"
+
+			Expect(cfg.Validate()).To(MatchError(`dns-server: "bind" isn't a DNS server. Use dnsmasq, builtin`))
+		})
+
+		It("rejects the same port for HTTP and HTTPS", func() {
+			cfg.HTTPSPort = 80
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("https-port: 80 is also the http-port")))
+		})
+
+		It("names the index of a bad host or route", func() {
+			cfg.Hosts = []string{"app.docker", "not a host"}
+			cfg.Routes = []Route{{Host: "api.docker", URL: "host.docker.internal:3000"}}
+
+			Expect(keys(cfg.Validate())).To(Equal([]string{"hosts[1]", "routes[0].url"}))
+		})
+
+		It("names the key with a bad image, pull policy or TLS directory", func() {
+			cfg.Images.Proxy = "Not An Image"
+			cfg.Pull = "sometimes"
+			cfg.TLS.Dir = "certs"
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"images.proxy", "pull", "tls.dir"}))
+			Expect(err).To(MatchError(ContainSubstring(`pull: "sometimes" isn't a pull policy. Use always, if-not-present, never`)))
+		})
+
+		It("names the key with a bad network name, subnet or connect policy", func() {
+			cfg.Network = Network{Name: "-falcon", Subnet: "172.30.0.1/24", Connect: "some"}
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"network.name", "network.subnet", "network.connect"}))
+			Expect(err).To(MatchError(ContainSubstring(`network.subnet: "172.30.0.1/24" isn't the start of a subnet. Did you mean 172.30.0.0/24?`)))
+			Expect(err).To(MatchError(ContainSubstring(`network.connect: "some" isn't a connect policy. Use none, labelled, all`)))
+		})
+
+		It("names the key with a bad record policy or records directory", func() {
+			cfg.Records = Records{Containers: "every", Dir: "hosts"}
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"records.containers", "records.dir"}))
+			Expect(err).To(MatchError(ContainSubstring(`records.containers: "every" isn't a record policy. Use none, labelled, compose`)))
+		})
+
+		It("names the key with a bad upstream server or forwarded domain", func() {
+			cfg.Upstream = Upstream{
+				Servers: []string{"1.1.1.1", "dns.example.com"},
+				Domains: []Forward{
+					{Domain: "corp.example.com", Servers: []string{"10.8.0.1:99999"}},
+					{Domain: "db.docker", Servers: []string{"[fd00::1]:53"}},
+					{Domain: "vpn.example.com"},
+				},
+			}
+
+			err := cfg.Validate()
+			Expect(keys(err)).To(Equal([]string{"upstream.servers[1]", "upstream.domains[0].servers[0]", "upstream.domains[1].domain", "upstream.domains[2].servers"}))
+			Expect(err).To(MatchError(ContainSubstring(`upstream.servers[1]: "dns.example.com" isn't an IP address, or an IP address and port like 10.0.0.53:5353`)))
+			Expect(err).To(MatchError(ContainSubstring(`upstream.domains[1].domain: "db.docker" is under falcon's docker TLD`)))
+		})
+
+		It("rejects Docker's own networks", func() {
+			cfg.Network.Name = "bridge"
+
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`network.name: "bridge" is one of Docker's own networks`)))
+		})
+	})
+})
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// File is a config file as it's written on disk, without any defaults, environment variables or
+// flags applied, so that settings can be changed without writing all of those back to it.
+type File struct {
+	Path     string
+	settings map[string]interface{}
+}
+
+// ReadFile reads the config file at the specified path. A file that doesn't exist yet is empty.
+func ReadFile(path string) (*File, error) {
+	f := &File{Path: path, settings: make(map[string]interface{})}
+	contents, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(contents, &raw); err != nil {
+		return nil, fmt.Errorf("%v isn't valid YAML: %v", path, err)
+	}
+	f.settings = stringKeys(raw)
+
+	return f, nil
+}
+
+// stringKeys converts the maps yaml.v2 creates, which can have keys of any type, into maps with
+// string keys, like the config keys.
+func stringKeys(raw map[interface{}]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(raw))
+
+	for key, value := range raw {
+		if nested, ok := value.(map[interface{}]interface{}); ok {
+			value = stringKeys(nested)
+		}
+		result[fmt.Sprint(key)] = value
+	}
+
+	return result
+}
+
+// Has reports whether the file sets the specified key.
+func (f *File) Has(key string) bool {
+	parent, name := f.parent(key, false)
+	if parent == nil {
+		return false
+	}
+
+	_, ok := parent[name]
+	return ok
+}
+
+// Set parses the specified value for the specified key and sets it in the file.
+func (f *File) Set(key string, value string) error {
+	parsed, err := Parse(key, value)
+	if err != nil {
+		return err
+	}
+
+	parent, name := f.parent(key, true)
+	parent[name] = parsed
+
+	return nil
+}
+
+// Unset removes the specified key from the file, reporting whether it was set. Any sections left
+// empty, like images once neither image is set, are removed too.
+func (f *File) Unset(key string) bool {
+	if !f.Has(key) {
+		return false
+	}
+
+	parent, name := f.parent(key, false)
+	delete(parent, name)
+
+	parts := strings.Split(key, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		section, sectionName := f.parent(strings.Join(parts[:i], "."), false)
+		if nested, ok := section[sectionName].(map[string]interface{}); ok && len(nested) == 0 {
+			delete(section, sectionName)
+		}
+	}
+
+	return true
+}
+
+// parent returns the section of the file that holds the specified key, along with the key's name
+// inside it. Missing sections are created if create is true, and nil is returned otherwise.
+func (f *File) parent(key string, create bool) (map[string]interface{}, string) {
+	parts := strings.Split(key, ".")
+	section := f.settings
+
+	for _, part := range parts[:len(parts)-1] {
+		nested, ok := section[part].(map[string]interface{})
+
+		if !ok && !create {
+			return nil, ""
+		} else if !ok {
+			nested = make(map[string]interface{})
+			section[part] = nested
+		}
+
+		section = nested
+	}
+
+	return section, parts[len(parts)-1]
+}
+
+// Unknown returns every key in the file that isn't a config key, which is usually a typo.
+func (f *File) Unknown() []string {
+	unknown := unknownKeys(f.settings, "")
+	sort.Strings(unknown)
+	return unknown
+}
+
+func unknownKeys(section map[string]interface{}, prefix string) []string {
+	unknown := make([]string, 0)
+
+	for name, value := range section {
+		key := prefix + name
+		nested, isSection := value.(map[string]interface{})
+
+		if IsKey(key) {
+			continue
+		} else if isSection && isSectionKey(key) {
+			unknown = append(unknown, unknownKeys(nested, key+".")...)
+		} else {
+			unknown = append(unknown, key)
+		}
+	}
+
+	return unknown
+}
+
+// isSectionKey reports whether the specified key is a section of config keys, like "images".
+func isSectionKey(key string) bool {
+	for _, k := range Keys() {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config returns the config the file sets, with defaults for everything it doesn't.
+func (f *File) Config() (Config, error) {
+	contents, err := yaml.Marshal(f.settings)
+	if err != nil {
+		return Config{}, err
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return Config{}, err
+	}
+
+	return Load(v)
+}
+
+// Validate checks every setting in the file, returning Errors naming each key with a bad value and
+// each key that falcon doesn't know about.
+func (f *File) Validate() error {
+	cfg, err := f.Config()
+	if err != nil {
+		return err
+	}
+
+	errs := Errors{}
+	if err, ok := cfg.Validate().(Errors); ok {
+		errs = append(errs, err...)
+	}
+
+	for _, key := range f.Unknown() {
+		errs = append(errs, &Error{Key: key, Err: fmt.Errorf("isn't a config key falcon knows about")})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// Write writes the file back to disk. It's written to a temporary file first and then renamed over
+// the old one, so that a crash never leaves a half-written config behind. If the file is a symlink,
+// like one managed by a dotfiles repo, the file it points at is replaced instead.
+func (f *File) Write() error {
+	if ext := filepath.Ext(f.Path); ext != "" && ext != ".yaml" && ext != ".yml" {
+		return fmt.Errorf("%v isn't a YAML file, so falcon can't edit it", f.Path)
+	}
+
+	path := f.Path
+	mode := os.FileMode(0644)
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	contents, err := yaml.Marshal(f.settings)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, contents, mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
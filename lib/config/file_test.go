@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("File", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		path = filepath.Join(dir, ".falcon.yaml")
+	})
+
+	read := func() *File {
+		f, err := ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return f
+	}
+
+	Describe("ReadFile", func() {
+		It("treats a missing file as empty", func() {
+			f := read()
+
+			Expect(f.Has("tlds")).To(BeFalse())
+			Expect(f.Config()).To(Equal(Defaults()))
+		})
+
+		It("returns an error if the file isn't valid YAML", func() {
+			Expect(os.WriteFile(path, []byte("tlds: [docker"), 0644)).To(Succeed())
+
+			Expect(ReadFile(path)).Error().To(MatchError(ContainSubstring("isn't valid YAML")))
+		})
+	})
+
+	Describe("Set", func() {
+		It("sets nested keys with the type the key holds", func() {
+			f := read()
+			Expect(f.Set("images.proxy", "registry.example.com/falcon-proxy:1.0")).To(Succeed())
+			Expect(f.Set("http-port", "8080")).To(Succeed())
+			Expect(f.Set("tlds", "test, docker")).To(Succeed())
+			Expect(f.Set("docker-timeout", "90s")).To(Succeed())
+
+			cfg, err := f.Config()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Images.Proxy).To(Equal("registry.example.com/falcon-proxy:1.0"))
+			Expect(cfg.HTTPPort).To(Equal(8080))
+			Expect(cfg.TLDs).To(Equal([]string{"test", "docker"}))
+			Expect(cfg.DockerTimeout).To(Equal(90 * time.Second))
+		})
+
+		It("parses routes as YAML", func() {
+			f := read()
+			Expect(f.Set("routes", `[{host: api.docker, url: "http://host.docker.internal:3000"}]`)).To(Succeed())
+
+			cfg, err := f.Config()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.Routes).To(Equal([]Route{{Host: "api.docker", URL: "http://host.docker.internal:3000"}}))
+		})
+
+		It("rejects unknown keys and values of the wrong type", func() {
+			f := read()
+
+			Expect(f.Set("image.proxy", "falcon-proxy")).To(MatchError(ContainSubstring(`no config key named "image.proxy"`)))
+			Expect(f.Set("images", "falcon-proxy")).NotTo(Succeed())
+			Expect(f.Set("dns-port", "fifty-three")).To(MatchError(ContainSubstring("dns-port")))
+			Expect(f.Set("auto-hostnames", "yes please")).To(MatchError(ContainSubstring("isn't true or false")))
+			Expect(f.Set("docker-timeout", "forever")).To(MatchError(ContainSubstring("isn't a duration")))
+		})
+	})
+
+	Describe("Unset", func() {
+		It("removes the key and any section it leaves empty", func() {
+			f := read()
+			Expect(f.Set("images.proxy", "falcon-proxy")).To(Succeed())
+
+			Expect(f.Unset("images.proxy")).To(BeTrue())
+			Expect(f.Has("images.proxy")).To(BeFalse())
+			Expect(f.settings).NotTo(HaveKey("images"))
+		})
+
+		It("reports keys that aren't set", func() {
+			Expect(read().Unset("tlds")).To(BeFalse())
+		})
+	})
+
+	Describe("Unknown and Validate", func() {
+		It("finds keys falcon doesn't know about, even in sections", func() {
+			Expect(os.WriteFile(path, []byte("tld: test\nimages:\n  proxxy: falcon-proxy\nhttp-port: 8080\n"), 0644)).To(Succeed())
+			f := read()
+
+			Expect(f.Unknown()).To(Equal([]string{"images.proxxy", "tld"}))
+			Expect(f.Validate()).To(MatchError(ContainSubstring("tld: isn't a config key")))
+		})
+
+		It("names the keys with bad values", func() {
+			Expect(os.WriteFile(path, []byte("loopback-address: 127.0.0.2\n"), 0644)).To(Succeed())
+
+			errs, ok := read().Validate().(Errors)
+			Expect(ok).To(BeTrue())
+			Expect(errs.For("loopback-address")).To(HaveLen(1))
+			Expect(errs.For("tlds")).To(BeEmpty())
+		})
+	})
+
+	Describe("Write", func() {
+		It("writes the settings back, keeping the file's permissions", func() {
+			Expect(os.WriteFile(path, []byte("tlds: [test]\n"), 0600)).To(Succeed())
+			f := read()
+			Expect(f.Set("http-port", "8080")).To(Succeed())
+
+			Expect(f.Write()).To(Succeed())
+
+			Expect(os.ReadFile(path)).To(BeEquivalentTo("http-port: 8080\ntlds:\n- test\n"))
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			Expect(path + ".tmp").NotTo(BeAnExistingFile())
+		})
+
+		It("replaces the file a symlink points at rather than the symlink", func() {
+			target := filepath.Join(dir, "dotfiles", "falcon.yaml")
+			Expect(os.MkdirAll(filepath.Dir(target), 0755)).To(Succeed())
+			Expect(os.WriteFile(target, []byte("tlds: [test]\n"), 0644)).To(Succeed())
+			Expect(os.Symlink(target, path)).To(Succeed())
+			f := read()
+			Expect(f.Set("auto-hostnames", "true")).To(Succeed())
+
+			Expect(f.Write()).To(Succeed())
+
+			Expect(os.Readlink(path)).To(Equal(target))
+			Expect(os.ReadFile(target)).To(ContainSubstring("auto-hostnames: true"))
+		})
+
+		It("refuses to write YAML to a file that isn't YAML", func() {
+			f, err := ReadFile(filepath.Join(dir, "falcon.json"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(f.Write()).To(MatchError(ContainSubstring("isn't a YAML file")))
+		})
+	})
+})
@@ -0,0 +1,196 @@
+// The daemon package keeps falcon running while it's up. It watches Docker's container events,
+// restarting falcon's own containers if they die, and connecting containers to falcon's network and
+// keeping their DNS records up to date as they start and stop, reporting the routes that appear and
+// disappear as it goes.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/network"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/records"
+	"github.com/Hawkbawk/falcon/lib/traefik"
+)
+
+// Containers are the names of the containers falcon up starts, which the daemon restarts if they
+// die.
+var Containers = []string{proxy.ContainerName, dnsmasq.ContainerName}
+
+// restartDelay is how long the daemon waits after one of falcon's containers dies before starting it
+// again. falcon down stops the containers before it records that it has, so waiting gives Wanted a
+// chance to say they shouldn't be running, and keeps a container that dies straight away from being
+// restarted in a tight loop.
+var restartDelay = 2 * time.Second
+
+// Hooks are how the daemon does the things that depend on how falcon up was run.
+type Hooks struct {
+	// Restart starts the falcon container with the specified name again.
+	Restart func(ctx context.Context, name string) error
+	// Wanted reports whether the falcon container with the specified name should be running, which
+	// it shouldn't be once falcon down has stopped it.
+	Wanted func(name string) bool
+	// Log reports something the daemon did, or couldn't do.
+	Log func(format string, args ...interface{})
+}
+
+// Run keeps falcon running until ctx is done, or until one of falcon's containers stops and Wanted
+// says it shouldn't be running any more, since falcon down has stopped it. Containers are connected
+// and records are updated once before watching starts. Nothing the daemon does in response to an
+// event stops it if it fails, since the next event may well succeed; each failure is logged instead.
+// An error is only returned if Docker stops sending events.
+func Run(ctx context.Context, client docker.DockerClient, cfg config.Config, hooks Hooks) error {
+	messages, errs := client.ContainerEvents(ctx)
+	restarts := make(chan string)
+
+	var (
+		lastRecords []records.Record
+		lastRoutes  = make(map[string]string)
+	)
+	sync := func(logRoutes bool) {
+		connected, err := network.ConnectAll(ctx, client, cfg)
+		for _, name := range connected {
+			hooks.Log("Connected %v to the %v network.", name, cfg.Network.Name)
+		}
+		if err != nil {
+			hooks.Log("Unable to connect containers to the %v network: %v", cfg.Network.Name, err)
+		}
+
+		written, problems, err := records.Update(ctx, client, cfg)
+		if err != nil {
+			hooks.Log("Unable to update the DNS records for containers: %v", err)
+		} else if !records.Same(written, lastRecords) {
+			lastRecords = written
+			records.Log(written, problems, hooks.Log)
+		}
+
+		containers, err := client.ListContainers(ctx)
+		if err != nil {
+			hooks.Log("Unable to list the routes: %v", err)
+			return
+		}
+		routes := routeTargets(traefik.Routes(containers, cfg.AutoHostnames, cfg.TLDs))
+		if logRoutes {
+			for _, line := range routeChanges(lastRoutes, routes) {
+				hooks.Log("%v", line)
+			}
+		}
+		lastRoutes = routes
+	}
+
+	sync(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case name := <-restarts:
+			if !hooks.Wanted(name) {
+				hooks.Log("falcon down stopped the %v container, so there's nothing left to keep running.", name)
+				return nil
+			}
+			restart(ctx, client, name, hooks)
+		case message := <-messages:
+			if message.Action != "start" && message.Action != "die" {
+				continue
+			}
+			// falcon's own containers don't need records or routes, and syncing when falcon down
+			// stops them would write the records it just removed back again.
+			if name := message.Actor.Attributes["name"]; isFalconContainer(name) {
+				if message.Action == "die" {
+					hooks.Log("The %v container stopped.", name)
+					time.AfterFunc(restartDelay, func() {
+						select {
+						case restarts <- name:
+						case <-ctx.Done():
+						}
+					})
+				}
+				continue
+			}
+			sync(true)
+		}
+	}
+}
+
+// restart starts the falcon container with the specified name again, unless something else has
+// already started it.
+func restart(ctx context.Context, client docker.DockerClient, name string, hooks Hooks) {
+	if container, err := client.GetContainer(ctx, name); err != nil {
+		hooks.Log("Unable to check on the %v container: %v", name, err)
+		return
+	} else if container != nil && container.State == "running" {
+		return
+	}
+
+	hooks.Log("Restarting the %v container...", name)
+	if err := hooks.Restart(ctx, name); err != nil {
+		hooks.Log("Unable to restart the %v container: %v", name, err)
+	} else {
+		hooks.Log("Restarted the %v container.", name)
+	}
+}
+
+// isFalconContainer reports whether the container with the specified name is one falcon up starts.
+func isFalconContainer(name string) bool {
+	for _, container := range Containers {
+		if name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// routeTargets maps the hostname of each of the specified routes to a description of where it goes.
+func routeTargets(routes []traefik.Route) map[string]string {
+	targets := make(map[string]string, len(routes))
+	for _, route := range routes {
+		target := route.Container
+		if route.Port != "" {
+			target = fmt.Sprintf("%v:%v", target, route.Port)
+		}
+		targets[route.Hostname] = target
+	}
+	return targets
+}
+
+// routeChanges describes the routes that appeared, disappeared or now go somewhere else between the
+// specified route targets, sorted by hostname.
+func routeChanges(before map[string]string, after map[string]string) []string {
+	hostnames := make([]string, 0, len(before)+len(after))
+	for hostname := range before {
+		hostnames = append(hostnames, hostname)
+	}
+	for hostname := range after {
+		if _, ok := before[hostname]; !ok {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+
+	changes := make([]string, 0)
+	for _, hostname := range hostnames {
+		old, hadRoute := before[hostname]
+		current, hasRoute := after[hostname]
+		switch {
+		case !hadRoute:
+			changes = append(changes, fmt.Sprintf("Added a route from %v to %v.", hostname, current))
+		case !hasRoute:
+			changes = append(changes, fmt.Sprintf("Removed the route from %v to %v.", hostname, old))
+		case old != current:
+			changes = append(changes, fmt.Sprintf("%v now goes to %v rather than %v.", hostname, current, old))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,186 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/lib/records"
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// running describes a running container with the specified name and labels, on the default bridge
+// with the specified address.
+func running(name string, labels map[string]string, address string) types.Container {
+	container := types.Container{ID: name, Names: []string{"/" + name}, Labels: labels, State: "running"}
+	container.HostConfig.NetworkMode = "default"
+	container.NetworkSettings = &types.SummaryNetworkSettings{Networks: map[string]*networktypes.EndpointSettings{"bridge": {IPAddress: address}}}
+	return container
+}
+
+// event describes Docker's event for the container with the specified name doing action.
+func event(action string, name string) events.Message {
+	return events.Message{Action: action, Actor: events.Actor{ID: name, Attributes: map[string]string{"name": name}}}
+}
+
+var _ = Describe("Run", func() {
+	var (
+		ctrl       *gomock.Controller
+		mockClient *mock_docker.MockDockerClient
+		cfg        config.Config
+		ctx        context.Context
+		cancel     context.CancelFunc
+		messages   chan events.Message
+		errs       chan error
+		containers []types.Container
+		logged     []string
+		hooks      Hooks
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		cfg = config.Defaults()
+		cfg.Records.Dir = GinkgoT().TempDir()
+
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		messages = make(chan events.Message)
+		errs = make(chan error, 1)
+		mockClient.EXPECT().ContainerEvents(ctx).Return((<-chan events.Message)(messages), (<-chan error)(errs))
+		mockClient.EXPECT().ListContainers(ctx).DoAndReturn(func(context.Context) ([]types.Container, error) {
+			return containers, nil
+		}).AnyTimes()
+
+		containers = []types.Container{}
+		logged = make([]string, 0)
+		hooks = Hooks{
+			Restart: func(context.Context, string) error { return nil },
+			Wanted:  func(string) bool { return true },
+			Log: func(format string, args ...interface{}) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			},
+		}
+
+		delay := restartDelay
+		restartDelay = 0
+		DeferCleanup(func() { restartDelay = delay })
+	})
+
+	It("keeps records and routes up to date as containers start and stop", func() {
+		app := running("app", map[string]string{"traefik.enable": "true", "traefik.http.routers.app.rule": "Host(`app.docker`)"}, "172.17.0.2")
+		postgres := running("postgres", map[string]string{records.DNSLabel: "db"}, "172.17.0.3")
+
+		done := make(chan error)
+		containers = []types.Container{postgres}
+		go func() { done <- Run(ctx, mockClient, cfg, hooks) }()
+
+		// Run only reads the next event once it's finished with the last one, and it ignores pauses,
+		// so the containers are only changed once Run has received a pause.
+		messages <- event("pause", "postgres")
+		containers = []types.Container{app, postgres}
+		messages <- event("start", "app")
+		messages <- event("pause", "app")
+		containers = []types.Container{postgres}
+		messages <- event("die", "app")
+		cancel()
+
+		Expect(<-done).To(Succeed())
+		Expect(logged).To(Equal([]string{
+			"db.docker resolves to 172.17.0.3 (postgres).",
+			"Added a route from app.docker to app.",
+			"Removed the route from app.docker to app.",
+		}))
+
+		contents, err := os.ReadFile(records.Path(cfg))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(ContainSubstring("172.17.0.3 db.docker # postgres"))
+	})
+
+	It("connects the containers the connect policy selects as they start", func() {
+		cfg.Network.Connect = config.ConnectLabelled
+		mockClient.EXPECT().ConnectContainer(ctx, cfg.Network.Name, "app").Return(nil)
+
+		done := make(chan error)
+		go func() { done <- Run(ctx, mockClient, cfg, hooks) }()
+
+		messages <- event("pause", "app")
+		containers = []types.Container{running("app", map[string]string{"falcon.connect": "true"}, "172.17.0.2")}
+		messages <- event("start", "app")
+		cancel()
+
+		Expect(<-done).To(Succeed())
+		Expect(logged).To(ContainElement("Connected app to the falcon network."))
+	})
+
+	It("restarts falcon's containers when they die", func() {
+		restarted := make(chan string, 1)
+		hooks.Restart = func(_ context.Context, name string) error {
+			restarted <- name
+			return nil
+		}
+		mockClient.EXPECT().GetContainer(ctx, proxy.ContainerName).Return(&types.Container{State: "exited"}, nil)
+
+		done := make(chan error)
+		go func() { done <- Run(ctx, mockClient, cfg, hooks) }()
+
+		messages <- event("die", proxy.ContainerName)
+		Eventually(restarted).Should(Receive(Equal(proxy.ContainerName)))
+		cancel()
+
+		Expect(<-done).To(Succeed())
+		Expect(logged).To(Equal([]string{
+			"The falcon-proxy container stopped.",
+			"Restarting the falcon-proxy container...",
+			"Restarted the falcon-proxy container.",
+		}))
+	})
+
+	It("stops once falcon down has stopped falcon's containers", func() {
+		hooks.Wanted = func(string) bool { return false }
+		hooks.Restart = func(context.Context, string) error {
+			Fail("the container shouldn't have been restarted")
+			return nil
+		}
+
+		done := make(chan error)
+		go func() { done <- Run(ctx, mockClient, cfg, hooks) }()
+
+		messages <- event("die", dnsmasq.ContainerName)
+
+		Expect(<-done).To(Succeed())
+		Expect(logged).To(Equal([]string{
+			"The falcon-dnsmasq container stopped.",
+			"falcon down stopped the falcon-dnsmasq container, so there's nothing left to keep running.",
+		}))
+	})
+
+	It("returns the error if Docker stops sending events", func() {
+		err := fmt.Errorf("connection reset")
+		errs <- err
+
+		Expect(Run(ctx, mockClient, cfg, hooks)).To(Equal(err))
+	})
+})
+
+var _ = Describe("routeChanges", func() {
+	It("describes the routes that appeared, disappeared and moved", func() {
+		Expect(routeChanges(
+			map[string]string{"api.docker": "api:8080", "old.docker": "old", "same.docker": "same"},
+			map[string]string{"api.docker": "api:9090", "new.docker": "new", "same.docker": "same"},
+		)).To(Equal([]string{
+			"api.docker now goes to api:9090 rather than api:8080.",
+			"Added a route from new.docker to new.",
+			"Removed the route from old.docker to old.",
+		}))
+	})
+})
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// StartBackground runs the specified command, normally falcon dns serve, as a background process
+// that carries on after falcon exits. Its output is appended to the file at the specified path. The
+// ID of the process is returned so that it can be stopped later.
+func StartBackground(command []string, logPath string) (int, error) {
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	// The process is detached from the terminal, so that closing the terminal or pressing Ctrl-C in
+	// it later doesn't stop the server.
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// StopBackground stops the background process with the specified ID, as long as it's still running
+// a command with the specified arguments, in order. A process that has already exited is ignored,
+// and so is one whose ID now belongs to some other command, like after a reboot, since it isn't
+// ours to stop.
+func StopBackground(pid int, args []string) error {
+	if running, err := IsRunning(pid, args); err != nil || !running {
+		return err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return terminate(process)
+}
+
+// IsRunning reports whether the process with the specified ID is running a command with the
+// specified arguments, in order.
+func IsRunning(pid int, args []string) (bool, error) {
+	if pid <= 0 {
+		return false, nil
+	}
+
+	command, err := commandLine(pid)
+	if err != nil || command == nil {
+		return false, err
+	}
+
+	return containsArgs(command[1:], args), nil
+}
+
+// containsArgs reports whether args appear one after another somewhere in command.
+func containsArgs(command []string, args []string) bool {
+	for i := 0; i+len(args) <= len(command); i++ {
+		matches := true
+		for j, arg := range args {
+			if command[i+j] != arg {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePIDFile writes the ID of the current process to the file at the specified path, so that a
+// server started some other way can still be found and stopped. The returned func removes the file
+// again, unless another process has written its own ID to it since.
+func WritePIDFile(path string) (func(), error) {
+	pid := os.Getpid()
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if ReadPIDFile(path) == pid {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// ReadPIDFile returns the process ID in the file at the specified path, or 0 if there isn't one.
+func ReadPIDFile(path string) int {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// CheckListen checks that the current user can listen for DNS queries on the specified address, so
+// that a server that can't is explained before it's started in the background, rather than failing
+// where nobody sees it. The background server runs as the same user, with the same executable.
+func CheckListen(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("only root can listen on %v here, since ports below 1024 are privileged. Set dns-port to a port above 1023 with a backend that supports it, like resolved, or let falcon listen on privileged ports with sudo setcap cap_net_bind_service=+ep on its executable, or set dns-server to dnsmasq", address)
+	} else if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
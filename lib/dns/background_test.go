@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Background processes", func() {
+	It("starts a process that logs to the specified file and stops it", func() {
+		logPath := filepath.Join(GinkgoT().TempDir(), "dns.log")
+		log := func() (string, error) {
+			data, err := os.ReadFile(logPath)
+			return string(data), err
+		}
+
+		pid, err := StartBackground([]string{"sh", "-c", "trap 'echo stopped; exit' TERM; echo serving; while :; do sleep 0.1; done"}, logPath)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(log, 5*time.Second).Should(Equal("serving\n"))
+
+		Expect(IsRunning(pid, []string{"-c"})).To(BeTrue())
+		Expect(StopBackground(pid, []string{"-c"})).To(Succeed())
+		Eventually(log, 5*time.Second).Should(Equal("serving\nstopped\n"))
+	})
+
+	It("leaves a process running some other command alone", func() {
+		logPath := filepath.Join(GinkgoT().TempDir(), "dns.log")
+		pid, err := StartBackground([]string{"sh", "-c", "trap 'echo stopped; exit' TERM; while :; do sleep 0.1; done"}, logPath)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() { StopBackground(pid, []string{"-c"}) })
+
+		Expect(IsRunning(pid, []string{"dns", "serve"})).To(BeFalse())
+		Expect(StopBackground(pid, []string{"dns", "serve"})).To(Succeed())
+		Consistently(func() (bool, error) { return IsRunning(pid, []string{"-c"}) }, 300*time.Millisecond).Should(BeTrue())
+	})
+
+	It("ignores a process that has already exited", func() {
+		Expect(IsRunning(0, []string{"dns", "serve"})).To(BeFalse())
+		Expect(StopBackground(999999999, []string{"dns", "serve"})).To(Succeed())
+	})
+
+	It("returns an error if the command can't be started", func() {
+		_, err := StartBackground([]string{"/nonexistent/falcon"}, filepath.Join(GinkgoT().TempDir(), "dns.log"))
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("PID files", func() {
+		It("writes the current process's ID and removes it again", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "dns.pid")
+
+			remove, err := WritePIDFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ReadPIDFile(path)).To(Equal(os.Getpid()))
+
+			remove()
+			Expect(path).NotTo(BeAnExistingFile())
+			Expect(ReadPIDFile(path)).To(BeZero())
+		})
+
+		It("leaves the file alone if another process has written to it since", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "dns.pid")
+			remove, err := WritePIDFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(path, []byte("1\n"), 0644)).To(Succeed())
+
+			remove()
+			Expect(ReadPIDFile(path)).To(Equal(1))
+		})
+	})
+
+	Describe("CheckListen", func() {
+		It("succeeds if the address is free", func() {
+			Expect(CheckListen("127.0.0.1:0")).To(Succeed())
+		})
+
+		It("returns an error if something is already listening there", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			Expect(CheckListen(conn.LocalAddr().String())).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// detach gives the command a session of its own, which detaches it from the terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// terminate asks the process to stop. A process that has already exited is ignored.
+func terminate(process *os.Process) error {
+	err := process.Signal(syscall.SIGTERM)
+	if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// commandLine returns the command the process with the specified ID is running, split into its
+// arguments, or nil if there's no such process. It's read from /proc where there is one, and from
+// ps everywhere else, like on macOS, where arguments containing spaces get split up.
+func commandLine(pid int) ([]string, error) {
+	if _, err := os.Stat("/proc/self/cmdline"); err == nil {
+		contents, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+		if os.IsNotExist(err) || (err == nil && len(contents) == 0) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return strings.Split(string(bytes.TrimSuffix(contents, []byte{0})), "\x00"), nil
+	}
+
+	output, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// ps exits with a status of 1 when there's no such process.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	command := strings.Fields(string(output))
+	if len(command) == 0 {
+		return nil, nil
+	}
+	return command, nil
+}
@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// detachedProcess is Windows' DETACHED_PROCESS creation flag, which syscall doesn't define.
+const detachedProcess = 0x00000008
+
+// detach starts the command without a console, in a process group of its own, which detaches it
+// from the terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+// terminate stops the process. Windows can't ask a process without a console to stop, so it's
+// killed. A process that has already exited is ignored.
+func terminate(process *os.Process) error {
+	if err := process.Kill(); err != nil && err != os.ErrProcessDone {
+		return err
+	}
+	return nil
+}
+
+// commandLine returns the command the process with the specified ID is running, split into its
+// arguments, or nil if there's no such process. Arguments containing spaces get split up.
+func commandLine(pid int) ([]string, error) {
+	query := fmt.Sprintf("(Get-CimInstance Win32_Process -Filter 'ProcessId = %v').CommandLine", pid)
+	output, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", query).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	command := strings.Fields(string(output))
+	if len(command) == 0 {
+		return nil, nil
+	}
+	return command, nil
+}
@@ -0,0 +1,13 @@
+package dns
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDns(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DNS Suite")
+}
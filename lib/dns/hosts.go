@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hosts is a hosts file, in the same format as /etc/hosts, that's read again whenever it changes,
+// like the ones dnsmasq reads with --hostsdir. A file that doesn't exist has no hosts in it.
+type Hosts struct {
+	path string
+
+	mu        sync.Mutex
+	modTime   time.Time
+	size      int64
+	addresses map[string]net.IP
+}
+
+// NewHosts returns the hosts file at the specified path. It isn't read until it's needed.
+func NewHosts(path string) *Hosts {
+	return &Hosts{path: path, addresses: map[string]net.IP{}}
+}
+
+// Lookup returns the first address the hosts file has for the specified name, or nil if it doesn't
+// have one. If the file can't be read, the hosts it had the last time it was read are used.
+func (h *Hosts) Lookup(name string) net.IP {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.reload()
+	return h.addresses[name]
+}
+
+// reload reads the hosts file again if its modification time or size has changed since it was
+// last read.
+func (h *Hosts) reload() {
+	info, err := os.Stat(h.path)
+	if os.IsNotExist(err) {
+		h.modTime, h.size, h.addresses = time.Time{}, 0, map[string]net.IP{}
+		return
+	} else if err != nil || (info.ModTime().Equal(h.modTime) && info.Size() == h.size) {
+		return
+	}
+
+	contents, err := os.ReadFile(h.path)
+	if err != nil {
+		return
+	}
+
+	h.modTime, h.size, h.addresses = info.ModTime(), info.Size(), parseHosts(contents)
+}
+
+// parseHosts parses the specified hosts file, skipping comments and any line that doesn't start
+// with an IP address. Names are lowercased, and the first address for each name wins.
+func parseHosts(contents []byte) map[string]net.IP {
+	addresses := make(map[string]net.IP)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if _, ok := addresses[name]; !ok {
+				addresses[name] = ip
+			}
+		}
+	}
+
+	return addresses
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hosts", func() {
+	var (
+		path  string
+		hosts *Hosts
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "containers")
+		hosts = NewHosts(path)
+	})
+
+	// write writes the specified contents to the hosts file, making sure its modification time
+	// changes even on file systems that only keep it to the second.
+	write := func(contents string) {
+		Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		later := time.Now().Add(time.Duration(len(contents)) * time.Second)
+		Expect(os.Chtimes(path, later, later)).To(Succeed())
+	}
+
+	It("has no hosts while the file doesn't exist", func() {
+		Expect(hosts.Lookup("db.docker")).To(BeNil())
+	})
+
+	It("looks up names, ignoring comments and case", func() {
+		write("# falcon's records\n172.17.0.2 DB.docker postgres.docker # postgres\nnot-an-ip cache.docker\n172.17.0.3 db.docker\n")
+
+		Expect(hosts.Lookup("db.docker")).To(Equal(net.ParseIP("172.17.0.2")))
+		Expect(hosts.Lookup("postgres.docker")).To(Equal(net.ParseIP("172.17.0.2")))
+		Expect(hosts.Lookup("cache.docker")).To(BeNil())
+	})
+
+	It("reads the file again when it changes", func() {
+		write("172.17.0.2 db.docker\n")
+		Expect(hosts.Lookup("db.docker")).To(Equal(net.ParseIP("172.17.0.2")))
+
+		write("172.17.0.10 db.docker\n")
+		Expect(hosts.Lookup("db.docker")).To(Equal(net.ParseIP("172.17.0.10")))
+
+		Expect(os.Remove(path)).To(Succeed())
+		Expect(hosts.Lookup("db.docker")).To(BeNil())
+	})
+})
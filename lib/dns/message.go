@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// The parts of the DNS wire format (RFC 1035) falcon needs to answer queries.
+const (
+	headerLength = 12
+
+	typeA    uint16 = 1
+	typeAAAA uint16 = 28
+	typeANY  uint16 = 255
+	classIN  uint16 = 1
+
+	flagResponse      uint16 = 1 << 15
+	flagAuthoritative uint16 = 1 << 10
+	flagRecursion     uint16 = 1 << 8
+	opcodeMask        uint16 = 0xf << 11
+
+	rcodeSuccess        uint16 = 0
+	rcodeFormatError    uint16 = 1
+	rcodeServerFailure  uint16 = 2
+	rcodeNameError      uint16 = 3
+	rcodeNotImplemented uint16 = 4
+
+	// maxLabels is the most labels a 255 byte name can have, which also stops a loop of compression
+	// pointers from being followed forever.
+	maxLabels = 128
+)
+
+var (
+	errTooShort = errors.New("the message is too short to be a DNS message")
+	errBadName  = errors.New("the message has a malformed name")
+)
+
+// header is the fixed-size header that starts every DNS message.
+type header struct {
+	ID      uint16
+	Flags   uint16
+	QDCount uint16
+	ANCount uint16
+	NSCount uint16
+	ARCount uint16
+}
+
+// opcode returns the kind of query the header describes. Only standard queries, opcode 0, are
+// answered.
+func (h header) opcode() uint16 {
+	return h.Flags & opcodeMask
+}
+
+func parseHeader(message []byte) (header, error) {
+	if len(message) < headerLength {
+		return header{}, errTooShort
+	}
+
+	return header{
+		ID:      binary.BigEndian.Uint16(message[0:]),
+		Flags:   binary.BigEndian.Uint16(message[2:]),
+		QDCount: binary.BigEndian.Uint16(message[4:]),
+		ANCount: binary.BigEndian.Uint16(message[6:]),
+		NSCount: binary.BigEndian.Uint16(message[8:]),
+		ARCount: binary.BigEndian.Uint16(message[10:]),
+	}, nil
+}
+
+func (h header) append(b []byte) []byte {
+	for _, field := range []uint16{h.ID, h.Flags, h.QDCount, h.ANCount, h.NSCount, h.ARCount} {
+		b = appendUint16(b, field)
+	}
+	return b
+}
+
+// question is the name and type of record a query asks for.
+type question struct {
+	// Name is the lowercased name being asked about, without a trailing dot, like "app.docker".
+	Name  string
+	Type  uint16
+	Class uint16
+	// raw is the question exactly as it appeared in the query, which is repeated in the response.
+	raw []byte
+}
+
+// parseQuestion parses the question that starts at the specified offset of the message.
+func parseQuestion(message []byte, offset int) (question, error) {
+	name, end, err := parseName(message, offset)
+	if err != nil {
+		return question{}, err
+	} else if end+4 > len(message) {
+		return question{}, errTooShort
+	}
+
+	return question{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(message[end:]),
+		Class: binary.BigEndian.Uint16(message[end+2:]),
+		raw:   message[offset : end+4],
+	}, nil
+}
+
+// parseName parses the name that starts at the specified offset of the message, following any
+// compression pointers, and returns it along with the offset just past it.
+func parseName(message []byte, offset int) (string, int, error) {
+	labels := make([]string, 0, 4)
+	end := -1
+
+	for i := 0; i < maxLabels; i++ {
+		if offset >= len(message) {
+			return "", 0, errBadName
+		}
+
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, nil
+		case length&0xc0 == 0xc0:
+			// A pointer to a name earlier in the message.
+			if offset+1 >= len(message) {
+				return "", 0, errBadName
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(message[offset:]) & 0x3fff)
+		case length&0xc0 != 0:
+			return "", 0, errBadName
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, errBadName
+			}
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+
+	return "", 0, errBadName
+}
+
+// resourceRecord is a single answer to a question. Its name always points back at the question,
+// which starts straight after the header.
+type resourceRecord struct {
+	Type uint16
+	TTL  uint32
+	Data []byte
+}
+
+func (r resourceRecord) append(b []byte) []byte {
+	b = appendUint16(b, 0xc000|headerLength)
+	b = appendUint16(b, r.Type)
+	b = appendUint16(b, classIN)
+	b = appendUint32(b, r.TTL)
+	b = appendUint16(b, uint16(len(r.Data)))
+	return append(b, r.Data...)
+}
+
+func appendUint16(b []byte, value uint16) []byte {
+	return append(b, byte(value>>8), byte(value))
+}
+
+func appendUint32(b []byte, value uint32) []byte {
+	return append(b, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Hawkbawk/falcon/lib/ready"
+)
+
+// Query sends a DNS query for the specified hostname straight to the DNS server at the specified
+// address, bypassing the host machine's resolver, and returns the addresses it answers with.
+func Query(ctx context.Context, server string, hostname string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+
+	return resolver.LookupHost(ctx, hostname)
+}
+
+// WaitUntilResolves waits, for up to the specified timeout, until the DNS server at the specified
+// address resolves the specified hostname to the specified address. The server is queried using
+// query, which is normally Query.
+func WaitUntilResolves(ctx context.Context, timeout time.Duration, server string, hostname string, address string, query func(ctx context.Context, server string, hostname string) ([]string, error)) error {
+	return ready.Wait(ctx, timeout, func(ctx context.Context) error {
+		addrs, err := query(ctx, server, hostname)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			if addr == address {
+				return nil
+			}
+		}
+		return fmt.Errorf("it resolved %v to %v instead of %v", hostname, strings.Join(addrs, ", "), address)
+	})
+}
@@ -0,0 +1,322 @@
+// The dns package is a small DNS server that can stand in for the dnsmasq container. It answers
+// A and AAAA queries for every domain under falcon's TLDs with a single address, over UDP and TCP,
+// unless a hosts file has an address of its own for the domain, and forwards everything else to
+// upstream servers, or answers that it doesn't exist.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long answers for falcon's domains can be cached. It's short so that changing the loopback
+// address takes effect straight away, like dnsmasq's answers for -A.
+const ttl = 0
+
+// How long we wait for an upstream server to answer a forwarded query, and for a TCP client to send
+// its next query before the connection is closed.
+const (
+	upstreamTimeout = 2 * time.Second
+	idleTimeout     = 10 * time.Second
+)
+
+// Server answers DNS queries for every domain under its TLDs with its address.
+type Server struct {
+	// TLDs are the top-level domains the server answers for, like "docker".
+	TLDs []string
+	// Address is the address every domain under the TLDs resolves to. It's an A record if it's an
+	// IPv4 address and an AAAA record otherwise.
+	Address net.IP
+	// Upstream are the servers, like "1.1.1.1:53", that queries for every other domain are
+	// forwarded to, in order, until one answers. If there aren't any, those domains don't exist.
+	Upstream []string
+	// Forwards send queries for particular domains to servers of their own instead.
+	Forwards []Forward
+	// Hosts has addresses for individual domains, which win over Address. It can be nil.
+	Hosts *Hosts
+}
+
+// Forward sends queries for a domain, and every domain under it, to its own servers, like
+// "10.8.0.1:53", in order, until one answers.
+type Forward struct {
+	Domain  string
+	Servers []string
+}
+
+// ListenAndServe answers queries sent to the specified address, like "127.0.0.1:53", over both UDP
+// and TCP until ctx is done.
+func (s Server) ListenAndServe(ctx context.Context, address string) error {
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+
+	return s.Serve(ctx, packetConn, listener)
+}
+
+// Serve answers queries received on the specified UDP connection and TCP listener until ctx is
+// done, and then closes them both. If either one stops working before then, the error is returned.
+func (s Server) Serve(ctx context.Context, packetConn net.PacketConn, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+	}()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.serveUDP(ctx, packetConn) }()
+	go func() { errs <- s.serveTCP(ctx, listener) }()
+
+	err := <-errs
+	cancel()
+	<-errs
+
+	if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s Server) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	for {
+		buffer := make([]byte, 65535)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if response := s.respond(ctx, "udp", buffer[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+func (s Server) serveTCP(ctx context.Context, listener net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers each query sent over the specified TCP connection, which are prefixed with
+// their length, until the client closes it or goes quiet.
+func (s Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn.SetDeadline(time.Now().Add(idleTimeout))
+
+		query, err := readTCP(conn)
+		if err != nil {
+			return
+		}
+
+		response := s.respond(ctx, "tcp", query)
+		if response == nil {
+			return
+		}
+		if _, err := conn.Write(withLength(response)); err != nil {
+			return
+		}
+	}
+}
+
+// respond builds the response to the specified query, which arrived over the specified network,
+// "udp" or "tcp". Messages that aren't queries at all don't get a response.
+func (s Server) respond(ctx context.Context, network string, query []byte) []byte {
+	h, err := parseHeader(query)
+	if err != nil || h.Flags&flagResponse != 0 {
+		return nil
+	}
+
+	if h.opcode() != 0 {
+		return reply(h, rcodeNotImplemented, nil, nil)
+	} else if h.QDCount != 1 {
+		return reply(h, rcodeFormatError, nil, nil)
+	}
+
+	q, err := parseQuestion(query, headerLength)
+	if err != nil {
+		return reply(h, rcodeFormatError, nil, nil)
+	}
+
+	if address := s.lookup(q.Name); address != nil {
+		h.Flags |= flagAuthoritative
+		return reply(h, rcodeSuccess, &q, answersFor(q, address))
+	}
+
+	upstreams := s.upstreams(q.Name)
+	if len(upstreams) == 0 {
+		return reply(h, rcodeNameError, &q, nil)
+	}
+
+	for _, upstream := range upstreams {
+		if response, err := exchange(ctx, network, upstream, query); err == nil {
+			return response
+		}
+	}
+	return reply(h, rcodeServerFailure, &q, nil)
+}
+
+// lookup returns the address the server answers with for the specified name: the hosts file's, if
+// it has one, or the server's address if the name is under one of the server's TLDs. Otherwise, it
+// returns nil.
+func (s Server) lookup(name string) net.IP {
+	if s.Hosts != nil {
+		if address := s.Hosts.Lookup(name); address != nil {
+			return address
+		}
+	}
+
+	for _, tld := range s.TLDs {
+		if name == tld || strings.HasSuffix(name, "."+tld) {
+			return s.Address
+		}
+	}
+
+	return nil
+}
+
+// upstreams returns the servers a query for the specified name is forwarded to: the servers of the
+// most specific forward for a domain the name is under, or the upstream servers if there isn't one.
+func (s Server) upstreams(name string) []string {
+	servers, longest := s.Upstream, -1
+
+	for _, forward := range s.Forwards {
+		if (name == forward.Domain || strings.HasSuffix(name, "."+forward.Domain)) && len(forward.Domain) > longest {
+			servers, longest = forward.Servers, len(forward.Domain)
+		}
+	}
+
+	return servers
+}
+
+// answersFor answers the specified question with the specified address. Questions about other types
+// of records get no answers, since the domain still exists.
+func answersFor(q question, address net.IP) []resourceRecord {
+	record := resourceRecord{Type: typeA, TTL: ttl, Data: address.To4()}
+	if record.Data == nil {
+		record = resourceRecord{Type: typeAAAA, TTL: ttl, Data: address.To16()}
+	}
+
+	answers := []resourceRecord{}
+	if q.Class == classIN && (q.Type == record.Type || q.Type == typeANY) {
+		answers = append(answers, record)
+	}
+
+	return answers
+}
+
+// reply builds a response to the query with the specified header, repeating its question, if
+// there is one, followed by the specified answers.
+func reply(h header, rcode uint16, q *question, answers []resourceRecord) []byte {
+	response := header{
+		ID:      h.ID,
+		Flags:   flagResponse | h.opcode() | h.Flags&(flagAuthoritative|flagRecursion) | rcode,
+		ANCount: uint16(len(answers)),
+	}
+	if q != nil {
+		response.QDCount = 1
+	}
+
+	b := response.append(make([]byte, 0, 512))
+	if q != nil {
+		b = append(b, q.raw...)
+	}
+	for _, answer := range answers {
+		b = answer.append(b)
+	}
+
+	return b
+}
+
+// exchange forwards the specified query to the specified upstream server over the specified
+// network, and returns its response.
+func exchange(ctx context.Context, network string, upstream string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		if _, err := conn.Write(withLength(query)); err != nil {
+			return nil, err
+		}
+		return readTCP(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 65535)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// readTCP reads a single message sent over TCP, which is prefixed with its length.
+func readTCP(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// withLength prefixes the specified message with its length, ready to be sent over TCP.
+func withLength(message []byte) []byte {
+	return append(appendUint16(make([]byte, 0, len(message)+2), uint16(len(message))), message...)
+}
@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// packet decodes a DNS message written as hex, ignoring the spaces used to split it into fields.
+func packet(fields string) []byte {
+	data, err := hex.DecodeString(strings.ReplaceAll(fields, " ", ""))
+	Expect(err).NotTo(HaveOccurred())
+	return data
+}
+
+// The question "app.docker", followed by its type and class.
+const appDocker = "03617070 06646f636b6572 00"
+
+// serve starts the specified server on ephemeral UDP and TCP ports of 127.0.0.1, returning their
+// addresses. The server is stopped once the spec finishes.
+func serve(server Server) (string, string) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Serve(ctx, packetConn, listener) }()
+
+	DeferCleanup(func() {
+		cancel()
+		Expect(<-done).To(Succeed())
+	})
+
+	return packetConn.LocalAddr().String(), listener.Addr().String()
+}
+
+var _ = Describe("Server", func() {
+	var server Server
+
+	BeforeEach(func() {
+		server = Server{TLDs: []string{"docker", "test"}, Address: net.ParseIP("192.168.40.1")}
+	})
+
+	Describe("respond", func() {
+		It("answers A queries for falcon's domains with the address", func() {
+			query := packet("1234 0100 0001 0000 0000 0000" + appDocker + "0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8500 0001 0001 0000 0000" + appDocker + "0001 0001" +
+					"c00c 0001 0001 00000000 0004 c0a82801")))
+		})
+
+		It("answers for the TLD itself, ignoring case", func() {
+			query := packet("0001 0000 0001 0000 0000 0000 04546553 54 00 0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"0001 8400 0001 0001 0000 0000 04546553 54 00 0001 0001" +
+					"c00c 0001 0001 00000000 0004 c0a82801")))
+		})
+
+		It("answers AAAA queries with an IPv6 address", func() {
+			server.Address = net.ParseIP("fd00::1")
+			query := packet("1234 0100 0001 0000 0000 0000" + appDocker + "001c 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8500 0001 0001 0000 0000" + appDocker + "001c 0001" +
+					"c00c 001c 0001 00000000 0010 fd000000000000000000000000000001")))
+		})
+
+		It("answers other types of queries for falcon's domains without any records", func() {
+			query := packet("1234 0100 0001 0000 0000 0000" + appDocker + "001c 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8500 0001 0000 0000 0000" + appDocker + "001c 0001")))
+		})
+
+		It("ignores the EDNS record that follows the question", func() {
+			query := packet("1234 0100 0001 0000 0000 0001" + appDocker + "0001 0001" +
+				"00 0029 1000 00000000 0000")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8500 0001 0001 0000 0000" + appDocker + "0001 0001" +
+					"c00c 0001 0001 00000000 0004 c0a82801")))
+		})
+
+		It("answers with the hosts file's address for a domain it has one for", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "containers")
+			Expect(os.WriteFile(path, []byte("172.17.0.2 app.docker\n"), 0644)).To(Succeed())
+			server.Hosts = NewHosts(path)
+			query := packet("1234 0100 0001 0000 0000 0000" + appDocker + "0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8500 0001 0001 0000 0000" + appDocker + "0001 0001" +
+					"c00c 0001 0001 00000000 0004 ac110002")))
+		})
+
+		It("says other domains don't exist when there's nowhere to forward them", func() {
+			query := packet("beef 0100 0001 0000 0000 0000 07 6578616d706c65 03 636f6d 00 0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"beef 8103 0001 0000 0000 0000 07 6578616d706c65 03 636f6d 00 0001 0001")))
+		})
+
+		It("rejects messages without exactly one question", func() {
+			query := packet("1234 0100 0002 0000 0000 0000" + appDocker + "0001 0001" + appDocker + "0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet("1234 8101 0000 0000 0000 0000")))
+		})
+
+		It("rejects names that point at themselves", func() {
+			query := packet("1234 0100 0001 0000 0000 0000 c00c 0001 0001")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet("1234 8101 0000 0000 0000 0000")))
+		})
+
+		It("rejects names that run past the end of the message", func() {
+			query := packet("1234 0100 0001 0000 0000 0000 0a 617070")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet("1234 8101 0000 0000 0000 0000")))
+		})
+
+		It("only implements standard queries", func() {
+			query := packet("1234 2800 0000 0000 0000 0000")
+
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet("1234 a804 0000 0000 0000 0000")))
+		})
+
+		It("doesn't respond to responses or messages that are too short", func() {
+			Expect(server.respond(context.Background(), "udp", packet("1234 8000 0000 0000 0000 0000"))).To(BeNil())
+			Expect(server.respond(context.Background(), "udp", packet("1234 0100"))).To(BeNil())
+		})
+
+		It("follows compressed names", func() {
+			name, end, err := parseName(packet("0000 0000 0000 0000 0000 0000 03617070 c012 06646f636b6572 00"), 12)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("app.docker"))
+			Expect(end).To(Equal(18))
+		})
+	})
+
+	Describe("Serve", func() {
+		It("answers queries over UDP", func() {
+			udp, _ := serve(server)
+
+			Expect(Query(context.Background(), udp, "api.app.docker")).To(Equal([]string{"192.168.40.1"}))
+		})
+
+		It("answers queries over TCP", func() {
+			_, tcp := serve(server)
+
+			conn, err := net.DialTimeout("tcp", tcp, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			query := packet("1234 0100 0001 0000 0000 0000" + appDocker + "0001 0001")
+			for i := 0; i < 2; i++ {
+				_, err = conn.Write(withLength(query))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readTCP(conn)).To(Equal(server.respond(context.Background(), "tcp", query)))
+			}
+		})
+
+		It("forwards other domains to the first upstream server that answers, and relays its answer", func() {
+			upstreamUDP, upstreamTCP := serve(Server{TLDs: []string{"example.com"}, Address: net.ParseIP("10.0.0.1")})
+			nowhere, _ := serve(Server{})
+			server.Upstream = []string{"127.0.0.1:1", upstreamUDP}
+			udp, _ := serve(server)
+
+			Expect(Query(context.Background(), udp, "www.example.com")).To(Equal([]string{"10.0.0.1"}))
+
+			server.Upstream = []string{upstreamTCP}
+			query := packet("1234 0100 0001 0000 0000 0000 03777777 07 6578616d706c65 03 636f6d 00 0001 0001")
+			Expect(server.respond(context.Background(), "tcp", query)).To(HaveLen(len(query) + 16))
+
+			server.Upstream = []string{nowhere}
+			Expect(server.respond(context.Background(), "udp", query)).To(Equal(packet(
+				"1234 8103 0001 0000 0000 0000 03777777 07 6578616d706c65 03 636f6d 00 0001 0001")))
+		})
+
+		It("forwards domains with servers of their own to those servers instead", func() {
+			corp, _ := serve(Server{TLDs: []string{"corp.example.com"}, Address: net.ParseIP("10.8.0.1")})
+			vpn, _ := serve(Server{TLDs: []string{"vpn.corp.example.com"}, Address: net.ParseIP("10.9.0.1")})
+			public, _ := serve(Server{TLDs: []string{"example.com"}, Address: net.ParseIP("10.0.0.1")})
+			server.Upstream = []string{public}
+			server.Forwards = []Forward{
+				{Domain: "corp.example.com", Servers: []string{corp}},
+				{Domain: "vpn.corp.example.com", Servers: []string{vpn}},
+			}
+			udp, _ := serve(server)
+
+			Expect(Query(context.Background(), udp, "wiki.corp.example.com")).To(Equal([]string{"10.8.0.1"}))
+			Expect(Query(context.Background(), udp, "gw.vpn.corp.example.com")).To(Equal([]string{"10.9.0.1"}))
+			Expect(Query(context.Background(), udp, "www.example.com")).To(Equal([]string{"10.0.0.1"}))
+			Expect(Query(context.Background(), udp, "app.docker")).To(Equal([]string{"192.168.40.1"}))
+		})
+
+		It("stops once ctx is done", func() {
+			packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(server.Serve(ctx, packetConn, listener)).To(Succeed())
+			_, err = listener.Accept()
+			Expect(err).To(MatchError(net.ErrClosed))
+		})
+	})
+})
@@ -1,61 +1,152 @@
 package dnsmasq
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
 
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dns"
 	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/listen"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
 )
 
-// The loopback address we use that makes us better than dory. It allows inter-container
-// communication to work by causing all request to *.docker to resolve to 192.168.40.1, which
-// containers send back out through the host networking to the falcon-proxy container, rather than
-// just sending all traffic to *.docker domains back to themselves.
-const LoopbackAddress = "192.168.40.1"
-
-// The name of the dnsmasq image we use.
-const dnsMasqImageName = "4km3/dnsmasq:2.85-r2"
-
-// The name of the dnsmasq container when it's running.
-const dnsMasqContainerName = "falcon-dnsmasq"
-
-var containerConfig *container.Config = &container.Config{
-	Image: dnsMasqImageName,
-	ExposedPorts: nat.PortSet{
-		"53/tcp": struct{}{},
-		"53/udp": struct{}{},
-	},
-	Cmd: []string{
+// ContainerName is the name of the dnsmasq container when it's running.
+const ContainerName = "falcon-dnsmasq"
+
+// Where the directory with the hosts file for falcon's records is mounted inside the container.
+const containerHostsDir = "/etc/falcon/hosts"
+
+// createContainerConfig creates the config for the dnsmasq container, which runs the specified image
+// and resolves every domain under the specified TLDs to the specified loopback address. If a hosts
+// directory is specified, dnsmasq also reads the hosts files in it, and reads them again whenever
+// they change. Their addresses win over the loopback address. Queries for every other domain go to
+// the specified upstream servers.
+func createContainerConfig(image string, tlds []string, loopbackAddress string, hostsDir string, upstream config.Upstream) *container.Config {
+	cmd := []string{
 		"--log-facility=-", "--listen-address=0.0.0.0",
 		"--interface=eth0", "--interface=docker0",
-		"-A", fmt.Sprintf("/docker/%v", LoopbackAddress)}, // Tells dnsmasq to forward all requests for *.docker domains to our special loopback address.
+		"-A", createAddressArg(tlds, loopbackAddress)} // Tells dnsmasq to forward all requests for our domains to our special loopback address.
+	if hostsDir != "" {
+		cmd = append(cmd, "--hostsdir="+containerHostsDir)
+	}
+	cmd = append(cmd, createServerArgs(upstream)...)
+
+	return &container.Config{
+		Image: image,
+		// Make sure Traefik never tries to route requests to dnsmasq, even with automatic hostnames.
+		Labels: map[string]string{"traefik.enable": "false"},
+		ExposedPorts: nat.PortSet{
+			"53/tcp": struct{}{},
+			"53/udp": struct{}{},
+		},
+		Cmd: cmd,
+	}
+}
+
+// createAddressArg creates dnsmasq's address argument for the specified TLDs and loopback address,
+// like "/docker/test/192.168.40.1".
+func createAddressArg(tlds []string, loopbackAddress string) string {
+	return fmt.Sprintf("/%v/%v", strings.Join(tlds, "/"), loopbackAddress)
+}
+
+// createServerArgs creates dnsmasq's arguments for the specified upstream servers. Each forwarded
+// domain gets a --server=/domain/server argument for each of its servers. If there are upstream
+// servers for every other domain, dnsmasq is told to use them rather than the servers in the
+// container's /etc/resolv.conf, which Docker copies from the host machine.
+func createServerArgs(upstream config.Upstream) []string {
+	args := make([]string, 0)
+
+	for _, forward := range upstream.Domains {
+		for _, server := range forward.Servers {
+			args = append(args, fmt.Sprintf("--server=/%v/%v", forward.Domain, serverArg(server)))
+		}
+	}
+
+	if len(upstream.Servers) > 0 {
+		args = append(args, "--no-resolv")
+	}
+	for _, server := range upstream.Servers {
+		args = append(args, "--server="+serverArg(server))
+	}
+
+	return args
 }
 
-var hostConfig *container.HostConfig = &container.HostConfig{
-	PortBindings: nat.PortMap{
-		"53/tcp": []nat.PortBinding{
-			{
-				HostIP:   "0.0.0.0",
-				HostPort: "53",
+// serverArg writes the specified upstream server the way dnsmasq expects it, with a # rather than a
+// colon before the port, like "10.0.0.53#5353". The port is left out when it's 53.
+func serverArg(server string) string {
+	ip, port, err := config.SplitServer(server)
+	if err != nil {
+		// The config has already been validated, so dnsmasq gets to reject anything odd.
+		return server
+	} else if port == 53 {
+		return ip
+	}
+
+	return fmt.Sprintf("%v#%v", ip, port)
+}
+
+// createHostConfig creates the host config for the dnsmasq container, which runs on the specified
+// network, mounts the specified hosts directory, if there is one, and publishes DNS over both TCP and
+// UDP on the specified addresses.
+func createHostConfig(network string, hostsDir string, addresses listen.Addresses) *container.HostConfig {
+	var binds []string
+	if hostsDir != "" {
+		binds = []string{fmt.Sprintf("%v:%v:ro", hostsDir, containerHostsDir)}
+	}
+
+	return &container.HostConfig{
+		Binds:       binds,
+		NetworkMode: container.NetworkMode(network),
+		PortBindings: nat.PortMap{
+			"53/tcp": []nat.PortBinding{
+				{
+					HostIP:   addresses.DNSIP,
+					HostPort: fmt.Sprint(addresses.DNSPort),
+				},
 			},
-		},
-		"53/udp": []nat.PortBinding{
-			{
-				HostIP:   "0.0.0.0",
-				HostPort: "53",
+			"53/udp": []nat.PortBinding{
+				{
+					HostIP:   addresses.DNSIP,
+					HostPort: fmt.Sprint(addresses.DNSPort),
+				},
 			},
 		},
-	},
-	CapAdd: []string{"NET_ADMIN"},
+		CapAdd: []string{"NET_ADMIN"},
+	}
 }
 
-// Starts our dnsmasq container.
-func Start(client docker.DockerClient) error {
-	return client.StartContainer(dnsMasqImageName, hostConfig, containerConfig, dnsMasqContainerName)
+// Starts our dnsmasq container, listening on the specified addresses. The records directory is
+// created first, so that Docker doesn't create it as root.
+func Start(ctx context.Context, client docker.DockerClient, cfg config.Config, addresses listen.Addresses, recreate bool) error {
+	hostsDir := cfg.Records.Dir
+	if cfg.Records.Containers == config.RecordsNone {
+		hostsDir = ""
+	} else if err := os.MkdirAll(hostsDir, 0755); err != nil {
+		return err
+	}
+
+	return client.StartContainer(ctx, cfg.Images.DNS, cfg.Pull, recreate, createHostConfig(cfg.Network.Name, hostsDir, addresses), createContainerConfig(cfg.Images.DNS, cfg.TLDs, cfg.LoopbackAddress, hostsDir, cfg.Upstream), ContainerName)
 }
 
 // Stops our dnsmasq container.
-func Stop(client docker.DockerClient) error {
-	return client.StopAndRemoveContainer(dnsMasqContainerName)
+func Stop(ctx context.Context, client docker.DockerClient) error {
+	return client.StopAndRemoveContainer(ctx, ContainerName)
+}
+
+// WaitUntilReady waits, for up to the configured ready-timeout, until the dnsmasq container at the
+// specified address resolves a hostname under the first configured TLD to the configured loopback
+// address. The container is queried using query, which is normally dns.Query.
+func WaitUntilReady(ctx context.Context, cfg config.Config, server string, query func(ctx context.Context, server string, hostname string) ([]string, error)) error {
+	hostname := fmt.Sprintf("%v.%v", ContainerName, cfg.TLDs[0])
+
+	err := dns.WaitUntilResolves(ctx, cfg.ReadyTimeout, server, hostname, cfg.LoopbackAddress, query)
+	if err != nil {
+		return fmt.Errorf("dnsmasq didn't answer DNS queries on %v: %v. Run docker logs %v to see why", server, err, ContainerName)
+	}
+	return nil
 }
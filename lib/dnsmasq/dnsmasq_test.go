@@ -1,9 +1,15 @@
 package dnsmasq
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/listen"
 	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -13,40 +19,184 @@ var _ = Describe("Dnsmasq", func() {
 	var (
 		ctrl       *gomock.Controller
 		mockClient *mock_docker.MockDockerClient
+		cfg        = config.Defaults()
+		addresses  = cfg.Addresses(cfg.LoopbackAddress)
 	)
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
 		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		cfg.Records.Dir = GinkgoT().TempDir()
 	})
 
 	Describe("Start", func() {
 		It("tries to start the dnsmasq container and returns no errors", func() {
-			mockClient.EXPECT().StartContainer(dnsMasqImageName, hostConfig, containerConfig, dnsMasqContainerName).Return(nil)
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultDNSImage, config.PullAlways, false, createHostConfig("falcon", cfg.Records.Dir, addresses), createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", cfg.Records.Dir, cfg.Upstream), ContainerName).Return(nil)
 
-			Expect(Start(mockClient)).Should(Succeed())
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).Should(Succeed())
 		})
 
 		It("returns an error if the container can't be started", func() {
 			err := fmt.Errorf("problems!")
-			mockClient.EXPECT().StartContainer(dnsMasqImageName, hostConfig, containerConfig, dnsMasqContainerName).Return(err)
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultDNSImage, config.PullAlways, false, createHostConfig("falcon", cfg.Records.Dir, addresses), createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", cfg.Records.Dir, cfg.Upstream), ContainerName).Return(err)
 
-			Expect(Start(mockClient)).Should(Equal(err))
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).Should(Equal(err))
+		})
+
+		It("uses the configured image", func() {
+			custom := cfg
+			custom.Images.DNS = "registry.example.com/dnsmasq:latest"
+			mockClient.EXPECT().StartContainer(context.Background(), custom.Images.DNS, config.PullAlways, false, createHostConfig("falcon", cfg.Records.Dir, addresses), createContainerConfig(custom.Images.DNS, []string{"docker"}, "192.168.40.1", cfg.Records.Dir, cfg.Upstream), ContainerName).Return(nil)
+
+			Expect(Start(context.Background(), mockClient, custom, addresses, false)).Should(Succeed())
+		})
+
+		It("uses the configured pull policy", func() {
+			offline := cfg
+			offline.Pull = "never"
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultDNSImage, config.PullNever, false, createHostConfig("falcon", cfg.Records.Dir, addresses), createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", cfg.Records.Dir, cfg.Upstream), ContainerName).Return(nil)
+
+			Expect(Start(context.Background(), mockClient, offline, addresses, false)).Should(Succeed())
+		})
+
+		It("doesn't mount the records directory with the none record policy", func() {
+			none := cfg
+			none.Records.Containers = config.RecordsNone
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultDNSImage, config.PullAlways, false, createHostConfig("falcon", "", addresses), createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", "", cfg.Upstream), ContainerName).Return(nil)
+
+			Expect(Start(context.Background(), mockClient, none, addresses, false)).Should(Succeed())
+		})
+
+		It("asks for the container to be recreated", func() {
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultDNSImage, config.PullAlways, true, createHostConfig("falcon", cfg.Records.Dir, addresses), createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", cfg.Records.Dir, cfg.Upstream), ContainerName).Return(nil)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, true)).Should(Succeed())
+		})
+	})
+
+	Describe("createContainerConfig", func() {
+		It("only reads hosts files when there's a hosts directory", func() {
+			Expect(createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", "", cfg.Upstream).Cmd).NotTo(ContainElement(HavePrefix("--hostsdir")))
+			Expect(createContainerConfig(config.DefaultDNSImage, []string{"docker"}, "192.168.40.1", "/home/me/.falcon/hosts", config.Upstream{}).Cmd).To(ContainElement("--hostsdir=/etc/falcon/hosts"))
+		})
+	})
+
+	Describe("createServerArgs", func() {
+		It("doesn't add any arguments without upstream servers", func() {
+			Expect(createServerArgs(config.Upstream{})).To(BeEmpty())
+		})
+
+		It("forwards each domain to its own servers and keeps the inherited servers", func() {
+			upstream := config.Upstream{Domains: []config.Forward{
+				{Domain: "corp.example.com", Servers: []string{"10.8.0.1", "10.8.0.2:5353"}},
+				{Domain: "internal", Servers: []string{"[fd00::53]:53"}},
+			}}
+
+			Expect(createServerArgs(upstream)).To(Equal([]string{
+				"--server=/corp.example.com/10.8.0.1",
+				"--server=/corp.example.com/10.8.0.2#5353",
+				"--server=/internal/fd00::53",
+			}))
+		})
+
+		It("uses the upstream servers instead of the inherited ones", func() {
+			upstream := config.Upstream{
+				Servers: []string{"1.1.1.1", "10.0.0.53:5353"},
+				Domains: []config.Forward{{Domain: "corp.example.com", Servers: []string{"10.8.0.1"}}},
+			}
+
+			Expect(createServerArgs(upstream)).To(Equal([]string{
+				"--server=/corp.example.com/10.8.0.1",
+				"--no-resolv",
+				"--server=1.1.1.1",
+				"--server=10.0.0.53#5353",
+			}))
+		})
+	})
+
+	Describe("createAddressArg", func() {
+		It("resolves every TLD to the loopback address", func() {
+			Expect(createAddressArg([]string{"docker", "test"}, "10.254.254.254")).To(Equal("/docker/test/10.254.254.254"))
+		})
+	})
+
+	Describe("createHostConfig", func() {
+		It("publishes DNS over TCP and UDP on the configured address", func() {
+			bindings := createHostConfig("falcon", "", listen.Addresses{DNSIP: "192.168.40.1", DNSPort: 5353}).PortBindings
+
+			for _, port := range []nat.Port{"53/tcp", "53/udp"} {
+				Expect(bindings[port][0].HostIP).To(Equal("192.168.40.1"))
+				Expect(bindings[port][0].HostPort).To(Equal("5353"))
+			}
+		})
+
+		It("mounts the hosts directory read-only, if there is one", func() {
+			Expect(createHostConfig("falcon", "", addresses).Binds).To(BeEmpty())
+			Expect(createHostConfig("falcon", "/home/me/.falcon/hosts", addresses).Binds).To(Equal([]string{"/home/me/.falcon/hosts:/etc/falcon/hosts:ro"}))
+		})
+
+		It("runs dnsmasq on the specified network", func() {
+			Expect(createHostConfig("dev", "", addresses).NetworkMode).To(Equal(container.NetworkMode("dev")))
 		})
 	})
 
 	Describe("Stop", func() {
 		It("tries to stop the dnsmasq container and returns no errors", func() {
-			mockClient.EXPECT().StopAndRemoveContainer(dnsMasqContainerName).Return(nil)
+			mockClient.EXPECT().StopAndRemoveContainer(context.Background(), ContainerName).Return(nil)
 
-			Expect(Stop(mockClient)).Should(Succeed())
+			Expect(Stop(context.Background(), mockClient)).Should(Succeed())
 		})
 
 		It("returns an error if the container can't be stopped", func() {
 			err := fmt.Errorf("problems!")
-			mockClient.EXPECT().StopAndRemoveContainer(dnsMasqContainerName).Return(err)
+			mockClient.EXPECT().StopAndRemoveContainer(context.Background(), ContainerName).Return(err)
+
+			Expect(Stop(context.Background(), mockClient)).Should(Equal(err))
+		})
+	})
+	Describe("WaitUntilReady", func() {
+		var readyCfg config.Config
+
+		BeforeEach(func() {
+			readyCfg = cfg
+			readyCfg.ReadyTimeout = 50 * time.Millisecond
+		})
+
+		It("returns once dnsmasq resolves falcon's domains to the loopback address", func() {
+			var queried []string
+			query := func(_ context.Context, server string, hostname string) ([]string, error) {
+				queried = append(queried, server, hostname)
+				if len(queried) < 4 {
+					return nil, fmt.Errorf("connection refused")
+				}
+				return []string{readyCfg.LoopbackAddress}, nil
+			}
+			readyCfg.ReadyTimeout = time.Minute
+
+			Expect(WaitUntilReady(context.Background(), readyCfg, addresses.DNS(), query)).To(Succeed())
+			Expect(queried).To(Equal([]string{addresses.DNS(), "falcon-dnsmasq.docker", addresses.DNS(), "falcon-dnsmasq.docker"}))
+		})
+
+		It("gives up if dnsmasq never answers", func() {
+			query := func(context.Context, string, string) ([]string, error) {
+				return nil, fmt.Errorf("connection refused")
+			}
+
+			err := WaitUntilReady(context.Background(), readyCfg, addresses.DNS(), query)
+
+			Expect(err).To(MatchError(ContainSubstring("dnsmasq didn't answer DNS queries on " + addresses.DNS())))
+			Expect(err).To(MatchError(ContainSubstring("still not ready after 50ms: connection refused")))
+			Expect(err).To(MatchError(HaveSuffix("Run docker logs falcon-dnsmasq to see why")))
+		})
+
+		It("gives up if dnsmasq resolves falcon's domains somewhere else", func() {
+			query := func(context.Context, string, string) ([]string, error) {
+				return []string{"10.0.0.1"}, nil
+			}
+
+			err := WaitUntilReady(context.Background(), readyCfg, addresses.DNS(), query)
 
-			Expect(Stop(mockClient)).Should(Equal(err))
+			Expect(err).To(MatchError(ContainSubstring("it resolved falcon-dnsmasq.docker to 10.0.0.1 instead of " + readyCfg.LoopbackAddress)))
 		})
 	})
 })
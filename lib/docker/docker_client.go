@@ -2,14 +2,26 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"io"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/docker/distribution/reference"
+
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -18,24 +30,80 @@ type DockerApi interface {
 	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
 	ContainerRestart(ctx context.Context, containerID string, timeout *time.Duration) error
 	ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
 	ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error
 	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *v1.Platform, containerName string) (container.ContainerCreateCreatedBody, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error)
+	NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+	NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+	NetworkRemove(ctx context.Context, networkID string) error
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
+	NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
 }
 
 type DockerClient interface {
 	// GetContainer finds the first container that matches the specified container name.
 	// If no match is found, then a nil container and nil error is returned. Note that this function only
 	// looks at containers that are in a running state.
-	GetContainer(containerName string) (*types.Container, error)
+	GetContainer(ctx context.Context, containerName string) (*types.Container, error)
+	// ListContainers returns every running container. If any errors are encountered, they're returned.
+	ListContainers(ctx context.Context) ([]types.Container, error)
 	// Stops and removes the first container that matches the provided container name.
 	// If no containers match, nothing happens. If any errors are encountered, they're returned.
-	StopAndRemoveContainer(containerName string) error
-	// Starts a container with the specified configuration. If any errors are encountered, they are returned.
-	StartContainer(imageName string, hostConfig *container.HostConfig, containerConfig *container.Config, containerName string) error
+	StopAndRemoveContainer(ctx context.Context, containerName string) error
+	// Starts a container with the specified configuration, pulling the image first if the pull
+	// policy calls for it. If the image is pinned by digest, like "hawkbawk/falcon-proxy@sha256:...",
+	// the image's digest is checked against it before the container is created. A container with
+	// the same name is reused if it was created with the same configuration, and is removed and
+	// created again if its configuration has changed or recreate is true. If any errors are
+	// encountered, they are returned.
+	StartContainer(ctx context.Context, imageName string, policy config.PullPolicy, recreate bool, hostConfig *container.HostConfig, containerConfig *container.Config, containerName string) error
+	// ServerVersion returns the version of the Docker server and the API version it's using. If the
+	// server can't be reached, an error is returned.
+	ServerVersion(ctx context.Context) (types.Version, error)
+	// ImageDigests returns the repo digests of the specified image, like
+	// "hawkbawk/falcon-proxy@sha256:...", which identify exactly what was pulled from the registry.
+	// Images that were built locally don't have any.
+	ImageDigests(ctx context.Context, image string) ([]string, error)
+	// EnsureNetwork creates a bridge network with the specified name and subnet, labelled as
+	// falcon's, unless a network with that name already exists. An empty subnet lets Docker pick
+	// one. If the existing network doesn't have the specified subnet, an error is returned.
+	EnsureNetwork(ctx context.Context, name string, subnet string) error
+	// RemoveNetwork disconnects every container from the network with the specified name and
+	// removes it. Networks falcon didn't create, or that don't exist, are left alone.
+	RemoveNetwork(ctx context.Context, name string) error
+	// ConnectContainer connects the container with the specified ID to the specified network.
+	ConnectContainer(ctx context.Context, network string, containerID string) error
+	// ContainerEvents streams events about containers, like one starting or dying, and about
+	// containers being connected to and disconnected from networks, until ctx is done. If the
+	// stream breaks, the error is sent on the error channel and the stream ends.
+	ContainerEvents(ctx context.Context) (<-chan events.Message, <-chan error)
 }
 
+// ConfigHashLabel is the label falcon stores a hash of each container's configuration in.
+const ConfigHashLabel = "falcon.config-hash"
+
+// How long we wait for the Docker server to remove a partially created container after a start was
+// cancelled.
+const cleanupTimeout = 10 * time.Second
+
 type dockerConsumer struct {
 	api DockerApi
+	// progress is where the progress of image pulls is written. It's rendered with a progress bar
+	// for each layer if it's a terminal.
+	progress io.Writer
+}
+
+// ContainerName returns the name of the specified container without Docker's leading slash, or its
+// ID if it doesn't have a name.
+func ContainerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return container.ID
+	}
+
+	return strings.TrimPrefix(container.Names[0], "/")
 }
 
 func NewDockerClient() (DockerClient, error) {
@@ -46,28 +114,37 @@ func NewDockerClient() (DockerClient, error) {
 	}
 
 	return dockerConsumer{
-		api: api,
+		api:      api,
+		progress: os.Stdout,
 	}, nil
 }
 
-func (dc dockerConsumer) GetContainer(containerName string) (*types.Container, error) {
-	ctx := context.Background()
-
+func (dc dockerConsumer) GetContainer(ctx context.Context, containerName string) (*types.Container, error) {
+	// The name filter also matches containers whose names only contain the specified name, like
+	// old-falcon-proxy, which mustn't be mistaken for ours, since they may be removed.
 	containers, err := dc.api.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})})
 
 	if err != nil {
 		return nil, err
-	} else if len(containers) == 0 {
-		return nil, nil
-	} else {
-		return &containers[0], nil
 	}
+
+	for i := range containers {
+		for _, name := range containers[i].Names {
+			if name == "/"+containerName {
+				return &containers[i], nil
+			}
+		}
+	}
+
+	return nil, nil
 }
 
-func (dc dockerConsumer) StopAndRemoveContainer(containerName string) error {
-	ctx := context.Background()
+func (dc dockerConsumer) ListContainers(ctx context.Context) ([]types.Container, error) {
+	return dc.api.ContainerList(ctx, types.ContainerListOptions{})
+}
 
-	container, err := dc.GetContainer(containerName)
+func (dc dockerConsumer) StopAndRemoveContainer(ctx context.Context, containerName string) error {
+	container, err := dc.GetContainer(ctx, containerName)
 
 	if err != nil {
 		return err
@@ -84,49 +161,185 @@ func (dc dockerConsumer) StopAndRemoveContainer(containerName string) error {
 	return nil
 }
 
-func (dc dockerConsumer) StartContainer(imageName string, hostConfig *container.HostConfig, containerConfig *container.Config, containerName string) error {
-	ctx := context.Background()
+func (dc dockerConsumer) StartContainer(ctx context.Context, imageName string, policy config.PullPolicy, recreate bool, hostConfig *container.HostConfig, containerConfig *container.Config, containerName string) error {
+	hash, err := configHash(imageName, hostConfig, containerConfig)
+	if err != nil {
+		return err
+	}
 
-	container, err := dc.GetContainer(containerName)
+	container, err := dc.GetContainer(ctx, containerName)
 
 	if err != nil {
 		return err
 	} else if container != (*types.Container)(nil) {
-		// I've been burned in the past by not checking whether a container already exists
-		// with our specified name and restarting it if it's not already running.
-		if container.Status != "running" {
-			if err := dc.api.ContainerRestart(ctx, container.ID, nil); err != nil {
-				return err
-			} else {
-				return nil
+		if !recreate && container.Labels[ConfigHashLabel] == hash {
+			// I've been burned in the past by not checking whether a container already exists
+			// with our specified name and restarting it if it's not already running.
+			if container.State != "running" {
+				return dc.api.ContainerRestart(ctx, container.ID, nil)
 			}
-		} else {
 			return nil
 		}
-	}
 
-	reader, err := dc.api.ImagePull(ctx, imageName, types.ImagePullOptions{})
+		// The container was created by an older falcon, or with different ports or images, so
+		// it has to be replaced to pick up its new configuration.
+		logger.LogInfo("Recreating %v, since its configuration has changed...", containerName)
+		if err := dc.api.ContainerRemove(ctx, container.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
+	if err := dc.ensureImage(ctx, imageName, policy); err != nil {
 		return err
 	}
 
-	// We have to write the stream of data from pulling the image, otherwise we
-	// won't actually pull the image.
-	defer reader.Close()
-	io.Copy(io.Discard, reader)
-
 	ref, err := dc.api.ContainerCreate(ctx,
-		containerConfig,
+		withLabel(containerConfig, ConfigHashLabel, hash),
 		hostConfig, &network.NetworkingConfig{},
 		nil, containerName)
 
 	if err != nil {
-		return err
+		return dc.removeCancelled(ctx, containerName, err)
 	}
 
 	if err := dc.api.ContainerStart(ctx, ref.ID, types.ContainerStartOptions{}); err != nil {
+		return dc.removeCancelled(ctx, containerName, err)
+	}
+	return nil
+}
+
+// configHash returns a hash of everything a container is created from, which is stored in its
+// ConfigHashLabel so that StartContainer can tell whether the container is out of date.
+func configHash(imageName string, hostConfig *container.HostConfig, containerConfig *container.Config) (string, error) {
+	desired, err := json.Marshal(struct {
+		Image      string
+		HostConfig *container.HostConfig
+		Config     *container.Config
+	}{imageName, hostConfig, containerConfig})
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(desired)), nil
+}
+
+// withLabel returns a copy of the specified container config with the specified label added, so
+// that the caller's config is left as it was.
+func withLabel(containerConfig *container.Config, key string, value string) *container.Config {
+	labelled := *containerConfig
+	labelled.Labels = make(map[string]string, len(containerConfig.Labels)+1)
+
+	for k, v := range containerConfig.Labels {
+		labelled.Labels[k] = v
+	}
+	labelled.Labels[key] = value
+
+	return &labelled
+}
+
+// removeCancelled removes the specified container if creating or starting it failed because ctx
+// was cancelled, so that an interrupted falcon up doesn't leave a container behind that was created
+// but never started. The Docker server may have created the container even though we stopped
+// waiting for it to, so it's removed by name. The error that stopped the start is returned.
+func (dc dockerConsumer) removeCancelled(ctx context.Context, containerName string, err error) error {
+	if ctx.Err() == nil {
 		return err
 	}
+
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	if removeErr := dc.api.ContainerRemove(cleanupCtx, containerName, types.ContainerRemoveOptions{Force: true}); removeErr != nil && !client.IsErrNotFound(removeErr) {
+		return fmt.Errorf("%v\nAdditionally, the partially created %v container couldn't be removed:\n%v", err, containerName, removeErr)
+	}
+
+	return err
+}
+
+// ensureImage makes sure the specified image is on the machine, pulling it if the pull policy calls
+// for it, and, if it's pinned by digest, that it's the image the digest describes.
+func (dc dockerConsumer) ensureImage(ctx context.Context, imageName string, policy config.PullPolicy) error {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return err
+	}
+	pinned, isPinned := named.(reference.Canonical)
+
+	image, _, err := dc.api.ImageInspectWithRaw(ctx, imageName)
+	present := err == nil
+
+	if err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+
+	switch {
+	case policy == config.PullNever && !present:
+		return fmt.Errorf("%v isn't on this machine yet, and falcon isn't allowed to pull it. Run falcon up with --pull if-not-present to pull it", imageName)
+	case policy == config.PullNever, policy == config.PullIfNotPresent && present, policy == config.PullAlways && present && isPinned:
+		// The image on the machine is the one we want.
+	default:
+		if err := dc.pullImage(ctx, imageName); err != nil {
+			return err
+		}
+		if image, _, err = dc.api.ImageInspectWithRaw(ctx, imageName); err != nil {
+			return err
+		}
+	}
+
+	if !isPinned {
+		return nil
+	}
+
+	for _, repoDigest := range image.RepoDigests {
+		if digested, err := reference.ParseNormalizedNamed(repoDigest); err == nil {
+			if canonical, ok := digested.(reference.Canonical); ok && canonical.Name() == pinned.Name() && canonical.Digest() == pinned.Digest() {
+				return nil
+			}
+		}
+	}
+
+	if len(image.RepoDigests) == 0 {
+		return fmt.Errorf("%v is pinned to a digest, but the image on this machine doesn't have one since it wasn't pulled from a registry", imageName)
+	}
+
+	return fmt.Errorf("%v doesn't match the digest it's pinned to. The image on this machine is %v", imageName, strings.Join(image.RepoDigests, ", "))
+}
+
+// pullImage pulls the specified image, rendering its progress as it goes. Docker reports errors
+// that happen partway through a pull, like a missing manifest, inside the stream of progress
+// messages rather than from ImagePull itself, so the stream has to be read to the end to find them.
+func (dc dockerConsumer) pullImage(ctx context.Context, imageName string) error {
+	reader, err := dc.api.ImagePull(ctx, imageName, types.ImagePullOptions{})
+
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	progress := dc.progress
+	if progress == nil {
+		progress = io.Discard
+	}
+	fd, isTerminal := term.GetFdInfo(progress)
+
+	if err := jsonmessage.DisplayJSONMessagesStream(reader, progress, fd, isTerminal, nil); err != nil {
+		return fmt.Errorf("unable to pull %v: %w", imageName, err)
+	}
+
 	return nil
 }
+
+func (dc dockerConsumer) ImageDigests(ctx context.Context, image string) ([]string, error) {
+	inspect, _, err := dc.api.ImageInspectWithRaw(ctx, image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return inspect.RepoDigests, nil
+}
+
+func (dc dockerConsumer) ServerVersion(ctx context.Context) (types.Version, error) {
+	return dc.api.ServerVersion(ctx)
+}
@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// ManagedLabel marks the networks falcon created, so that it never removes one it didn't.
+const ManagedLabel = "falcon.managed"
+
+func (dc dockerConsumer) EnsureNetwork(ctx context.Context, name string, subnet string) error {
+	existing, err := dc.findNetwork(ctx, name)
+
+	if err != nil {
+		return err
+	} else if existing != nil {
+		if subnet == "" || hasSubnet(*existing, subnet) {
+			return nil
+		}
+
+		remedy := fmt.Sprintf("Remove it with docker network rm %v, or change network.subnet to match", name)
+		if existing.Labels[ManagedLabel] == "true" {
+			remedy = "Run falcon down to remove it first"
+		}
+		return fmt.Errorf("the %v network already exists with the subnet %v instead of %v. %v", name, strings.Join(subnets(*existing), ", "), subnet, remedy)
+	}
+
+	options := types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         map[string]string{ManagedLabel: "true"},
+	}
+	if subnet != "" {
+		options.IPAM = &network.IPAM{Config: []network.IPAMConfig{{Subnet: subnet}}}
+	}
+
+	_, err = dc.api.NetworkCreate(ctx, name, options)
+	return err
+}
+
+func (dc dockerConsumer) RemoveNetwork(ctx context.Context, name string) error {
+	existing, err := dc.findNetwork(ctx, name)
+
+	if err != nil {
+		return err
+	} else if existing == nil || existing.Labels[ManagedLabel] != "true" {
+		return nil
+	}
+
+	// Docker refuses to remove a network that still has containers on it, and the list of networks
+	// doesn't say which containers those are.
+	inspected, err := dc.api.NetworkInspect(ctx, existing.ID, types.NetworkInspectOptions{})
+	if err != nil {
+		return err
+	}
+
+	for id := range inspected.Containers {
+		if err := dc.api.NetworkDisconnect(ctx, existing.ID, id, true); err != nil && !client.IsErrNotFound(err) {
+			return err
+		}
+	}
+
+	return dc.api.NetworkRemove(ctx, existing.ID)
+}
+
+func (dc dockerConsumer) ConnectContainer(ctx context.Context, networkName string, containerID string) error {
+	return dc.api.NetworkConnect(ctx, networkName, containerID, nil)
+}
+
+func (dc dockerConsumer) ContainerEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	return dc.api.Events(ctx, types.EventsOptions{Filters: filters.NewArgs(
+		filters.KeyValuePair{Key: "type", Value: events.ContainerEventType},
+		filters.KeyValuePair{Key: "type", Value: events.NetworkEventType},
+	)})
+}
+
+// findNetwork finds the network with exactly the specified name. If there isn't one, then a nil
+// network and nil error is returned.
+func (dc dockerConsumer) findNetwork(ctx context.Context, name string) (*types.NetworkResource, error) {
+	// The name filter also matches networks whose names only contain the specified name.
+	networks, err := dc.api.NetworkList(ctx, types.NetworkListOptions{Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: name})})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range networks {
+		if networks[i].Name == name {
+			return &networks[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// subnets lists the subnets of the specified network.
+func subnets(n types.NetworkResource) []string {
+	result := make([]string, 0, len(n.IPAM.Config))
+
+	for _, config := range n.IPAM.Config {
+		result = append(result, config.Subnet)
+	}
+
+	return result
+}
+
+func hasSubnet(n types.NetworkResource, subnet string) bool {
+	for _, s := range subnets(n) {
+		if s == subnet {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+)
+
+var _ = Describe("Docker networks", func() {
+	var (
+		ctrl        *gomock.Controller
+		mockApi     *mock_docker.MockDockerApi
+		client      DockerClient
+		networkName = "falcon"
+		listOptions = types.NetworkListOptions{Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: "falcon"})}
+		managed     = types.NetworkResource{
+			ID:     "net1",
+			Name:   "falcon",
+			Labels: map[string]string{ManagedLabel: "true"},
+			IPAM:   network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.30.0.0/24"}}},
+		}
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockApi = mock_docker.NewMockDockerApi(ctrl)
+		client = dockerConsumer{api: mockApi}
+	})
+
+	Describe("EnsureNetwork", func() {
+		It("creates a bridge network labelled as falcon's if there isn't one", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{{ID: "other", Name: "falcon-old"}}, nil)
+			mockApi.EXPECT().NetworkCreate(context.Background(), networkName, types.NetworkCreate{
+				CheckDuplicate: true,
+				Driver:         "bridge",
+				Labels:         map[string]string{ManagedLabel: "true"},
+			}).Return(types.NetworkCreateResponse{ID: "net1"}, nil)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "")).To(Succeed())
+		})
+
+		It("creates the network with the specified subnet", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return(nil, nil)
+			mockApi.EXPECT().NetworkCreate(context.Background(), networkName, types.NetworkCreate{
+				CheckDuplicate: true,
+				Driver:         "bridge",
+				IPAM:           &network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.30.0.0/24"}}},
+				Labels:         map[string]string{ManagedLabel: "true"},
+			}).Return(types.NetworkCreateResponse{ID: "net1"}, nil)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "172.30.0.0/24")).To(Succeed())
+		})
+
+		It("uses an existing network with the same name", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{managed}, nil).Times(2)
+			mockApi.EXPECT().NetworkCreate(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "")).To(Succeed())
+			Expect(client.EnsureNetwork(context.Background(), networkName, "172.30.0.0/24")).To(Succeed())
+		})
+
+		It("returns an error if the existing network has a different subnet", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{managed}, nil)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "10.1.0.0/16")).To(MatchError(
+				"the falcon network already exists with the subnet 172.30.0.0/24 instead of 10.1.0.0/16. Run falcon down to remove it first"))
+		})
+
+		It("explains how to remove an existing network falcon didn't create", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{{ID: "net2", Name: "falcon"}}, nil)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "10.1.0.0/16")).To(MatchError(
+				ContainSubstring("Remove it with docker network rm falcon, or change network.subnet to match")))
+		})
+
+		It("returns any error creating the network", func() {
+			err := fmt.Errorf("problems!")
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return(nil, nil)
+			mockApi.EXPECT().NetworkCreate(context.Background(), networkName, gomock.Any()).Return(types.NetworkCreateResponse{}, err)
+
+			Expect(client.EnsureNetwork(context.Background(), networkName, "")).To(Equal(err))
+		})
+	})
+
+	Describe("RemoveNetwork", func() {
+		It("disconnects every container before removing the network", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{managed}, nil)
+			mockApi.EXPECT().NetworkInspect(context.Background(), "net1", types.NetworkInspectOptions{}).Return(types.NetworkResource{
+				Containers: map[string]types.EndpointResource{"app": {Name: "app"}, "gone": {Name: "gone"}},
+			}, nil)
+			mockApi.EXPECT().NetworkDisconnect(context.Background(), "net1", "app", true).Return(nil)
+			mockApi.EXPECT().NetworkDisconnect(context.Background(), "net1", "gone", true).Return(errdefs.NotFound(fmt.Errorf("no such container")))
+			mockApi.EXPECT().NetworkRemove(context.Background(), "net1").Return(nil)
+
+			Expect(client.RemoveNetwork(context.Background(), networkName)).To(Succeed())
+		})
+
+		It("leaves networks falcon didn't create alone", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{{ID: "net2", Name: "falcon"}}, nil)
+			mockApi.EXPECT().NetworkRemove(gomock.Any(), gomock.Any()).Times(0)
+
+			Expect(client.RemoveNetwork(context.Background(), networkName)).To(Succeed())
+		})
+
+		It("does nothing if the network doesn't exist", func() {
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return(nil, nil)
+
+			Expect(client.RemoveNetwork(context.Background(), networkName)).To(Succeed())
+		})
+
+		It("returns any error removing the network", func() {
+			err := fmt.Errorf("problems!")
+			mockApi.EXPECT().NetworkList(context.Background(), listOptions).Return([]types.NetworkResource{managed}, nil)
+			mockApi.EXPECT().NetworkInspect(context.Background(), "net1", types.NetworkInspectOptions{}).Return(types.NetworkResource{}, nil)
+			mockApi.EXPECT().NetworkRemove(context.Background(), "net1").Return(err)
+
+			Expect(client.RemoveNetwork(context.Background(), networkName)).To(Equal(err))
+		})
+	})
+
+	Describe("ConnectContainer", func() {
+		It("connects the container to the network", func() {
+			mockApi.EXPECT().NetworkConnect(context.Background(), networkName, "abcd", nil).Return(nil)
+
+			Expect(client.ConnectContainer(context.Background(), networkName, "abcd")).To(Succeed())
+		})
+	})
+
+	Describe("ContainerEvents", func() {
+		It("only streams events about containers and networks", func() {
+			var messages <-chan events.Message = make(chan events.Message)
+			var errs <-chan error = make(chan error)
+			mockApi.EXPECT().Events(context.Background(), types.EventsOptions{Filters: filters.NewArgs(filters.KeyValuePair{Key: "type", Value: "container"}, filters.KeyValuePair{Key: "type", Value: "network"})}).Return(messages, errs)
+
+			gotMessages, gotErrs := client.ContainerEvents(context.Background())
+
+			Expect(gotMessages).To(Equal(messages))
+			Expect(gotErrs).To(Equal(errs))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,10 +11,13 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/Hawkbawk/falcon/lib/config"
 	"github.com/Hawkbawk/falcon/mocks/mock_docker"
 )
 
@@ -54,13 +58,13 @@ var _ = Describe("Docker", func() {
 			var containerList []types.Container
 
 			BeforeEach(func() {
-				containerList = []types.Container{{ID: containerId}}
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}}}
 			})
 
 			It("returns the containers id and no errors", func() {
 				MockContainerListWithValues(containerList, nil, mockApi, containerName)
 
-				result, err := client.GetContainer(containerName)
+				result, err := client.GetContainer(context.Background(), containerName)
 
 				Expect(err).NotTo(HaveOccurred())
 				Expect(result.ID).To(Equal(containerId))
@@ -70,7 +74,28 @@ var _ = Describe("Docker", func() {
 		Describe("the container doesn't exist", func() {
 			It("returns no id and no errors", func() {
 				MockContainerListWithValues(make([]types.Container, 0), nil, mockApi, containerName)
-				Expect(client.GetContainer(containerName)).Should(BeNil())
+				Expect(client.GetContainer(context.Background(), containerName)).Should(BeNil())
+			})
+
+			It("ignores containers whose names only contain the container's name", func() {
+				MockContainerListWithValues([]types.Container{
+					{ID: "old", Names: []string{"/old-" + containerName}},
+					{ID: "debug", Names: []string{"/" + containerName + "-debug"}},
+				}, nil, mockApi, containerName)
+				Expect(client.GetContainer(context.Background(), containerName)).Should(BeNil())
+			})
+		})
+
+		Describe("a container with a similar name exists too", func() {
+			It("returns the container with exactly the specified name", func() {
+				MockContainerListWithValues([]types.Container{
+					{ID: "old", Names: []string{"/old-" + containerName}},
+					{ID: containerId, Names: []string{"/" + containerName}},
+				}, nil, mockApi, containerName)
+
+				result, err := client.GetContainer(context.Background(), containerName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.ID).To(Equal(containerId))
 			})
 		})
 
@@ -78,26 +103,51 @@ var _ = Describe("Docker", func() {
 			It("returns no id and an error", func() {
 				MockContainerListWithValues(make([]types.Container, 0), fmt.Errorf("err"), mockApi, containerName)
 
-				result, err := client.GetContainer(containerName)
+				result, err := client.GetContainer(context.Background(), containerName)
 				Expect(err).Should(MatchError("err"))
 				Expect(result).Should(BeNil())
 			})
 		})
 	})
 
+	Describe("ContainerName", func() {
+		It("returns the container's name without the leading slash", func() {
+			Expect(ContainerName(types.Container{ID: "abc123", Names: []string{"/web"}})).To(Equal("web"))
+		})
+
+		It("returns the container's ID if it doesn't have a name", func() {
+			Expect(ContainerName(types.Container{ID: "abc123"})).To(Equal("abc123"))
+		})
+	})
+
+	Describe("ListContainers", func() {
+		It("returns only the running containers", func() {
+			containerList := []types.Container{{ID: containerId, Names: []string{"/" + containerName}}}
+			mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{}).Return(containerList, nil)
+
+			Expect(client.ListContainers(context.Background())).To(Equal(containerList))
+		})
+
+		It("returns any error from the client", func() {
+			mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{}).Return(nil, fmt.Errorf("err"))
+
+			Expect(client.ListContainers(context.Background())).Error().To(HaveOccurred())
+		})
+	})
+
 	Describe("StopAndRemoveContainer", func() {
 		Describe("the container exists", func() {
 			var containerList []types.Container
 
 			BeforeEach(func() {
-				containerList = []types.Container{{ID: containerId}}
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}}}
 			})
 
 			It("removes the container and returns no errors", func() {
 				MockContainerListWithValues(containerList, nil, mockApi, containerName)
 				MockContainerRemoveWithError(containerId, nil, mockApi)
 
-				Expect(client.StopAndRemoveContainer(containerName)).Should(Succeed())
+				Expect(client.StopAndRemoveContainer(context.Background(), containerName)).Should(Succeed())
 			})
 
 			Describe("the container removal throws an error", func() {
@@ -105,7 +155,7 @@ var _ = Describe("Docker", func() {
 					MockContainerListWithValues(containerList, nil, mockApi, containerName)
 					MockContainerRemoveWithError(containerId, fmt.Errorf("err"), mockApi)
 
-					Expect(client.StopAndRemoveContainer(containerName)).Should(MatchError("err"))
+					Expect(client.StopAndRemoveContainer(context.Background(), containerName)).Should(MatchError("err"))
 				})
 			})
 		})
@@ -114,7 +164,7 @@ var _ = Describe("Docker", func() {
 			It("doesn't try and remove the container and doesn't error", func() {
 				MockContainerListWithValues(make([]types.Container, 0), nil, mockApi, containerName)
 				ExpectContainerRemoveNotBeCalled(containerId, mockApi)
-				Expect(client.StopAndRemoveContainer(containerName)).Should(Succeed())
+				Expect(client.StopAndRemoveContainer(context.Background(), containerName)).Should(Succeed())
 			})
 		})
 
@@ -122,26 +172,38 @@ var _ = Describe("Docker", func() {
 			It("doesn't try and remove the container and returns an error", func() {
 				MockContainerListWithValues(make([]types.Container, 0), fmt.Errorf("err"), mockApi, containerName)
 				ExpectContainerRemoveNotBeCalled(containerId, mockApi)
-				Expect(client.StopAndRemoveContainer(containerName)).Should(MatchError("err"))
+				Expect(client.StopAndRemoveContainer(context.Background(), containerName)).Should(MatchError("err"))
 			})
 		})
 	})
 	Describe("StartContainer", func() {
 		var (
 			containerList []types.Container
-			imageName     = "imageName"
+			imageName     = "hawkbawk/falcon-proxy"
 		)
 
+		hash := func(image string) string {
+			h, err := configHash(image, &container.HostConfig{}, &container.Config{})
+			Expect(err).NotTo(HaveOccurred())
+			return h
+		}
+
+		// labelled returns the config StartContainer creates a container from the specified image
+		// with, given an empty config.
+		labelled := func(image string) *container.Config {
+			return &container.Config{Labels: map[string]string{ConfigHashLabel: hash(image)}}
+		}
+
 		Describe("the specified container isn't running", func() {
 			BeforeEach(func() {
-				containerList = []types.Container{{ID: containerId, Status: "stopped"}}
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}, State: "exited", Status: "Exited (0) 2 hours ago", Labels: map[string]string{ConfigHashLabel: hash(imageName)}}}
 			})
 
 			It("tries to restart the container", func() {
 				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
 				mockApi.EXPECT().ContainerRestart(context.Background(), containerId, nil).Return(nil)
 
-				Expect(client.StartContainer(imageName, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
+				Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
 			})
 
 			It("returns the error any errors it encounters when restarting the container", func() {
@@ -149,20 +211,59 @@ var _ = Describe("Docker", func() {
 				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
 				mockApi.EXPECT().ContainerRestart(context.Background(), containerId, nil).Return(err)
 
-				Expect(client.StartContainer(imageName, &container.HostConfig{}, &container.Config{}, containerName)).Should(Equal(err))
+				Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, &container.HostConfig{}, &container.Config{}, containerName)).Should(Equal(err))
 			})
 		})
 
 		Describe("the specified container is running", func() {
 			BeforeEach(func() {
-				containerList = []types.Container{{ID: containerId, Status: "running"}}
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}, State: "running", Status: "Up 2 hours", Labels: map[string]string{ConfigHashLabel: hash(imageName)}}}
 			})
 
 			It("doesn't try and do anything", func() {
 				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
 				mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Times(0)
 
-				Expect(client.StartContainer(imageName, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
+				Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
+			})
+		})
+
+		Describe("the specified container's configuration has changed", func() {
+			BeforeEach(func() {
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}, State: "running", Status: "Up 2 hours", Labels: map[string]string{ConfigHashLabel: hash("hawkbawk/falcon-proxy:old")}}}
+				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+			})
+
+			It("removes the container and creates it again", func() {
+				gomock.InOrder(
+					mockApi.EXPECT().ContainerRemove(context.Background(), containerId, types.ContainerRemoveOptions{Force: true}).Return(nil),
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil),
+					mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), &container.HostConfig{}, &network.NetworkingConfig{}, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: "efgh"}, nil),
+					mockApi.EXPECT().ContainerStart(context.Background(), "efgh", types.ContainerStartOptions{}).Return(nil),
+				)
+
+				Expect(client.StartContainer(context.Background(), imageName, config.PullIfNotPresent, false, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
+			})
+
+			It("returns an error if it can't remove the old container", func() {
+				err := fmt.Errorf("problems!")
+				mockApi.EXPECT().ContainerRemove(context.Background(), containerId, types.ContainerRemoveOptions{Force: true}).Return(err)
+				mockApi.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+				Expect(client.StartContainer(context.Background(), imageName, config.PullIfNotPresent, false, &container.HostConfig{}, &container.Config{}, containerName)).Should(Equal(err))
+			})
+		})
+
+		Describe("recreating is forced", func() {
+			It("recreates the container even though its configuration hasn't changed", func() {
+				containerList = []types.Container{{ID: containerId, Names: []string{"/" + containerName}, State: "running", Status: "Up 2 hours", Labels: map[string]string{ConfigHashLabel: hash(imageName)}}}
+				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+				mockApi.EXPECT().ContainerRemove(context.Background(), containerId, types.ContainerRemoveOptions{Force: true}).Return(nil)
+				mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil)
+				mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), &container.HostConfig{}, &network.NetworkingConfig{}, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: "efgh"}, nil)
+				mockApi.EXPECT().ContainerStart(context.Background(), "efgh", types.ContainerStartOptions{}).Return(nil)
+
+				Expect(client.StartContainer(context.Background(), imageName, config.PullIfNotPresent, true, &container.HostConfig{}, &container.Config{}, containerName)).Should(Succeed())
 			})
 		})
 
@@ -176,16 +277,35 @@ var _ = Describe("Docker", func() {
 
 			BeforeEach(func() {
 				containerList = []types.Container{}
-				readCloser = io.NopCloser(strings.NewReader("testing"))
+				readCloser = io.NopCloser(strings.NewReader(`{"status":"Pull complete","id":"abcd"}`))
 			})
 
 			It("tries to pull the image, create the container, and then start it", func() {
 				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+				mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil).Times(2)
 				mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil)
-				mockApi.EXPECT().ContainerCreate(context.Background(), containerConfig, hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+				mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
 				mockApi.EXPECT().ContainerStart(context.Background(), containerId, types.ContainerStartOptions{}).Return(nil)
 
-				Expect(client.StartContainer(imageName, hostConfig, containerConfig, containerName)).Should(Succeed())
+				Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+			})
+
+			It("writes the progress of the pull", func() {
+				progress := &bytes.Buffer{}
+				client = dockerConsumer{api: mockApi, progress: progress}
+				readCloser = io.NopCloser(strings.NewReader(`{"status":"Pulling from hawkbawk/falcon-proxy","id":"latest"}
+{"status":"Downloading","progressDetail":{"current":50,"total":100},"id":"abcd"}
+{"status":"Pull complete","id":"abcd"}
+`))
+				mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+				mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil).Times(2)
+				mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil)
+				mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+				mockApi.EXPECT().ContainerStart(context.Background(), containerId, types.ContainerStartOptions{}).Return(nil)
+
+				Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+				Expect(progress.String()).To(ContainSubstring("latest: Pulling from hawkbawk/falcon-proxy"))
+				Expect(progress.String()).To(ContainSubstring("abcd: Pull complete"))
 			})
 
 			Describe("error conditions", func () {
@@ -194,33 +314,189 @@ var _ = Describe("Docker", func() {
 				It("returns an error if it can't list containers", func() {
 					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(nil, err)
 
-					Expect(client.StartContainer(imageName, hostConfig, containerConfig, containerName)).Should(Equal(err))
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Equal(err))
+				})
+
+				It("returns an error if it can't inspect the image", func() {
+					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, err)
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Equal(err))
 				})
 
 				It("returns an error if it can't pull the image", func() {
 					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil)
 					mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, err)
 
-					Expect(client.StartContainer(imageName, hostConfig, containerConfig, containerName)).Should(Equal(err))
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Equal(err))
+				})
+
+				It("returns the error Docker reports partway through the pull", func() {
+					readCloser = io.NopCloser(strings.NewReader(`{"status":"Pulling from hawkbawk/falcon-proxy","id":"latest"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}
+`))
+					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil)
+					mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil)
+					mockApi.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(MatchError("unable to pull hawkbawk/falcon-proxy: manifest unknown"))
 				})
 
 				It("returns an error if it can't create the container", func() {
 					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil).Times(2)
 					mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil)
-					mockApi.EXPECT().ContainerCreate(context.Background(), containerConfig, hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{}, err)
+					mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{}, err)
 
-					Expect(client.StartContainer(imageName, hostConfig, containerConfig, containerName)).Should(Equal(err))
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Equal(err))
 				})
 
 				It("returns an error if it can't start the container", func() {
 					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil).Times(2)
 					mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil)
-					mockApi.EXPECT().ContainerCreate(context.Background(), containerConfig, hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+					mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
 					mockApi.EXPECT().ContainerStart(context.Background(), containerId, types.ContainerStartOptions{}).Return(err)
 
-					Expect(client.StartContainer(imageName, hostConfig, containerConfig, containerName)).Should(Equal(err))
+					Expect(client.StartContainer(context.Background(), imageName, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Equal(err))
+				})
+			})
+
+			Describe("cancelled", func() {
+				var (
+					ctx    context.Context
+					cancel context.CancelFunc
+				)
+
+				BeforeEach(func() {
+					ctx, cancel = context.WithCancel(context.Background())
+					DeferCleanup(cancel)
+
+					mockApi.EXPECT().ContainerList(ctx, gomock.Any()).Return(containerList, nil)
+					mockApi.EXPECT().ImageInspectWithRaw(ctx, imageName).Return(types.ImageInspect{}, nil, nil)
+					mockApi.EXPECT().ContainerCreate(ctx, labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+					mockApi.EXPECT().ContainerStart(ctx, containerId, types.ContainerStartOptions{}).DoAndReturn(func(context.Context, string, types.ContainerStartOptions) error {
+						cancel()
+						return context.Canceled
+					})
+				})
+
+				It("removes the container it created", func() {
+					mockApi.EXPECT().ContainerRemove(gomock.Not(ctx), containerName, types.ContainerRemoveOptions{Force: true}).Return(nil)
+
+					Expect(client.StartContainer(ctx, imageName, config.PullIfNotPresent, false, hostConfig, containerConfig, containerName)).Should(MatchError(context.Canceled))
+				})
+
+				It("reports a container it couldn't remove", func() {
+					mockApi.EXPECT().ContainerRemove(gomock.Any(), containerName, types.ContainerRemoveOptions{Force: true}).Return(fmt.Errorf("problems!"))
+
+					Expect(client.StartContainer(ctx, imageName, config.PullIfNotPresent, false, hostConfig, containerConfig, containerName)).Should(MatchError(ContainSubstring("couldn't be removed")))
+				})
+			})
+
+			Describe("pull policies", func() {
+				notFound := errdefs.NotFound(fmt.Errorf("no such image"))
+
+				BeforeEach(func() {
+					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+				})
+
+				expectStart := func() {
+					mockApi.EXPECT().ContainerCreate(context.Background(), labelled(imageName), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+					mockApi.EXPECT().ContainerStart(context.Background(), containerId, types.ContainerStartOptions{}).Return(nil)
+				}
+
+				It("doesn't pull an image that's already on this machine if the policy is if-not-present", func() {
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil)
+					mockApi.EXPECT().ImagePull(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+					expectStart()
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullIfNotPresent, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+				})
+
+				It("pulls a missing image if the policy is if-not-present", func() {
+					gomock.InOrder(
+						mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, notFound),
+						mockApi.EXPECT().ImagePull(context.Background(), imageName, types.ImagePullOptions{}).Return(readCloser, nil),
+						mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil),
+					)
+					expectStart()
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullIfNotPresent, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+				})
+
+				It("uses the image on this machine without pulling it if the policy is never", func() {
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, nil)
+					mockApi.EXPECT().ImagePull(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+					expectStart()
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullNever, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+				})
+
+				It("returns an error if the image is missing and the policy is never", func() {
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), imageName).Return(types.ImageInspect{}, nil, notFound)
+					mockApi.EXPECT().ImagePull(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+					Expect(client.StartContainer(context.Background(), imageName, config.PullNever, false, hostConfig, containerConfig, containerName)).Should(MatchError(ContainSubstring("isn't on this machine yet")))
+				})
+			})
+
+			Describe("pinned by digest", func() {
+				digest := "sha256:" + strings.Repeat("a", 64)
+				pinned := imageName + "@" + digest
+
+				BeforeEach(func() {
+					mockApi.EXPECT().ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: containerName})}).Return(containerList, nil)
+				})
+
+				It("doesn't pull the image again if it's already on this machine", func() {
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), pinned).Return(types.ImageInspect{RepoDigests: []string{"docker.io/" + pinned}}, nil, nil)
+					mockApi.EXPECT().ImagePull(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+					mockApi.EXPECT().ContainerCreate(context.Background(), labelled(pinned), hostConfig, networkingConfig, nil, containerName).Return(container.ContainerCreateCreatedBody{ID: containerId}, nil)
+					mockApi.EXPECT().ContainerStart(context.Background(), containerId, types.ContainerStartOptions{}).Return(nil)
+
+					Expect(client.StartContainer(context.Background(), pinned, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(Succeed())
+				})
+
+				It("returns an error if the image has a different digest", func() {
+					other := imageName + "@sha256:" + strings.Repeat("b", 64)
+					mockApi.EXPECT().ImageInspectWithRaw(context.Background(), pinned).Return(types.ImageInspect{RepoDigests: []string{other}}, nil, nil)
+					mockApi.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+					Expect(client.StartContainer(context.Background(), pinned, config.PullAlways, false, hostConfig, containerConfig, containerName)).Should(MatchError(ContainSubstring("doesn't match the digest it's pinned to")))
 				})
 			})
 		})
 	})
+
+	Describe("configHash", func() {
+		It("changes when the image, host config or container config does", func() {
+			hostConfig := &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": []nat.PortBinding{{HostIP: "192.168.40.1", HostPort: "80"}}}}
+			containerConfig := &container.Config{Image: "hawkbawk/falcon-proxy", Cmd: []string{"--api.insecure=true"}}
+			original, err := configHash("hawkbawk/falcon-proxy", hostConfig, containerConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(configHash("hawkbawk/falcon-proxy", hostConfig, containerConfig)).To(Equal(original))
+			Expect(configHash("hawkbawk/falcon-proxy:2", hostConfig, containerConfig)).NotTo(Equal(original))
+			Expect(configHash("hawkbawk/falcon-proxy", &container.HostConfig{}, containerConfig)).NotTo(Equal(original))
+			Expect(configHash("hawkbawk/falcon-proxy", hostConfig, &container.Config{Image: "hawkbawk/falcon-proxy"})).NotTo(Equal(original))
+		})
+	})
+
+	Describe("ImageDigests", func() {
+		It("returns the image's repo digests", func() {
+			digests := []string{"hawkbawk/falcon-proxy@sha256:" + strings.Repeat("a", 64)}
+			mockApi.EXPECT().ImageInspectWithRaw(context.Background(), "sha256:abcd").Return(types.ImageInspect{RepoDigests: digests}, nil, nil)
+
+			Expect(client.ImageDigests(context.Background(), "sha256:abcd")).To(Equal(digests))
+		})
+
+		It("returns an error if the image can't be inspected", func() {
+			mockApi.EXPECT().ImageInspectWithRaw(context.Background(), "sha256:abcd").Return(types.ImageInspect{}, nil, fmt.Errorf("problems!"))
+
+			Expect(client.ImageDigests(context.Background(), "sha256:abcd")).Error().To(MatchError("problems!"))
+		})
+	})
 })
@@ -0,0 +1,315 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dns"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/networking"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/docker/docker/api/types/versions"
+)
+
+// The oldest Docker API version falcon is known to work with (Docker 20.10). The proxy container
+// maps host.docker.internal to host-gateway, which older versions refuse to create containers with.
+const minimumAPIVersion = "1.41"
+
+// How long we wait for dnsmasq or the proxy to answer before giving up.
+const timeout = 2 * time.Second
+
+// What uses the DNS ports once falcon is up when dns-server is builtin, since there's no container.
+const builtinDNSServer = "falcon's DNS server"
+
+// A port falcon needs, and the container, or falcon's DNS server, that uses it once falcon is up.
+type port struct {
+	network string
+	ip      string
+	number  int
+	owner   string
+}
+
+// ports returns every port falcon needs to listen on the specified addresses with the specified
+// DNS server.
+func ports(addresses listen.Addresses, dnsServer config.DNSServer) []port {
+	dnsOwner := dnsmasq.ContainerName
+	if dnsServer == config.DNSServerBuiltin {
+		dnsOwner = builtinDNSServer
+	}
+
+	return []port{
+		{network: "udp", ip: addresses.DNSIP, number: addresses.DNSPort, owner: dnsOwner},
+		{network: "tcp", ip: addresses.DNSIP, number: addresses.DNSPort, owner: dnsOwner},
+		{network: "tcp", ip: addresses.ProxyIP, number: addresses.HTTPPort, owner: proxy.ContainerName},
+		{network: "tcp", ip: addresses.ProxyIP, number: addresses.HTTPSPort, owner: proxy.ContainerName},
+	}
+}
+
+// Environment is everything the checks need to inspect the host machine. It's a struct of
+// functions so that the checks can be tested without a real Docker server or network.
+type Environment struct {
+	Client  docker.DockerClient
+	Backend networking.Backend
+	// Config is the config falcon was set up with.
+	Config config.Config
+	// Addresses are where the proxy and dnsmasq listen on the host machine.
+	Addresses listen.Addresses
+	// Listen tries to listen on the specified network and address, closing the listener
+	// immediately if it succeeds.
+	Listen func(network string, address string) error
+	// LookPath finds the specified program on the PATH.
+	LookPath func(program string) (string, error)
+	// Output runs the specified program and returns what it prints.
+	Output func(program string, args ...string) (string, error)
+	// Exists reports whether the specified file exists.
+	Exists func(path string) bool
+	// Conflicts returns an error if one of the host's networks already uses the specified address.
+	Conflicts func(address string) error
+	// QueryDNS asks dnsmasq or falcon's DNS server directly for the addresses of the specified hostname.
+	QueryDNS func(hostname string) ([]string, error)
+	// Get makes an HTTP request to the specified host through the proxy, returning the status code.
+	Get func(host string) (int, error)
+}
+
+// NewEnvironment creates an environment that inspects the real host machine.
+func NewEnvironment(client docker.DockerClient, backend networking.Backend, cfg config.Config, addresses listen.Addresses) Environment {
+	return Environment{
+		Client:    client,
+		Backend:   backend,
+		Config:    cfg,
+		Addresses: addresses,
+		Listen:    tryListen,
+		LookPath:  exec.LookPath,
+		Output: func(program string, args ...string) (string, error) {
+			output, err := exec.Command(program, args...).Output()
+			return string(output), err
+		},
+		Exists: func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		},
+		Conflicts: loopback.Conflicts,
+		QueryDNS:  func(hostname string) ([]string, error) { return queryDNS(addresses.DNS(), hostname) },
+		Get:       func(host string) (int, error) { return get(addresses.HTTP(), host) },
+	}
+}
+
+// Checks returns every check doctor runs, in the order they're run. The checks that talk to Docker
+// give up once ctx is done.
+func Checks(ctx context.Context, env Environment) []Check {
+	return []Check{
+		{Name: "docker", Run: func() []Result { return []Result{checkDocker(ctx, env)} }},
+		{Name: "ports", Run: func() []Result { return checkPorts(ctx, env) }},
+		{Name: "mkcert", Run: func() []Result { return checkMkcert(env) }},
+		{Name: "loopback", Run: func() []Result { return []Result{checkLoopback(env)} }},
+		{Name: "networking", Run: func() []Result { return []Result{checkNetworking(env)} }},
+		{Name: "dns", Run: func() []Result { return []Result{checkDNS(env)} }},
+		{Name: "proxy", Run: func() []Result { return []Result{checkProxy(env)} }},
+	}
+}
+
+// checkDocker checks that the Docker server can be reached and is new enough.
+func checkDocker(ctx context.Context, env Environment) Result {
+	version, err := env.Client.ServerVersion(ctx)
+
+	if err != nil {
+		return fail("docker", fmt.Sprintf("unable to reach the Docker server: %v", err),
+			"Start Docker, and make sure DOCKER_HOST points at it if you aren't using the default socket.")
+	} else if versions.LessThan(version.APIVersion, minimumAPIVersion) {
+		return warn("docker", fmt.Sprintf("Docker %v uses API version %v, which is older than %v", version.Version, version.APIVersion, minimumAPIVersion),
+			"Upgrade Docker to 20.10 or newer.")
+	}
+
+	return pass("docker", fmt.Sprintf("Docker %v (API %v) is reachable", version.Version, version.APIVersion))
+}
+
+// checkPorts checks that each port falcon needs is either free or already in use by falcon.
+func checkPorts(ctx context.Context, env Environment) []Result {
+	results := make([]Result, 0, 4)
+	running := make(map[string]bool)
+
+	for _, p := range ports(env.Addresses, env.Config.DNSServer) {
+		name := fmt.Sprintf("port %v/%v", p.number, p.network)
+
+		if _, checked := running[p.owner]; !checked {
+			running[p.owner] = isRunning(ctx, env, p.owner)
+		}
+
+		if running[p.owner] {
+			results = append(results, pass(name, fmt.Sprintf("in use by %v", p.owner)))
+			continue
+		}
+
+		err := env.Listen(p.network, net.JoinHostPort(p.ip, fmt.Sprint(p.number)))
+
+		if errors.Is(err, syscall.EADDRINUSE) {
+			results = append(results, fail(name, "already in use by another program",
+				fmt.Sprintf("Stop whatever is using port %v (sudo lsof -i :%v will tell you what it is) and run falcon up again.", p.number, p.number)))
+		} else if errors.Is(err, syscall.EADDRNOTAVAIL) {
+			results = append(results, warn(name, fmt.Sprintf("unable to tell whether it's free, since %v isn't on this machine yet", p.ip),
+				"Run falcon up to add the loopback address, then run falcon doctor again."))
+		} else if err != nil {
+			results = append(results, warn(name, fmt.Sprintf("unable to tell whether it's free: %v", err),
+				fmt.Sprintf("Run falcon doctor with sudo to check port %v.", p.number)))
+		} else {
+			results = append(results, pass(name, "free"))
+		}
+	}
+
+	return results
+}
+
+// isRunning reports whether the specified container, or falcon's DNS server, is running. falcon's
+// DNS server isn't a container, so it counts as running if it answers a DNS query at all.
+func isRunning(ctx context.Context, env Environment, owner string) bool {
+	if owner == builtinDNSServer {
+		_, err := env.QueryDNS(proxy.DashboardHostname(env.Config.TLDs[0]))
+		return err == nil
+	}
+
+	container, err := env.Client.GetContainer(ctx, owner)
+	return err == nil && container != nil && container.State == "running"
+}
+
+// checkMkcert checks that mkcert is installed and its CA has been created, which falcon tls needs.
+func checkMkcert(env Environment) []Result {
+	if _, err := env.LookPath("mkcert"); err != nil {
+		return []Result{warn("mkcert", "mkcert isn't on your PATH, so falcon tls won't work",
+			"Install mkcert: https://github.com/FiloSottile/mkcert#installation")}
+	}
+
+	caRoot, err := env.Output("mkcert", "-CAROOT")
+
+	if err != nil {
+		return []Result{warn("mkcert CA", fmt.Sprintf("unable to find mkcert's CA: %v", err), "Run mkcert -install.")}
+	} else if !env.Exists(filepath.Join(strings.TrimSpace(caRoot), "rootCA.pem")) {
+		return []Result{warn("mkcert CA", "mkcert's CA hasn't been installed, so browsers won't trust falcon's certificates",
+			"Run mkcert -install.")}
+	}
+
+	return []Result{pass("mkcert", "installed"), pass("mkcert CA", "installed")}
+}
+
+// checkLoopback checks that falcon's loopback address is valid and isn't used by any of the host's
+// networks, which happens when a VPN routes the same range to a real network.
+func checkLoopback(env Environment) Result {
+	address := env.Config.LoopbackAddress
+
+	if err := loopback.Validate(address); err != nil {
+		return fail("loopback address", err.Error(), "Set loopback-address in ~/.falcon.yaml to a private IPv4 address.")
+	} else if err := env.Conflicts(address); err != nil {
+		return fail("loopback address", err.Error(), "Run falcon down, change loopback-address in ~/.falcon.yaml and run falcon up again.")
+	}
+
+	return pass("loopback address", fmt.Sprintf("%v isn't used by any of your networks", address))
+}
+
+// checkNetworking checks that the resolver and loopback address set up by falcon up are in place.
+func checkNetworking(env Environment) Result {
+	name := fmt.Sprintf("%v networking", env.Backend.Name())
+	problems, err := env.Backend.Status(env.Config)
+
+	if err != nil {
+		return warn(name, fmt.Sprintf("unable to check networking: %v", err), "")
+	} else if len(problems) > 0 {
+		return fail(name, strings.Join(problems, "; "),
+			"Run falcon up again. OS updates sometimes remove the resolver or loopback address.")
+	}
+
+	return pass(name, "resolver and loopback address are in place")
+}
+
+// checkDNS checks that dnsmasq, or falcon's DNS server, answers queries for falcon's domains with
+// falcon's loopback address. We ask for the dashboard's hostname, since it's always routed by falcon.
+func checkDNS(env Environment) Result {
+	server, start, restart := "dnsmasq", "Run falcon up to start the falcon-dnsmasq container.",
+		"Run falcon down and then falcon up to recreate the falcon-dnsmasq container."
+	if env.Config.DNSServer == config.DNSServerBuiltin {
+		server, start, restart = builtinDNSServer, "Run falcon up to start falcon's DNS server.",
+			"Run falcon down and then falcon up to restart falcon's DNS server."
+	}
+
+	probeHostname := proxy.DashboardHostname(env.Config.TLDs[0])
+	addrs, err := env.QueryDNS(probeHostname)
+
+	if err != nil {
+		return fail("dns", fmt.Sprintf("%v didn't answer a query for %v: %v", server, probeHostname, err), start)
+	}
+
+	for _, addr := range addrs {
+		if addr == env.Config.LoopbackAddress {
+			return pass("dns", fmt.Sprintf("%v resolves %v to %v", server, probeHostname, addr))
+		}
+	}
+
+	return fail("dns", fmt.Sprintf("%v resolves %v to %v instead of %v", server, probeHostname, addrs, env.Config.LoopbackAddress), restart)
+}
+
+// checkProxy checks that the proxy answers HTTP requests. Any response at all means the proxy is
+// up, even an error, since it's Traefik's job to decide what each request gets back.
+func checkProxy(env Environment) Result {
+	status, err := env.Get(proxy.DashboardHostname(env.Config.TLDs[0]))
+
+	if err != nil {
+		return fail("proxy", fmt.Sprintf("the proxy didn't answer on %v: %v", env.Addresses.HTTP(), err),
+			fmt.Sprintf("Run falcon up to start the falcon-proxy container, and make sure nothing else is using port %v.", env.Addresses.HTTPPort))
+	}
+
+	return pass("proxy", fmt.Sprintf("the proxy answered with HTTP %v", status))
+}
+
+// tryListen checks whether we can listen on the specified address.
+func tryListen(network string, address string) error {
+	if network == "udp" {
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// queryDNS sends a DNS query for the specified hostname straight to dnsmasq or falcon's DNS server
+// at the specified address, bypassing the host machine's resolver.
+func queryDNS(server string, hostname string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return dns.Query(ctx, server, hostname)
+}
+
+// get makes an HTTP request for the specified host to the proxy at the specified address.
+func get(address string, host string) (int, error) {
+	request, err := http.NewRequest(http.MethodGet, "http://"+address+"/", nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Host = host
+
+	response, err := (&http.Client{Timeout: timeout}).Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	return response.StatusCode, nil
+}
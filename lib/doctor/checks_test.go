@@ -0,0 +1,257 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBackend is a networking backend whose status is whatever the test says it is.
+type fakeBackend struct {
+	problems []string
+}
+
+func (fakeBackend) Name() string                             { return "fake" }
+func (fakeBackend) Detect() (bool, error)                    { return true, nil }
+func (fakeBackend) Steps(config.Config) []journal.Step       { return nil }
+func (b fakeBackend) Status(config.Config) ([]string, error) { return b.problems, nil }
+
+var _ = Describe("Checks", func() {
+	var (
+		ctrl       *gomock.Controller
+		mockClient *mock_docker.MockDockerClient
+		env        Environment
+		listening  map[string]error
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		listening = make(map[string]error)
+		cfg := config.Defaults()
+		env = Environment{
+			Client:    mockClient,
+			Backend:   fakeBackend{problems: []string{}},
+			Config:    cfg,
+			Addresses: cfg.Addresses(cfg.LoopbackAddress),
+			Listen:    func(network, address string) error { return listening[network+address] },
+			LookPath:  func(program string) (string, error) { return "/usr/bin/" + program, nil },
+			Output:    func(string, ...string) (string, error) { return "/home/me/.mkcert\n", nil },
+			Exists:    func(path string) bool { return path == "/home/me/.mkcert/rootCA.pem" },
+			Conflicts: func(string) error { return nil },
+			QueryDNS:  func(string) ([]string, error) { return []string{"192.168.40.1"}, nil },
+			Get:       func(string) (int, error) { return 404, nil },
+		}
+	})
+
+	levels := func(results []Result) []Level {
+		result := make([]Level, 0, len(results))
+		for _, r := range results {
+			result = append(result, r.Level)
+		}
+		return result
+	}
+
+	Describe("checkDocker", func() {
+		It("passes if the server is reachable and new enough", func() {
+			mockClient.EXPECT().ServerVersion(context.Background()).Return(types.Version{Version: "20.10.7", APIVersion: "1.41"}, nil)
+
+			Expect(checkDocker(context.Background(), env).Level).To(Equal(Passed))
+		})
+
+		It("warns if the server is too old", func() {
+			mockClient.EXPECT().ServerVersion(context.Background()).Return(types.Version{Version: "18.09", APIVersion: "1.39"}, nil)
+
+			Expect(checkDocker(context.Background(), env).Level).To(Equal(Warning))
+		})
+
+		It("warns about Docker 19.03, which doesn't support host-gateway", func() {
+			mockClient.EXPECT().ServerVersion(context.Background()).Return(types.Version{Version: "19.03.15", APIVersion: "1.40"}, nil)
+
+			result := checkDocker(context.Background(), env)
+			Expect(result.Level).To(Equal(Warning))
+			Expect(result.Fix).To(ContainSubstring("20.10"))
+		})
+
+		It("fails if the server can't be reached", func() {
+			mockClient.EXPECT().ServerVersion(context.Background()).Return(types.Version{}, fmt.Errorf("no socket"))
+
+			result := checkDocker(context.Background(), env)
+			Expect(result.Level).To(Equal(Failure))
+			Expect(result.Fix).NotTo(BeEmpty())
+		})
+	})
+
+	Describe("checkPorts", func() {
+		It("passes for ports that are free or used by falcon's own containers", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), dnsmasq.ContainerName).Return(nil, nil)
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(&types.Container{State: "running"}, nil)
+			listening["tcp192.168.40.1:80"] = syscall.EADDRINUSE
+
+			Expect(levels(checkPorts(context.Background(), env))).To(Equal([]Level{Passed, Passed, Passed, Passed}))
+		})
+
+		It("fails for ports used by something else", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), gomock.Any()).Return(nil, nil).Times(2)
+			listening["udp192.168.40.1:53"] = fmt.Errorf("listen udp :53: %w", syscall.EADDRINUSE)
+
+			results := checkPorts(context.Background(), env)
+			Expect(levels(results)).To(Equal([]Level{Failure, Passed, Passed, Passed}))
+			Expect(results[0].Fix).To(ContainSubstring("lsof -i :53"))
+		})
+
+		It("warns when it isn't allowed to check a port", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), gomock.Any()).Return(nil, nil).Times(2)
+			listening["tcp192.168.40.1:443"] = syscall.EACCES
+
+			Expect(levels(checkPorts(context.Background(), env))).To(Equal([]Level{Passed, Passed, Passed, Warning}))
+		})
+
+		It("checks the configured addresses and ports", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), gomock.Any()).Return(nil, nil).Times(2)
+			env.Addresses.ProxyIP = "127.0.0.1"
+			env.Addresses.HTTPPort = 8080
+			listening["tcp127.0.0.1:8080"] = syscall.EADDRINUSE
+
+			results := checkPorts(context.Background(), env)
+			Expect(levels(results)).To(Equal([]Level{Passed, Passed, Failure, Passed}))
+			Expect(results[2].Check).To(Equal("port 8080/tcp"))
+		})
+
+		It("passes for the DNS ports when falcon's DNS server answers on them", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(nil, nil)
+			env.Config.DNSServer = config.DNSServerBuiltin
+			listening["udp192.168.40.1:53"] = syscall.EADDRINUSE
+			listening["tcp192.168.40.1:53"] = syscall.EADDRINUSE
+
+			results := checkPorts(context.Background(), env)
+			Expect(levels(results)).To(Equal([]Level{Passed, Passed, Passed, Passed}))
+			Expect(results[0].Message).To(ContainSubstring("falcon's DNS server"))
+		})
+
+		It("warns when the loopback address hasn't been added yet", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), gomock.Any()).Return(nil, nil).Times(2)
+			listening["udp192.168.40.1:53"] = syscall.EADDRNOTAVAIL
+
+			results := checkPorts(context.Background(), env)
+			Expect(levels(results)).To(Equal([]Level{Warning, Passed, Passed, Passed}))
+			Expect(results[0].Fix).To(ContainSubstring("Run falcon up"))
+		})
+	})
+
+	Describe("checkMkcert", func() {
+		It("passes if mkcert and its CA are installed", func() {
+			Expect(levels(checkMkcert(env))).To(Equal([]Level{Passed, Passed}))
+		})
+
+		It("warns if mkcert isn't on the PATH", func() {
+			env.LookPath = func(string) (string, error) { return "", fmt.Errorf("not found") }
+
+			results := checkMkcert(env)
+			Expect(levels(results)).To(Equal([]Level{Warning}))
+			Expect(results[0].Fix).To(ContainSubstring("mkcert#installation"))
+		})
+
+		It("warns if the CA hasn't been installed", func() {
+			env.Exists = func(string) bool { return false }
+
+			results := checkMkcert(env)
+			Expect(levels(results)).To(Equal([]Level{Warning}))
+			Expect(results[0].Fix).To(Equal("Run mkcert -install."))
+		})
+	})
+
+	Describe("checkLoopback", func() {
+		It("passes if the address is valid and unused", func() {
+			Expect(checkLoopback(env).Level).To(Equal(Passed))
+		})
+
+		It("fails if the address isn't a private address", func() {
+			env.Config.LoopbackAddress = "127.0.0.2"
+
+			Expect(checkLoopback(env).Level).To(Equal(Failure))
+		})
+
+		It("fails if one of the host's networks uses the address", func() {
+			env.Conflicts = func(string) error { return fmt.Errorf("192.168.40.1 is inside 192.168.40.0/24") }
+
+			result := checkLoopback(env)
+			Expect(result.Level).To(Equal(Failure))
+			Expect(result.Message).To(ContainSubstring("192.168.40.0/24"))
+		})
+	})
+
+	Describe("checkNetworking", func() {
+		It("fails with every problem the backend found", func() {
+			env.Backend = fakeBackend{problems: []string{"resolver is missing", "loopback is missing"}}
+
+			result := checkNetworking(env)
+			Expect(result.Level).To(Equal(Failure))
+			Expect(result.Message).To(Equal("resolver is missing; loopback is missing"))
+		})
+
+		It("passes if nothing is missing", func() {
+			Expect(checkNetworking(env).Level).To(Equal(Passed))
+		})
+	})
+
+	Describe("checkDNS", func() {
+		It("passes if dnsmasq answers with the loopback address", func() {
+			Expect(checkDNS(env).Level).To(Equal(Passed))
+		})
+
+		It("asks for the dashboard under the primary TLD", func() {
+			env.Config.TLDs = []string{"test"}
+			env.QueryDNS = func(hostname string) ([]string, error) {
+				Expect(hostname).To(Equal("traefik.test"))
+				return []string{env.Config.LoopbackAddress}, nil
+			}
+
+			Expect(checkDNS(env).Level).To(Equal(Passed))
+		})
+
+		It("fails if dnsmasq doesn't answer", func() {
+			env.QueryDNS = func(string) ([]string, error) { return nil, fmt.Errorf("i/o timeout") }
+
+			Expect(checkDNS(env).Level).To(Equal(Failure))
+		})
+
+		It("names falcon's DNS server when it's the one asked", func() {
+			env.Config.DNSServer = config.DNSServerBuiltin
+			env.QueryDNS = func(string) ([]string, error) { return nil, fmt.Errorf("i/o timeout") }
+
+			result := checkDNS(env)
+			Expect(result.Level).To(Equal(Failure))
+			Expect(result.Message).To(ContainSubstring("falcon's DNS server"))
+			Expect(result.Fix).To(ContainSubstring("falcon's DNS server"))
+		})
+
+		It("fails if dnsmasq answers with the wrong address", func() {
+			env.QueryDNS = func(string) ([]string, error) { return []string{"10.0.0.1"}, nil }
+
+			Expect(checkDNS(env).Level).To(Equal(Failure))
+		})
+	})
+
+	Describe("checkProxy", func() {
+		It("passes if the proxy answers with any status", func() {
+			Expect(checkProxy(env).Level).To(Equal(Passed))
+		})
+
+		It("fails if the proxy doesn't answer", func() {
+			env.Get = func(string) (int, error) { return 0, fmt.Errorf("connection refused") }
+
+			Expect(checkProxy(env).Level).To(Equal(Failure))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+// The doctor package runs a set of checks that find the common reasons falcon stops working, like
+// another program using one of falcon's ports, and explains how to fix each one.
+package doctor
+
+// Level describes how serious the result of a check is.
+type Level string
+
+const (
+	// Passed means the check found nothing wrong.
+	Passed Level = "pass"
+	// Warning means the check found something that might break part of falcon, or couldn't tell.
+	Warning Level = "warn"
+	// Failure means the check found something that's stopping falcon from working.
+	Failure Level = "fail"
+)
+
+// Result is the outcome of running a single check.
+type Result struct {
+	Check   string `json:"check"`
+	Level   Level  `json:"level"`
+	Message string `json:"message"`
+	// Fix suggests how to fix the problem the check found. It's empty when the check passes.
+	Fix string `json:"fix,omitempty"`
+}
+
+// Check is a single diagnostic that doctor runs.
+type Check struct {
+	// Name uniquely identifies the check in the report.
+	Name string
+	// Run performs the check, returning one result for each thing it checked.
+	Run func() []Result
+}
+
+// Run runs each of the specified checks in order and returns all of their results.
+func Run(checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+
+	for _, check := range checks {
+		results = append(results, check.Run()...)
+	}
+
+	return results
+}
+
+// Failed reports whether any of the specified results failed.
+func Failed(results []Result) bool {
+	for _, result := range results {
+		if result.Level == Failure {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pass(check string, message string) Result {
+	return Result{Check: check, Level: Passed, Message: message}
+}
+
+func warn(check string, message string, fix string) Result {
+	return Result{Check: check, Level: Warning, Message: message, Fix: fix}
+}
+
+func fail(check string, message string, fix string) Result {
+	return Result{Check: check, Level: Failure, Message: message, Fix: fix}
+}
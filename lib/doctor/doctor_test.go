@@ -0,0 +1,33 @@
+package doctor
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Doctor", func() {
+	Describe("Run", func() {
+		It("runs every check in order and collects their results", func() {
+			checks := []Check{
+				{Name: "a", Run: func() []Result { return []Result{pass("a", "fine")} }},
+				{Name: "b", Run: func() []Result { return []Result{warn("b1", "hmm", "fix b1"), fail("b2", "broken", "fix b2")} }},
+			}
+
+			Expect(Run(checks)).To(Equal([]Result{
+				{Check: "a", Level: Passed, Message: "fine"},
+				{Check: "b1", Level: Warning, Message: "hmm", Fix: "fix b1"},
+				{Check: "b2", Level: Failure, Message: "broken", Fix: "fix b2"},
+			}))
+		})
+	})
+
+	Describe("Failed", func() {
+		It("is false if nothing failed", func() {
+			Expect(Failed([]Result{pass("a", ""), warn("b", "", "")})).To(BeFalse())
+		})
+
+		It("is true if anything failed", func() {
+			Expect(Failed([]Result{pass("a", ""), fail("b", "", "")})).To(BeTrue())
+		})
+	})
+})
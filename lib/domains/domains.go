@@ -0,0 +1,74 @@
+// The domains package works out which top-level domains falcon points at the proxy, like the
+// "docker" in "app.docker".
+package domains
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultTLD is the top-level domain falcon uses when none are configured.
+const DefaultTLD = "docker"
+
+// A top-level domain has to be a single valid DNS label.
+var tldRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Normalize lowercases each of the specified TLDs, strips any dots around them and removes
+// duplicates. If there aren't any left, the default TLD is returned.
+func Normalize(tlds []string) []string {
+	normalized := make([]string, 0, len(tlds))
+	seen := make(map[string]bool)
+
+	for _, tld := range tlds {
+		tld = strings.Trim(strings.ToLower(strings.TrimSpace(tld)), ".")
+
+		if tld != "" && !seen[tld] {
+			seen[tld] = true
+			normalized = append(normalized, tld)
+		}
+	}
+
+	if len(normalized) == 0 {
+		return []string{DefaultTLD}
+	}
+
+	return normalized
+}
+
+// Validate returns an error if any of the specified TLDs isn't a valid top-level domain.
+func Validate(tlds []string) error {
+	for _, tld := range tlds {
+		if !tldRegex.MatchString(tld) {
+			return fmt.Errorf("%q isn't a valid top-level domain. Use only letters, numbers and dashes, like \"docker\" or \"test\"", tld)
+		}
+	}
+
+	return nil
+}
+
+// Hostnames returns the hostname for the specified name under each of the specified TLDs.
+func Hostnames(name string, tlds []string) []string {
+	hostnames := make([]string, 0, len(tlds))
+
+	for _, tld := range tlds {
+		hostnames = append(hostnames, fmt.Sprintf("%v.%v", name, tld))
+	}
+
+	return hostnames
+}
+
+// Same reports whether the two lists of TLDs are the same, in the same order.
+func Same(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,45 @@
+package domains
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Domains", func() {
+	Describe("Normalize", func() {
+		It("lowercases, strips dots and removes duplicates", func() {
+			Expect(Normalize([]string{" .Test", "docker.", "test"})).To(Equal([]string{"test", "docker"}))
+		})
+
+		It("falls back to the default TLD", func() {
+			Expect(Normalize(nil)).To(Equal([]string{DefaultTLD}))
+			Expect(Normalize([]string{"", "."})).To(Equal([]string{DefaultTLD}))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts single DNS labels", func() {
+			Expect(Validate([]string{"docker", "test", "localhost", "my-tld2"})).To(Succeed())
+		})
+
+		It("rejects anything else", func() {
+			Expect(Validate([]string{"docker", "co.uk"})).To(MatchError(ContainSubstring(`"co.uk"`)))
+			Expect(Validate([]string{"-docker"})).NotTo(Succeed())
+			Expect(Validate([]string{"dock_er"})).NotTo(Succeed())
+		})
+	})
+
+	Describe("Hostnames", func() {
+		It("returns the name under each TLD", func() {
+			Expect(Hostnames("traefik", []string{"docker", "test"})).To(Equal([]string{"traefik.docker", "traefik.test"}))
+		})
+	})
+
+	Describe("Same", func() {
+		It("compares the TLDs in order", func() {
+			Expect(Same([]string{"docker"}, []string{"docker"})).To(BeTrue())
+			Expect(Same([]string{"docker", "test"}, []string{"test", "docker"})).To(BeFalse())
+			Expect(Same([]string{"docker"}, []string{"docker", "test"})).To(BeFalse())
+		})
+	})
+})
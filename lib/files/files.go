@@ -1,118 +1,147 @@
+// The files package contains the small set of file operations falcon needs to make to the host
+// machine, hidden behind an interface so that they can be pointed at a temporary directory when
+// testing.
 package files
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/shell"
 )
 
-// OpenFile opens the file at the specified location. If it encounters any errors, it logs them
-// and ends the program.
-func OpenFile(path string) *os.File {
-	file, err := os.Open(path)
-
-	if err != nil {
-		logger.LogError("Unable to open file %v.\n See the following error for more details: %v",
-			path, err.Error())
-	}
+// FileSystem describes every file operation falcon performs on the host machine. All paths are
+// absolute paths on the host, e.g. "/etc/resolv.conf".
+type FileSystem interface {
+	// ReadFile reads the entire contents of the file at the specified path.
+	ReadFile(path string) ([]byte, error)
+	// WriteFile replaces the contents of the file at the specified path, creating the file and
+	// any missing parent directories if necessary.
+	WriteFile(path string, data []byte) error
+	// Rename moves the file at oldpath to newpath, creating any missing parent directories of
+	// newpath if necessary.
+	Rename(oldpath string, newpath string) error
+	// Symlink creates a symlink at newname that points to oldname, replacing anything that is
+	// already at newname.
+	Symlink(oldname string, newname string) error
+	// Remove removes the file at the specified path. Removing a file that doesn't exist is not
+	// an error.
+	Remove(path string) error
+	// Exists reports whether anything, including a broken symlink, exists at the specified path.
+	Exists(path string) (bool, error)
+}
 
-	return file
+// NewSudoFileSystem returns a FileSystem that reads files directly, but makes all changes through
+// sudo. By running through the shell, we can ask for sudo only when we need it, rather than
+// requiring a user to run falcon with sudo.
+func NewSudoFileSystem() FileSystem {
+	return sudoFileSystem{}
 }
 
-// CreateFile creates a file at the specified path. If it encounters any errors, it logs them
-// and ends the program.
-func CreateFile(path string) *os.File {
-	file, err := os.Create(path)
+// NewRootedFileSystem returns a FileSystem that treats the specified directory as the root of the
+// host machine, so that a path like "/etc/resolv.conf" actually refers to
+// "<root>/etc/resolv.conf". It makes all changes as the current user.
+func NewRootedFileSystem(root string) FileSystem {
+	return rootedFileSystem{root: root}
+}
 
-	if err != nil {
-		logger.LogError("Unable to create file %v. \n Error: ", path, err.Error())
-	}
+type sudoFileSystem struct{}
 
-	return file
+func (sudoFileSystem) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
 }
 
-// DeleteFile deletes the file at the specified path. If it encounters any errors, it logs them
-// and ends the program.
-func DeleteFile(path string) {
-	if err := os.Remove(path); err != nil {
-		logger.LogError("Unable to delete file %v. Error: %v", path, err.Error())
-	}
+func (sudoFileSystem) WriteFile(path string, data []byte) error {
+	cmd := fmt.Sprintf("sudo mkdir -p %v && sudo tee %v > /dev/null", quote(filepath.Dir(path)), quote(path))
+
+	return shell.RunCommandWithInput(cmd, bytes.NewReader(data))
 }
 
-func Symlink(oldname string, newname string) {
-	if err := os.Symlink(oldname, newname); err != nil {
-		logger.LogError("Unable to create symlink to file %v at %v. Error: ",
-			oldname, newname, err.Error())
-	}
+func (sudoFileSystem) Rename(oldpath string, newpath string) error {
+	return shell.RunCommand(fmt.Sprintf("sudo mkdir -p %v && sudo mv -f %v %v",
+		quote(filepath.Dir(newpath)), quote(oldpath), quote(newpath)))
 }
 
-// ReadFile reads all of the contents of the specified file into a byte slice and returns that
-// slice. If any errors are encountered along the way, it logs the error and ends the program.
-func ReadFile(file *os.File) []byte {
-	buffer := make([]byte, FileSize(file))
+func (sudoFileSystem) Symlink(oldname string, newname string) error {
+	return shell.RunCommand(fmt.Sprintf("sudo ln -sfn %v %v", quote(oldname), quote(newname)))
+}
 
-	_, err := file.Read(buffer)
+func (sudoFileSystem) Remove(path string) error {
+	return shell.RunCommand(fmt.Sprintf("sudo rm -f %v", quote(path)))
+}
 
-	if err != nil {
-		logger.LogError("Unable to read file: ", file.Name())
-	}
+func (sudoFileSystem) Exists(path string) (bool, error) {
+	return exists(path)
+}
 
-	return buffer
+type rootedFileSystem struct {
+	root string
+}
 
+// path returns the location of the specified host path inside of the root directory.
+func (r rootedFileSystem) path(path string) string {
+	return filepath.Join(r.root, path)
 }
 
-// FileStats returns the info on the given file. If any errors are encountered, it logs the error
-// and then ends the program.
-func FileInfo(path string) os.FileInfo {
-	stats, err := os.Stat(path)
+func (r rootedFileSystem) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(r.path(path))
+}
 
-	if err != nil {
-		logger.LogError("Unable to get stats on file %v.\n See the following error for details: ",
-			path, err.Error())
+func (r rootedFileSystem) WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(r.path(path)), 0755); err != nil {
+		return err
 	}
 
-	return stats
+	return os.WriteFile(r.path(path), data, 0644)
 }
 
-// FileSize returns the size of the specified file. If it encounters any errors, it logs them and
-// ends the program.
-func FileSize(file *os.File) int64 {
-	fileinfo, err := file.Stat()
-
-	if err != nil {
-		logger.LogError("Unable to get stats on file: ", file.Name())
+func (r rootedFileSystem) Rename(oldpath string, newpath string) error {
+	if err := os.MkdirAll(filepath.Dir(r.path(newpath)), 0755); err != nil {
+		return err
 	}
 
-	return fileinfo.Size()
+	return os.Rename(r.path(oldpath), r.path(newpath))
 }
 
-// OverwriteFile overwrites the previous contents of the passed in file with the specified contents.
-// If it encounters any errors, it logs them and ends the program.
-func OverwriteFile(file *os.File, contents []byte) {
-	err := file.Truncate(0)
-
-	if err != nil {
-		logger.LogError("Unable to clear out file %v for write. See the following error for more details: ",
-			file.Name(), err.Error())
+func (r rootedFileSystem) Symlink(oldname string, newname string) error {
+	if err := r.Remove(newname); err != nil {
+		return err
 	}
 
-	_, err = file.Seek(0, 0)
+	return os.Symlink(r.path(oldname), r.path(newname))
+}
 
-	if err != nil {
-		logger.LogError("Unable to return to beginning of file %v for write. See the following error for more details: ",
-			file.Name(), err.Error())
+func (r rootedFileSystem) Remove(path string) error {
+	if err := os.Remove(r.path(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
 	}
 
-	_, err = file.Write(contents)
+	return nil
+}
 
-	if err != nil {
-		logger.LogError("Unable to write all data to the file %v. See the following error for details: ",
-			file.Name(), err.Error())
-	}
+func (r rootedFileSystem) Exists(path string) (bool, error) {
+	return exists(r.path(path))
+}
+
+// exists reports whether anything exists at the specified path, without following symlinks.
+func exists(path string) (bool, error) {
+	_, err := os.Lstat(path)
 
-	err = file.Sync()
-	if err != nil {
-		logger.LogError("Unable to save data to disk for file %v. See the following error for details: ",
-			file.Name(), err.Error())
+	if err == nil {
+		return true, nil
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else {
+		return false, err
 	}
 }
+
+// quote wraps the specified string in single quotes so that the shell treats it as a single word.
+func quote(s string) string {
+	return fmt.Sprintf("'%v'", strings.ReplaceAll(s, "'", `'\''`))
+}
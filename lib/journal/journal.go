@@ -0,0 +1,387 @@
+// The journal package keeps track of every change falcon makes to the host machine in a state
+// file, so that a failed falcon up can undo the changes it already made, and so that falcon down
+// can undo exactly the changes falcon up made, rather than guessing.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/logger"
+)
+
+// DefaultPath is where falcon keeps its state file.
+var DefaultPath = fmt.Sprintf("%v/.falcon/state.json", os.Getenv("HOME"))
+
+// Changes describes what a step actually changed on the host machine.
+type Changes struct {
+	// CreatedFiles lists the files that didn't exist until the step created them.
+	CreatedFiles []string `json:"createdFiles,omitempty"`
+	// ModifiedFiles lists the files that already existed and were edited in place.
+	ModifiedFiles []string `json:"modifiedFiles,omitempty"`
+	// BackedUpFiles maps each file that was moved out of the way to where its backup lives.
+	BackedUpFiles map[string]string `json:"backedUpFiles,omitempty"`
+	// LoopbackAddress is the loopback address the step added, if it added one.
+	LoopbackAddress string `json:"loopbackAddress,omitempty"`
+	// Link is the name of the network interface the step created, if it created one.
+	Link string `json:"link,omitempty"`
+	// Container describes the container the step started, if it started one.
+	Container *Container `json:"container,omitempty"`
+	// Network is the name of the Docker network the step set up, if it set one up.
+	Network string `json:"network,omitempty"`
+	// PID is the ID of the background process the step started, if it started one.
+	PID int `json:"pid,omitempty"`
+}
+
+// Container describes a container started by falcon.
+type Container struct {
+	Name        string `json:"name"`
+	ID          string `json:"id"`
+	Image       string `json:"image"`
+	ImageDigest string `json:"imageDigest"`
+}
+
+// Created reports whether the specified file was created by the step.
+func (c Changes) Created(path string) bool {
+	return contains(c.CreatedFiles, path)
+}
+
+// Modified reports whether the specified file was edited in place by the step.
+func (c Changes) Modified(path string) bool {
+	return contains(c.ModifiedFiles, path)
+}
+
+// empty reports whether nothing was changed.
+func (c Changes) empty() bool {
+	return len(c.CreatedFiles) == 0 && len(c.ModifiedFiles) == 0 && len(c.BackedUpFiles) == 0 &&
+		c.LoopbackAddress == "" && c.Link == "" && c.Container == nil && c.Network == "" && c.PID == 0
+}
+
+// merge combines the changes a step made when it was applied again with the changes it made
+// originally. The original changes win, as they describe the state of the machine before falcon
+// touched it, except for the container and background process, which are always the ones that are
+// running now.
+func (c Changes) merge(newer Changes) Changes {
+	merged := Changes{
+		CreatedFiles:    append([]string{}, c.CreatedFiles...),
+		ModifiedFiles:   append([]string{}, c.ModifiedFiles...),
+		LoopbackAddress: c.LoopbackAddress,
+		Link:            c.Link,
+		Container:       c.Container,
+		Network:         c.Network,
+		PID:             c.PID,
+	}
+
+	for _, path := range newer.CreatedFiles {
+		if !c.Created(path) && !c.Modified(path) {
+			merged.CreatedFiles = append(merged.CreatedFiles, path)
+		}
+	}
+
+	for _, path := range newer.ModifiedFiles {
+		if !c.Created(path) && !c.Modified(path) {
+			merged.ModifiedFiles = append(merged.ModifiedFiles, path)
+		}
+	}
+
+	if len(c.BackedUpFiles) > 0 || len(newer.BackedUpFiles) > 0 {
+		merged.BackedUpFiles = make(map[string]string)
+
+		for path, backup := range newer.BackedUpFiles {
+			merged.BackedUpFiles[path] = backup
+		}
+		// The original backup holds the file from before falcon touched it, so it wins.
+		for path, backup := range c.BackedUpFiles {
+			merged.BackedUpFiles[path] = backup
+		}
+	}
+
+	if merged.LoopbackAddress == "" {
+		merged.LoopbackAddress = newer.LoopbackAddress
+	}
+	if merged.Link == "" {
+		merged.Link = newer.Link
+	}
+	if merged.Network == "" {
+		merged.Network = newer.Network
+	}
+	if newer.PID != 0 {
+		merged.PID = newer.PID
+	}
+
+	if newer.Container != nil {
+		merged.Container = newer.Container
+	}
+
+	return merged
+}
+
+// Step is a single change to the host machine, paired with the change that undoes it.
+type Step struct {
+	// Name uniquely identifies the step, so that the journal can refer to it after falcon exits.
+	Name string
+	// Description finishes the sentence "Unable to ..." and is used when the step fails.
+	Description string
+	// Apply makes the change, and describes what it actually had to change. It should be safe to
+	// run more than once.
+	Apply func() (Changes, error)
+	// Undo reverts the changes made by Apply, and nothing else.
+	Undo func(Changes) error
+}
+
+// Record describes a step that was applied and what it changed.
+type Record struct {
+	Step    string  `json:"step"`
+	Changes Changes `json:"changes"`
+}
+
+// Journal records which steps have been applied, in the order they were applied, and saves
+// itself after every change so that nothing is forgotten if falcon is interrupted.
+type Journal struct {
+	// Backend is the name of the networking backend whose steps were applied.
+	Backend string `json:"backend"`
+	// TLDs are the top-level domains falcon was set up to resolve.
+	TLDs []string `json:"tlds,omitempty"`
+	// LoopbackAddress is the address falcon's domains were set up to resolve to.
+	LoopbackAddress string `json:"loopbackAddress,omitempty"`
+	// Addresses are where the proxy and dnsmasq were set up to listen on the host machine.
+	Addresses *listen.Addresses `json:"addresses,omitempty"`
+	// Complete is true once every step passed to Apply has been applied. If it's false while
+	// steps have been applied, falcon was interrupted partway through.
+	Complete bool `json:"complete"`
+	// Applied lists each applied step, in the order they were applied.
+	Applied []Record `json:"applied"`
+
+	path string
+}
+
+// Open loads the journal saved at the specified path. If there's no journal at that path, an
+// empty journal is returned that will be saved there once a step is applied.
+func Open(path string) (*Journal, error) {
+	journal := &Journal{Applied: []Record{}, path: path}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return journal, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("the state file at %v is corrupt: %v", path, err)
+	}
+
+	return journal, nil
+}
+
+// Empty reports whether there aren't any applied steps in the journal.
+func (j *Journal) Empty() bool {
+	return len(j.Applied) == 0
+}
+
+// Interrupted reports whether falcon was interrupted while applying steps, leaving some of them
+// applied and others not.
+func (j *Journal) Interrupted() bool {
+	return !j.Empty() && !j.Complete
+}
+
+// Lookup returns the record for the step with the specified name, if it has been applied.
+func (j *Journal) Lookup(name string) (Record, bool) {
+	if i := j.index(name); i >= 0 {
+		return j.Applied[i], true
+	}
+
+	return Record{}, false
+}
+
+// Apply applies each of the specified steps in order, recording each one and what it changed in
+// the journal once it succeeds. If a step fails, every step that was newly applied by this call is
+// undone in reverse order and the error from the failed step is returned. A step that fails partway
+// through may return what it already changed along with the error, in which case it's recorded and
+// undone with those changes too. Steps that were already in the journal are applied again, but are
+// left alone when rolling back.
+func (j *Journal) Apply(steps []Step) error {
+	j.Complete = false
+	newlyApplied := make([]Step, 0, len(steps))
+
+	for _, step := range steps {
+		changes, err := step.Apply()
+
+		if err != nil {
+			applyErr := fmt.Errorf("Unable to %v due to the following error:\n%v", step.Description, err)
+
+			if !changes.empty() {
+				if j.record(step.Name, changes) {
+					newlyApplied = append(newlyApplied, step)
+				}
+
+				if saveErr := j.save(); saveErr != nil {
+					return fmt.Errorf("%v\nAdditionally, the changes falcon already made couldn't be recorded:\n%v", applyErr, saveErr)
+				}
+			}
+
+			if undoErr := j.undo(newlyApplied); undoErr != nil {
+				return fmt.Errorf("%v\nAdditionally, the changes falcon already made couldn't be undone:\n%v", applyErr, undoErr)
+			}
+
+			return applyErr
+		}
+
+		if j.record(step.Name, changes) {
+			newlyApplied = append(newlyApplied, step)
+		}
+
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+
+	j.Complete = true
+	return j.save()
+}
+
+// record records that the step with the specified name made the specified changes, merging them
+// into what it changed before if it was already applied. It reports whether the step is new to the
+// journal.
+func (j *Journal) record(name string, changes Changes) bool {
+	if i := j.index(name); i >= 0 {
+		j.Applied[i].Changes = j.Applied[i].Changes.merge(changes)
+		return false
+	}
+
+	j.Applied = append(j.Applied, Record{Step: name, Changes: changes})
+	return true
+}
+
+// Undo undoes every applied step in the reverse order they were applied, removing each one from
+// the journal once it succeeds. Steps that aren't in the journal are skipped. If a step can't be
+// undone, Undo stops and returns the error, leaving that step and any before it in the journal.
+func (j *Journal) Undo(steps []Step) error {
+	byName := make(map[string]Step, len(steps))
+
+	for _, step := range steps {
+		byName[step.Name] = step
+	}
+
+	applied := make([]Step, 0, len(j.Applied))
+
+	for _, entry := range j.Applied {
+		step, ok := byName[entry.Step]
+
+		if !ok {
+			return fmt.Errorf("the state file at %v refers to a step named %q that falcon doesn't know how to undo", j.path, entry.Step)
+		}
+
+		applied = append(applied, step)
+	}
+
+	return j.undo(applied)
+}
+
+// UndoAll undoes every one of the specified steps in reverse order, whether or not they're in the
+// journal, and then clears the journal. Steps that aren't in the journal are undone with no
+// recorded changes, so only steps that can safely undo themselves without knowing what they
+// changed, like stopping a container by name, will do anything.
+func (j *Journal) UndoAll(steps []Step) error {
+	for _, step := range steps {
+		if j.index(step.Name) < 0 {
+			j.Applied = append(j.Applied, Record{Step: step.Name})
+		}
+	}
+
+	return j.undo(steps)
+}
+
+// undo undoes the specified steps in reverse order, removing each one from the journal.
+func (j *Journal) undo(steps []Step) error {
+	for i := len(steps) - 1; i >= 0; i-- {
+		logger.LogDebugOnly("Undoing step", steps[i].Name)
+
+		record, _ := j.Lookup(steps[i].Name)
+
+		if err := steps[i].Undo(record.Changes); err != nil {
+			return fmt.Errorf("Unable to undo the %v step due to the following error:\n%v", steps[i].Name, err)
+		}
+
+		j.remove(steps[i].Name)
+
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// index returns the position of the step with the specified name in the journal, or -1 if it
+// hasn't been applied.
+func (j *Journal) index(name string) int {
+	for i, entry := range j.Applied {
+		if entry.Step == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// remove removes the step with the specified name from the journal.
+func (j *Journal) remove(name string) {
+	remaining := make([]Record, 0, len(j.Applied))
+
+	for _, entry := range j.Applied {
+		if entry.Step != name {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	j.Applied = remaining
+}
+
+// save writes the journal to disk, or deletes it once nothing is left to undo.
+func (j *Journal) save() error {
+	if j.Empty() {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and then rename it over the old one, so that a crash never
+	// leaves us with a half-written state file.
+	tmpPath := j.path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, j.path)
+}
+
+// contains reports whether the specified list contains the specified string.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,252 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Hawkbawk/falcon/lib/listen"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var (
+		path    string
+		journal *Journal
+		calls   []string
+		failing map[string]bool
+		changes map[string]Changes
+		undone  map[string]Changes
+	)
+
+	step := func(name string) Step {
+		return Step{
+			Name:        name,
+			Description: fmt.Sprintf("do %v", name),
+			Apply: func() (Changes, error) {
+				calls = append(calls, "apply "+name)
+				if failing["apply "+name] {
+					return changes[name], fmt.Errorf("%v failed", name)
+				}
+				return changes[name], nil
+			},
+			Undo: func(recorded Changes) error {
+				calls = append(calls, "undo "+name)
+				undone[name] = recorded
+				if failing["undo "+name] {
+					return fmt.Errorf("%v failed", name)
+				}
+				return nil
+			},
+		}
+	}
+
+	applied := func(journal *Journal) []string {
+		names := make([]string, 0, len(journal.Applied))
+		for _, record := range journal.Applied {
+			names = append(names, record.Step)
+		}
+		return names
+	}
+
+	reopen := func() *Journal {
+		reopened, err := Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		return reopened
+	}
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "journal.json")
+		calls = make([]string, 0)
+		failing = make(map[string]bool)
+		changes = make(map[string]Changes)
+		undone = make(map[string]Changes)
+		journal = reopen()
+	})
+
+	Describe("Open", func() {
+		It("returns an empty journal if there isn't one saved", func() {
+			Expect(journal.Empty()).To(BeTrue())
+		})
+
+		It("returns an error if the saved journal is corrupt", func() {
+			Expect(os.WriteFile(path, []byte("{"), 0644)).To(Succeed())
+
+			Expect(Open(path)).Error().To(HaveOccurred())
+		})
+	})
+
+	Describe("Apply", func() {
+		It("applies each step in order and saves them", func() {
+			journal.Backend = "test"
+			journal.TLDs = []string{"docker", "test"}
+			journal.LoopbackAddress = "10.254.254.254"
+			journal.Addresses = &listen.Addresses{ProxyIP: "10.254.254.254", HTTPPort: 8080, HTTPSPort: 8443, DNSIP: "10.254.254.254", DNSPort: 5353}
+			Expect(journal.Apply([]Step{step("a"), step("b")})).To(Succeed())
+
+			Expect(calls).To(Equal([]string{"apply a", "apply b"}))
+			Expect(applied(reopen())).To(Equal([]string{"a", "b"}))
+			Expect(reopen().Backend).To(Equal("test"))
+			Expect(reopen().TLDs).To(Equal([]string{"docker", "test"}))
+			Expect(reopen().LoopbackAddress).To(Equal("10.254.254.254"))
+			Expect(reopen().Addresses).To(Equal(journal.Addresses))
+			Expect(reopen().Complete).To(BeTrue())
+		})
+
+		It("records what each step changed", func() {
+			changes["a"] = Changes{CreatedFiles: []string{"/etc/a"}, LoopbackAddress: "127.0.0.2"}
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			record, ok := reopen().Lookup("a")
+			Expect(ok).To(BeTrue())
+			Expect(record.Changes).To(Equal(changes["a"]))
+		})
+
+		It("keeps the original changes when a step is applied again", func() {
+			changes["a"] = Changes{
+				ModifiedFiles: []string{"/etc/a"},
+				BackedUpFiles: map[string]string{"/etc/b": "/backup/b"},
+				Container:     &Container{Name: "a", ID: "old"},
+				Network:       "falcon",
+				PID:           100,
+			}
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			changes["a"] = Changes{
+				CreatedFiles:  []string{"/etc/a", "/etc/c"},
+				BackedUpFiles: map[string]string{"/etc/b": "/backup/b2"},
+				Container:     &Container{Name: "a", ID: "new"},
+				Network:       "other",
+				PID:           200,
+			}
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			record, _ := reopen().Lookup("a")
+			Expect(record.Changes).To(Equal(Changes{
+				CreatedFiles:  []string{"/etc/c"},
+				ModifiedFiles: []string{"/etc/a"},
+				BackedUpFiles: map[string]string{"/etc/b": "/backup/b"},
+				Container:     &Container{Name: "a", ID: "new"},
+				Network:       "falcon",
+				PID:           200,
+			}))
+		})
+
+		It("undoes the steps it applied if a later one fails", func() {
+			failing["apply c"] = true
+
+			Expect(journal.Apply([]Step{step("a"), step("b"), step("c")})).To(MatchError(ContainSubstring("Unable to do c")))
+			Expect(calls).To(Equal([]string{"apply a", "apply b", "apply c", "undo b", "undo a"}))
+			Expect(journal.Empty()).To(BeTrue())
+			Expect(path).NotTo(BeAnExistingFile())
+		})
+
+		It("leaves steps that were already applied alone when rolling back", func() {
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+			calls = make([]string, 0)
+			failing["apply c"] = true
+
+			Expect(journal.Apply([]Step{step("a"), step("b"), step("c")})).NotTo(Succeed())
+			Expect(calls).To(Equal([]string{"apply a", "apply b", "apply c", "undo b"}))
+			Expect(applied(reopen())).To(Equal([]string{"a"}))
+		})
+
+		It("undoes what a failed step already changed", func() {
+			changes["b"] = Changes{BackedUpFiles: map[string]string{"/etc/resolv.conf": "/backup/resolv.conf"}}
+			failing["apply b"] = true
+
+			Expect(journal.Apply([]Step{step("a"), step("b")})).To(MatchError(ContainSubstring("Unable to do b")))
+			Expect(calls).To(Equal([]string{"apply a", "apply b", "undo b", "undo a"}))
+			Expect(undone["b"]).To(Equal(changes["b"]))
+			Expect(path).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps what a failed step changed if it was already applied", func() {
+			changes["a"] = Changes{CreatedFiles: []string{"/etc/a"}}
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+			calls = make([]string, 0)
+			changes["a"] = Changes{CreatedFiles: []string{"/etc/b"}}
+			failing["apply a"] = true
+
+			Expect(journal.Apply([]Step{step("a")})).NotTo(Succeed())
+			Expect(calls).To(Equal([]string{"apply a"}))
+			record, _ := reopen().Lookup("a")
+			Expect(record.Changes.CreatedFiles).To(Equal([]string{"/etc/a", "/etc/b"}))
+		})
+
+		It("reports when rolling back fails too", func() {
+			failing["apply b"] = true
+			failing["undo a"] = true
+
+			err := journal.Apply([]Step{step("a"), step("b")})
+			Expect(err).To(MatchError(ContainSubstring("couldn't be undone")))
+			Expect(applied(reopen())).To(Equal([]string{"a"}))
+		})
+	})
+
+	Describe("Interrupted", func() {
+		It("is false for an empty journal", func() {
+			Expect(journal.Interrupted()).To(BeFalse())
+		})
+
+		It("is false once every step has been applied", func() {
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			Expect(reopen().Interrupted()).To(BeFalse())
+		})
+
+		It("is true if falcon stopped partway through applying steps", func() {
+			// This is what the state file looks like if falcon is killed right after applying a.
+			journal.Applied = []Record{{Step: "a"}}
+			Expect(journal.save()).To(Succeed())
+
+			Expect(reopen().Interrupted()).To(BeTrue())
+		})
+	})
+
+	Describe("Undo", func() {
+		It("undoes only the applied steps, in reverse order", func() {
+			Expect(journal.Apply([]Step{step("a"), step("b")})).To(Succeed())
+			calls = make([]string, 0)
+
+			Expect(reopen().Undo([]Step{step("a"), step("b"), step("c")})).To(Succeed())
+			Expect(calls).To(Equal([]string{"undo b", "undo a"}))
+			Expect(path).NotTo(BeAnExistingFile())
+		})
+
+		It("passes each step the changes recorded when it was applied", func() {
+			changes["a"] = Changes{CreatedFiles: []string{"/etc/a"}}
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			Expect(reopen().Undo([]Step{step("a")})).To(Succeed())
+			Expect(undone["a"]).To(Equal(changes["a"]))
+		})
+
+		It("stops and keeps the remaining steps if one can't be undone", func() {
+			Expect(journal.Apply([]Step{step("a"), step("b")})).To(Succeed())
+			failing["undo a"] = true
+
+			Expect(journal.Undo([]Step{step("a"), step("b")})).NotTo(Succeed())
+			Expect(applied(reopen())).To(Equal([]string{"a"}))
+		})
+
+		It("returns an error if the journal refers to an unknown step", func() {
+			Expect(journal.Apply([]Step{step("a")})).To(Succeed())
+
+			Expect(journal.Undo([]Step{step("b")})).NotTo(Succeed())
+			Expect(calls).To(Equal([]string{"apply a"}))
+		})
+	})
+
+	Describe("UndoAll", func() {
+		It("undoes every step, even if the journal is empty", func() {
+			Expect(journal.UndoAll([]Step{step("a"), step("b")})).To(Succeed())
+
+			Expect(calls).To(Equal([]string{"undo b", "undo a"}))
+			Expect(undone["a"]).To(Equal(Changes{}))
+			Expect(journal.Empty()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,58 @@
+// The listen package works out where on the host machine the proxy and dnsmasq containers listen.
+// By default they only listen on the address falcon's domains resolve to, rather than on every
+// interface, so that they don't clash with other local tools or get exposed to the whole network.
+package listen
+
+import (
+	"fmt"
+	"net"
+)
+
+// The ports the proxy and dnsmasq listen on when none are configured.
+const (
+	DefaultHTTPPort  = 80
+	DefaultHTTPSPort = 443
+	DefaultDNSPort   = 53
+)
+
+// Addresses describes where on the host machine the proxy and dnsmasq containers listen.
+type Addresses struct {
+	// ProxyIP is the IP the proxy's HTTP and HTTPS ports are bound to.
+	ProxyIP   string `json:"proxyIP"`
+	HTTPPort  int    `json:"httpPort"`
+	HTTPSPort int    `json:"httpsPort"`
+	// DNSIP is the IP dnsmasq's DNS port is bound to.
+	DNSIP   string `json:"dnsIP"`
+	DNSPort int    `json:"dnsPort"`
+}
+
+// Legacy returns the addresses falcon listened on before they were configurable.
+func Legacy() Addresses {
+	return Addresses{
+		ProxyIP:   "0.0.0.0",
+		HTTPPort:  DefaultHTTPPort,
+		HTTPSPort: DefaultHTTPSPort,
+		DNSIP:     "0.0.0.0",
+		DNSPort:   DefaultDNSPort,
+	}
+}
+
+// HTTP returns the address to send HTTP requests for the proxy to from the host machine.
+func (a Addresses) HTTP() string {
+	return net.JoinHostPort(reachable(a.ProxyIP), fmt.Sprint(a.HTTPPort))
+}
+
+// DNS returns the address to send DNS queries for dnsmasq to from the host machine.
+func (a Addresses) DNS() string {
+	return net.JoinHostPort(reachable(a.DNSIP), fmt.Sprint(a.DNSPort))
+}
+
+// reachable returns the IP to connect to for something bound to the specified IP. Something that's
+// bound to every interface can always be reached through localhost.
+func reachable(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsUnspecified() {
+		return "127.0.0.1"
+	}
+
+	return ip
+}
@@ -0,0 +1,13 @@
+package listen
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestListen(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Listen Suite")
+}
@@ -0,0 +1,25 @@
+package listen
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listen", func() {
+	Describe("HTTP and DNS", func() {
+		It("connects to the bound IP", func() {
+			addresses := Addresses{ProxyIP: "192.168.40.1", HTTPPort: 80, HTTPSPort: 443, DNSIP: "192.168.40.1", DNSPort: 53}
+
+			Expect(addresses.HTTP()).To(Equal("192.168.40.1:80"))
+			Expect(addresses.DNS()).To(Equal("192.168.40.1:53"))
+		})
+
+		It("connects through localhost when bound to every interface", func() {
+			addresses := Legacy()
+			addresses.HTTPPort = 8080
+
+			Expect(addresses.HTTP()).To(Equal("127.0.0.1:8080"))
+			Expect(addresses.DNS()).To(Equal("127.0.0.1:53"))
+		})
+	})
+})
@@ -0,0 +1,134 @@
+// The network package creates the Docker network falcon runs the proxy and dnsmasq on, and connects
+// other containers to it, so that Traefik can reach them directly rather than through the host
+// machine.
+package network
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+// ConnectLabel is the label a container sets to "true" to be connected to falcon's network when the
+// connect policy is labelled.
+const ConnectLabel = "falcon.connect"
+
+// Create creates the configured network, unless it already exists.
+func Create(ctx context.Context, client docker.DockerClient, cfg config.Config) error {
+	return client.EnsureNetwork(ctx, cfg.Network.Name, cfg.Network.Subnet)
+}
+
+// Remove removes the network with the specified name if falcon created it, disconnecting every
+// container from it first.
+func Remove(ctx context.Context, client docker.DockerClient, name string) error {
+	return client.RemoveNetwork(ctx, name)
+}
+
+// Selects reports whether the specified connect policy connects a container with the specified
+// labels to falcon's network.
+func Selects(policy config.ConnectPolicy, labels map[string]string) bool {
+	switch policy {
+	case config.ConnectAll:
+		return true
+	case config.ConnectLabelled:
+		return labels[ConnectLabel] == "true" || labels["traefik.enable"] == "true"
+	default:
+		return false
+	}
+}
+
+// ConnectAll connects every running container the configured connect policy selects to the
+// configured network, returning the names of the containers it connected.
+func ConnectAll(ctx context.Context, client docker.DockerClient, cfg config.Config) ([]string, error) {
+	connected := make([]string, 0)
+
+	if cfg.Network.Connect == config.ConnectNone {
+		return connected, nil
+	}
+
+	containers, err := client.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, container := range containers {
+		if !connectable(container, cfg) {
+			continue
+		}
+		if err := client.ConnectContainer(ctx, cfg.Network.Name, container.ID); err != nil {
+			return connected, err
+		}
+		connected = append(connected, docker.ContainerName(container))
+	}
+
+	return connected, nil
+}
+
+// Watch connects each container the configured connect policy selects to the configured network as
+// soon as it starts, until ctx is done. connected is called with the name of each container Watch
+// connects, along with the error if it couldn't be connected, since one container failing to
+// connect shouldn't stop the others. An error is only returned if Docker stops sending events.
+func Watch(ctx context.Context, client docker.DockerClient, cfg config.Config, connected func(name string, err error)) error {
+	messages, errs := client.ContainerEvents(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case message := <-messages:
+			if message.Action != "start" {
+				continue
+			}
+			if container, err := started(ctx, client, message); err != nil {
+				connected(message.Actor.Attributes["name"], err)
+			} else if container != nil && connectable(*container, cfg) {
+				connected(docker.ContainerName(*container), client.ConnectContainer(ctx, cfg.Network.Name, container.ID))
+			}
+		}
+	}
+}
+
+// started finds the running container the specified start event is about. The event doesn't say
+// which networks the container is on, so the container is looked up. If it's already stopped
+// again, a nil container is returned.
+func started(ctx context.Context, client docker.DockerClient, message events.Message) (*types.Container, error) {
+	containers, err := client.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range containers {
+		if containers[i].ID == message.Actor.ID {
+			return &containers[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// connectable reports whether the specified container should be connected to the configured
+// network: the connect policy selects it, it isn't on the network already, and it isn't using the
+// host's network or another container's, which can't be combined with a bridge network.
+func connectable(container types.Container, cfg config.Config) bool {
+	mode := container.HostConfig.NetworkMode
+	if mode == "host" || mode == "none" || strings.HasPrefix(mode, "container:") {
+		return false
+	}
+
+	if container.NetworkSettings != nil {
+		if _, ok := container.NetworkSettings.Networks[cfg.Network.Name]; ok {
+			return false
+		}
+	}
+
+	return Selects(cfg.Network.Connect, container.Labels)
+}
@@ -0,0 +1,178 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// running describes a running container with the specified ID and labels, on the default bridge.
+func running(id string, labels map[string]string) types.Container {
+	container := types.Container{ID: id, Names: []string{"/" + id}, Labels: labels}
+	container.HostConfig.NetworkMode = "default"
+	container.NetworkSettings = &types.SummaryNetworkSettings{Networks: map[string]*networktypes.EndpointSettings{"bridge": {}}}
+	return container
+}
+
+var _ = Describe("Network", func() {
+	var (
+		ctrl       *gomock.Controller
+		mockClient *mock_docker.MockDockerClient
+		cfg        config.Config
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		cfg = config.Defaults()
+		cfg.Network.Connect = config.ConnectLabelled
+	})
+
+	Describe("Create", func() {
+		It("creates the configured network", func() {
+			cfg.Network.Subnet = "172.30.0.0/24"
+			mockClient.EXPECT().EnsureNetwork(context.Background(), "falcon", "172.30.0.0/24").Return(nil)
+
+			Expect(Create(context.Background(), mockClient, cfg)).To(Succeed())
+		})
+	})
+
+	Describe("Remove", func() {
+		It("removes the network with the specified name", func() {
+			err := fmt.Errorf("problems!")
+			mockClient.EXPECT().RemoveNetwork(context.Background(), "old").Return(err)
+
+			Expect(Remove(context.Background(), mockClient, "old")).To(Equal(err))
+		})
+	})
+
+	Describe("Selects", func() {
+		It("selects containers based on the policy", func() {
+			traefik := map[string]string{"traefik.enable": "true"}
+			asked := map[string]string{ConnectLabel: "true"}
+			plain := map[string]string{}
+
+			Expect(Selects(config.ConnectNone, traefik)).To(BeFalse())
+			Expect(Selects(config.ConnectLabelled, traefik)).To(BeTrue())
+			Expect(Selects(config.ConnectLabelled, asked)).To(BeTrue())
+			Expect(Selects(config.ConnectLabelled, plain)).To(BeFalse())
+			Expect(Selects(config.ConnectAll, plain)).To(BeTrue())
+		})
+	})
+
+	Describe("ConnectAll", func() {
+		It("connects the selected containers that aren't on the network yet", func() {
+			onNetwork := running("proxy", map[string]string{"traefik.enable": "true"})
+			onNetwork.NetworkSettings.Networks["falcon"] = &networktypes.EndpointSettings{}
+			hostNetwork := running("host", map[string]string{"traefik.enable": "true"})
+			hostNetwork.HostConfig.NetworkMode = "host"
+
+			mockClient.EXPECT().ListContainers(context.Background()).Return([]types.Container{
+				running("app", map[string]string{"traefik.enable": "true"}),
+				running("db", nil),
+				onNetwork,
+				hostNetwork,
+				running("worker", map[string]string{ConnectLabel: "true"}),
+			}, nil)
+			mockClient.EXPECT().ConnectContainer(context.Background(), "falcon", "app").Return(nil)
+			mockClient.EXPECT().ConnectContainer(context.Background(), "falcon", "worker").Return(nil)
+
+			Expect(ConnectAll(context.Background(), mockClient, cfg)).To(Equal([]string{"app", "worker"}))
+		})
+
+		It("doesn't look at any containers if the policy is none", func() {
+			cfg.Network.Connect = config.ConnectNone
+			mockClient.EXPECT().ListContainers(gomock.Any()).Times(0)
+
+			Expect(ConnectAll(context.Background(), mockClient, cfg)).To(BeEmpty())
+		})
+
+		It("returns any error connecting a container", func() {
+			err := fmt.Errorf("problems!")
+			mockClient.EXPECT().ListContainers(context.Background()).Return([]types.Container{running("app", map[string]string{ConnectLabel: "true"})}, nil)
+			mockClient.EXPECT().ConnectContainer(context.Background(), "falcon", "app").Return(err)
+
+			_, connectErr := ConnectAll(context.Background(), mockClient, cfg)
+			Expect(connectErr).To(Equal(err))
+		})
+	})
+
+	Describe("Watch", func() {
+		var (
+			ctx      context.Context
+			cancel   context.CancelFunc
+			messages chan events.Message
+			errs     chan error
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			messages = make(chan events.Message)
+			errs = make(chan error, 1)
+			mockClient.EXPECT().ContainerEvents(ctx).Return((<-chan events.Message)(messages), (<-chan error)(errs))
+		})
+
+		// start sends an event saying the container with the specified ID started.
+		start := func(id string) {
+			messages <- events.Message{Action: "start", Actor: events.Actor{ID: id, Attributes: map[string]string{"name": id}}}
+		}
+
+		It("connects selected containers as they start until ctx is done", func() {
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{
+				running("app", map[string]string{"traefik.enable": "true"}),
+				running("db", nil),
+			}, nil).Times(2)
+			mockClient.EXPECT().ConnectContainer(ctx, "falcon", "app").Return(nil)
+
+			results := make(map[string]error)
+			done := make(chan error)
+			go func() {
+				done <- Watch(ctx, mockClient, cfg, func(name string, err error) { results[name] = err })
+			}()
+
+			messages <- events.Message{Action: "die", Actor: events.Actor{ID: "app"}}
+			start("app")
+			start("db")
+			cancel()
+
+			Expect(<-done).To(Succeed())
+			Expect(results).To(Equal(map[string]error{"app": nil}))
+		})
+
+		It("reports containers that couldn't be connected and keeps going", func() {
+			err := fmt.Errorf("problems!")
+			mockClient.EXPECT().ListContainers(ctx).Return(nil, err)
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{running("app", map[string]string{ConnectLabel: "true"})}, nil)
+			mockClient.EXPECT().ConnectContainer(ctx, "falcon", "app").Return(err)
+
+			results := make(map[string]error)
+			done := make(chan error)
+			go func() {
+				done <- Watch(ctx, mockClient, cfg, func(name string, err error) { results[name] = err })
+			}()
+
+			start("gone")
+			start("app")
+			cancel()
+
+			Expect(<-done).To(Succeed())
+			Expect(results).To(Equal(map[string]error{"gone": err, "app": err}))
+		})
+
+		It("returns the error if Docker stops sending events", func() {
+			err := fmt.Errorf("unexpected EOF")
+			errs <- err
+
+			Expect(Watch(ctx, mockClient, cfg, func(string, error) {})).To(Equal(err))
+		})
+	})
+})
@@ -2,94 +2,259 @@ package darwin
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strings"
 
-	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/journal"
 	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
 	"github.com/Hawkbawk/falcon/lib/shell"
 )
 
-// The path to the resolver file we're going to create to tell macOS to resolve any requests to the
-// *.docker to our loopback address.
-const dockerResolverPath = "/etc/resolver/docker"
+// The directory macOS looks in for resolver files. The resolver file for a TLD is named after it,
+// so /etc/resolver/docker tells macOS how to resolve *.docker domains.
+const resolverDir = "/etc/resolver"
+
+// Where we keep resolver files that were already there before falcon up was run.
+var resolverBackupDir = fmt.Sprintf("%v/.falcon/backups", os.Getenv("HOME"))
+
+// The interface we add our loopback address to.
+const loopbackInterface = "lo0"
 
 // Allows us to check if, when deleting our new loopback address, the operation failed because
 // the address didn't exist in the first place. This way we can make sure falcon down is idempotent
 // and doesn't error when run multiple times in a row.
-var loopbackAlreadyDeletedRegex regexp.Regexp = *regexp.MustCompile("(SIOCDIFADDR)")
-
-// The command that adds our custom resolver for *.docker domains. By running through the shell, we
-// can ask for sudo only when we need it, rather than requiring a user to run falcon with sudo.
-var addResolverCmd string = fmt.Sprintf("echo \"nameserver %v\nport 53\" | sudo tee %v > /dev/null", dnsmasq.LoopbackAddress, dockerResolverPath)
-var addLoopbackAddressCmd string = fmt.Sprintf("sudo ifconfig lo0 alias %v", dnsmasq.LoopbackAddress)
-var removeResolverCmd string = fmt.Sprintf("sudo rm -f %v", dockerResolverPath)
-var removeLoopbackAddressCmd string = fmt.Sprintf("sudo ifconfig lo0 -alias %v", dnsmasq.LoopbackAddress)
-
-// Configure configures the host machine's networking to allow the falcon-proxy to work it's magic.
-func Configure() error {
-	if err := addDockerResolver(shell.RunCommand); err != nil {
-		return fmt.Errorf("Unable to add Docker resolver due to the following error:\n%v", err)
-	}
+var loopbackAlreadyDeletedRegex = regexp.MustCompile("(SIOCDIFADDR)")
+
+// The commands that add falcon's loopback address to the loopback interface and remove it again.
+var loopbackCommands = loopback.Commands{
+	Interface:      loopbackInterface,
+	Add:            createAddLoopbackAddressCmd,
+	Remove:         createRemoveLoopbackAddressCmd,
+	AlreadyRemoved: loopbackAlreadyDeletedRegex,
+}
 
-	if err := addLoopbackAddress(shell.RunCommand); err != nil {
-		return fmt.Errorf("Unable to add loopback address due to the following error:\n%v", err)
+// Backend points *.docker domains at falcon using a macOS resolver file.
+type Backend struct{}
+
+// Name returns the name users can use to select this backend.
+func (Backend) Name() string {
+	return "macos"
+}
+
+// Detect reports whether we're running on macOS.
+func (Backend) Detect() (bool, error) {
+	return runtime.GOOS == "darwin", nil
+}
+
+// Steps adds a resolver file for each TLD and then the loopback address.
+func (Backend) Steps(cfg config.Config) []journal.Step {
+	tlds := cfg.TLDs
+	address := cfg.LoopbackAddress
+	port := cfg.DNSPort
+
+	return []journal.Step{
+		{
+			Name:        "resolver",
+			Description: "add Docker resolver",
+			Apply: func() (journal.Changes, error) {
+				return addResolvers(shell.RunCommand, os.ReadFile, tlds, address, port)
+			},
+			Undo: func(changes journal.Changes) error { return removeResolvers(shell.RunCommand, changes) },
+		},
+		{
+			Name:        "loopback",
+			Description: "add loopback address",
+			Apply: func() (journal.Changes, error) {
+				return loopback.Add(shell.RunCommand, loopback.HasAddress, loopback.Conflicts, loopbackCommands, address)
+			},
+			Undo: func(changes journal.Changes) error {
+				return loopback.Remove(shell.RunCommand, loopbackCommands, changes)
+			},
+		},
 	}
+}
 
-	return nil
+// Status describes any part of the resolver files or loopback address that's missing.
+func (Backend) Status(cfg config.Config) ([]string, error) {
+	return status(os.ReadFile, loopback.HasAddress, cfg.TLDs, cfg.LoopbackAddress, cfg.DNSPort)
 }
 
-// Adds the custom *.docker custom resolver.
-func addDockerResolver(cmdRunner func(string) error) error {
-	logger.LogInfo("Requesting sudo to write to /etc/resolver/docker...")
-	if err := cmdRunner(addResolverCmd); err != nil {
-		return err
+// Adds a resolver for each of the specified TLDs, pointing at dnsmasq on the specified loopback
+// address and port.
+func addResolvers(cmdRunner func(string) error, readFile func(string) ([]byte, error), tlds []string, address string, port int) (journal.Changes, error) {
+	changes := journal.Changes{BackedUpFiles: map[string]string{}}
+
+	var err error
+	for _, tld := range tlds {
+		var added journal.Changes
+		added, err = addResolver(cmdRunner, readFile, tld, address, port)
+
+		// The resolvers already added are returned even if this one failed, so that they're removed.
+		changes.CreatedFiles = append(changes.CreatedFiles, added.CreatedFiles...)
+		for path, backup := range added.BackedUpFiles {
+			changes.BackedUpFiles[path] = backup
+		}
+
+		if err != nil {
+			break
+		}
 	}
-	return nil
+
+	if len(changes.BackedUpFiles) == 0 {
+		changes.BackedUpFiles = nil
+	}
+
+	return changes, err
 }
 
-// Adds the additional loopback address required for inter-container communication to work.
-func addLoopbackAddress(cmdRunner func(string) error) error {
-	logger.LogInfo("Requesting sudo to add a new loopback address...")
-	if err := cmdRunner(addLoopbackAddressCmd); err != nil {
-		return err
+// Adds the custom resolver for the specified TLD. If there's already a resolver for the TLD that
+// falcon didn't add, it's backed up first so that it can be restored by falcon down.
+func addResolver(cmdRunner func(string) error, readFile func(string) ([]byte, error), tld string, address string, port int) (journal.Changes, error) {
+	path := resolverPath(tld)
+	changes := journal.Changes{CreatedFiles: []string{path}}
+
+	if existing, err := readFile(path); err == nil && string(existing) != createResolverContents(address, port) {
+		backupPath := resolverBackupPath(tld)
+
+		logger.LogInfo("Requesting sudo to backup the existing %v...", path)
+		if err := cmdRunner(createBackupResolverCmd(path, backupPath)); err != nil {
+			return journal.Changes{}, err
+		}
+		changes = journal.Changes{BackedUpFiles: map[string]string{path: backupPath}}
+	} else if err != nil && !os.IsNotExist(err) {
+		return journal.Changes{}, err
 	}
-	return nil
+
+	logger.LogInfo("Requesting sudo to write to %v...", path)
+	if err := cmdRunner(createAddResolverCmd(path, address, port)); err != nil {
+		// The existing resolver has already been moved out of the way, so it has to be restored.
+		if changes.BackedUpFiles != nil {
+			return changes, err
+		}
+		return journal.Changes{}, err
+	}
+	return changes, nil
 }
 
-// Clean restores the host machine's networking to it's previous state before starting falcon.
-func Clean() error {
-	if err := removeLoopbackAddress(shell.RunCommand); err != nil {
-		return fmt.Errorf("Unable to remove the loopback address due to the following error: \n%v", err)
+// Removes every resolver falcon recorded adding, restoring the user's own resolvers where we
+// backed one up. The TLDs come from the changes, so this works even if the configured TLDs have
+// changed since falcon up was run.
+func removeResolvers(cmdRunner func(string) error, changes journal.Changes) error {
+	backedUp := make([]string, 0, len(changes.BackedUpFiles))
+	for path := range changes.BackedUpFiles {
+		backedUp = append(backedUp, path)
 	}
+	sort.Strings(backedUp)
+
+	for _, path := range backedUp {
+		if filepath.Dir(path) != resolverDir {
+			continue
+		}
 
-	if err := removeDockerResolver(shell.RunCommand); err != nil {
-		return fmt.Errorf("Unable to remove the custom *.docker resolver due to the following error: \n%v", err)
+		logger.LogInfo("Requesting sudo to restore your original %v...", path)
+		if err := cmdRunner(createRestoreResolverCmd(changes.BackedUpFiles[path], path)); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
+	for _, path := range changes.CreatedFiles {
+		if filepath.Dir(path) != resolverDir {
+			continue
+		}
 
-// Removes the previously added custom *.docker resolver, if it exists.
-func removeDockerResolver(cmdRunner func(string) error) error {
-	logger.LogInfo("Requesting sudo to remove /etc/resolver/docker...")
-	if err := cmdRunner(removeResolverCmd); err != nil {
-		return err
+		logger.LogInfo("Requesting sudo to remove %v...", path)
+		if err := cmdRunner(createRemoveResolverCmd(path)); err != nil {
+			return err
+		}
 	}
+
 	return nil
 }
 
-// Removes the previously added custom loopback address.
-func removeLoopbackAddress(cmdRunner func(string) error) error {
-	logger.LogInfo("Requesting sudo to remove the added loopback address...")
-	err := cmdRunner(removeLoopbackAddressCmd)
+// createAddLoopbackAddressCmd creates the command that adds the specified loopback address.
+func createAddLoopbackAddressCmd(address string) string {
+	return fmt.Sprintf("sudo ifconfig %v alias %v", loopbackInterface, address)
+}
+
+// createRemoveLoopbackAddressCmd creates the command that removes the specified loopback address.
+func createRemoveLoopbackAddressCmd(address string) string {
+	return fmt.Sprintf("sudo ifconfig %v -alias %v", loopbackInterface, address)
+}
 
+// resolverPath returns the path of the resolver file for the specified TLD.
+func resolverPath(tld string) string {
+	return filepath.Join(resolverDir, tld)
+}
+
+// resolverBackupPath returns where we back up an existing resolver file for the specified TLD.
+func resolverBackupPath(tld string) string {
+	return filepath.Join(resolverBackupDir, fmt.Sprintf("resolver-%v", tld))
+}
+
+// createResolverContents creates what each resolver file pointing at the specified loopback address
+// and port contains, so we can tell our resolvers apart from ones the user added themselves.
+func createResolverContents(address string, port int) string {
+	return fmt.Sprintf("nameserver %v\nport %v\n", address, port)
+}
+
+// The commands that manage resolver files. By running through the shell, we can ask for sudo only
+// when we need it, rather than requiring a user to run falcon with sudo.
+func createAddResolverCmd(path string, address string, port int) string {
+	return fmt.Sprintf("echo \"nameserver %v\nport %v\" | sudo tee %v > /dev/null", address, port, path)
+}
+
+func createBackupResolverCmd(path string, backupPath string) string {
+	return fmt.Sprintf("mkdir -p %v && sudo cp %v %v", filepath.Dir(backupPath), path, backupPath)
+}
+
+func createRestoreResolverCmd(backupPath string, path string) string {
+	return fmt.Sprintf("sudo mv -f %v %v", backupPath, path)
+}
+
+func createRemoveResolverCmd(path string) string {
+	return fmt.Sprintf("sudo rm -f %v", path)
+}
+
+// status checks that the resolver file for each TLD and the loopback address are in place,
+// returning a description of each one that isn't.
+func status(readFile func(string) ([]byte, error), hasAddress func(string, string) (bool, error), tlds []string, address string, port int) ([]string, error) {
+	problems := make([]string, 0)
+
+	for _, tld := range tlds {
+		path := resolverPath(tld)
+		resolver, err := readFile(path)
+
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%v is missing", path))
+		} else if err != nil {
+			return nil, err
+		} else if !hasLine(resolver, fmt.Sprintf("nameserver %v", address)) || !hasLine(resolver, fmt.Sprintf("port %v", port)) {
+			problems = append(problems, fmt.Sprintf("%v doesn't point at %v port %v", path, address, port))
+		}
+	}
+
+	addressProblems, err := loopback.Status(hasAddress, loopbackInterface, address)
 	if err != nil {
-		if loopbackAlreadyDeletedRegex.Match([]byte(err.Error())) {
-			return nil
-		} else {
-			return err
+		return nil, err
+	}
+
+	return append(problems, addressProblems...), nil
+}
+
+// hasLine reports whether the specified contents have a line that matches the specified line,
+// ignoring any whitespace around it.
+func hasLine(contents []byte, line string) bool {
+	for _, l := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(l) == line {
+			return true
 		}
 	}
 
-	return nil
+	return false
 }
@@ -2,6 +2,10 @@ package darwin
 
 import (
 	"fmt"
+	"os"
+
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -21,10 +25,50 @@ var _ = Describe("Darwin", func() {
 	})
 
 
-	Describe("addDockerResolver", func() {
-		It("tries to run the addResolver command", func() {
-			Expect(addDockerResolver(cmdRunner)).Should(Succeed())
-			Expect(argList[0]).Should(Equal(addResolverCmd))
+	dockerResolverPath := "/etc/resolver/docker"
+	testResolverPath := "/etc/resolver/test"
+	address := "192.168.40.1"
+	port := 53
+
+	Describe("addResolvers", func() {
+		var (
+			existing map[string]string
+			readFile = func(path string) ([]byte, error) {
+				if contents, ok := existing[path]; ok {
+					return []byte(contents), nil
+				}
+				return nil, os.ErrNotExist
+			}
+		)
+
+		BeforeEach(func() {
+			existing = make(map[string]string)
+		})
+
+		It("adds a resolver for each TLD and records that it created them", func() {
+			Expect(addResolvers(cmdRunner, readFile, []string{"docker", "test"}, address, port)).Should(Equal(journal.Changes{CreatedFiles: []string{dockerResolverPath, testResolverPath}}))
+			Expect(argList).Should(Equal([]string{createAddResolverCmd(dockerResolverPath, address, port), createAddResolverCmd(testResolverPath, address, port)}))
+		})
+
+		It("treats a resolver that matches ours as one that it created", func() {
+			existing[dockerResolverPath] = createResolverContents(address, port)
+
+			Expect(addResolvers(cmdRunner, readFile, []string{"docker"}, address, port)).Should(Equal(journal.Changes{CreatedFiles: []string{dockerResolverPath}}))
+			Expect(argList).Should(Equal([]string{createAddResolverCmd(dockerResolverPath, address, port)}))
+		})
+
+		It("backs up a resolver that falcon didn't add", func() {
+			existing[testResolverPath] = "nameserver 10.0.0.1\n"
+
+			changes, err := addResolvers(cmdRunner, readFile, []string{"docker", "test"}, address, port)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes.CreatedFiles).Should(Equal([]string{dockerResolverPath}))
+			Expect(changes.BackedUpFiles).Should(Equal(map[string]string{testResolverPath: resolverBackupPath("test")}))
+			Expect(argList).Should(Equal([]string{
+				createAddResolverCmd(dockerResolverPath, address, port),
+				createBackupResolverCmd(testResolverPath, resolverBackupPath("test")),
+				createAddResolverCmd(testResolverPath, address, port),
+			}))
 		})
 
 		Describe("an error occurs", func() {
@@ -33,15 +77,73 @@ var _ = Describe("Darwin", func() {
 			})
 
 			It("returns that error", func() {
-				Expect(addDockerResolver(cmdRunner)).Should(Equal(err))
+				Expect(addResolvers(cmdRunner, readFile, []string{"docker"}, address, port)).Error().Should(Equal(err))
+			})
+
+			It("returns the resolvers it already added along with the error", func() {
+				failure := err
+				err = nil
+				failingRunner := func(cmd string) error {
+					if cmd == createAddResolverCmd(testResolverPath, address, port) {
+						return failure
+					}
+					return cmdRunner(cmd)
+				}
+
+				changes, addErr := addResolvers(failingRunner, readFile, []string{"docker", "test"}, address, port)
+				Expect(addErr).Should(Equal(failure))
+				Expect(changes).Should(Equal(journal.Changes{CreatedFiles: []string{dockerResolverPath}}))
+			})
+
+			It("returns the backup of an existing resolver it couldn't replace", func() {
+				existing[dockerResolverPath] = "nameserver 10.0.0.1\n"
+				failure := err
+				err = nil
+				failingRunner := func(cmd string) error {
+					if cmd == createAddResolverCmd(dockerResolverPath, address, port) {
+						return failure
+					}
+					return cmdRunner(cmd)
+				}
+
+				changes, addErr := addResolvers(failingRunner, readFile, []string{"docker"}, address, port)
+				Expect(addErr).Should(Equal(failure))
+				Expect(changes.BackedUpFiles).Should(Equal(map[string]string{dockerResolverPath: resolverBackupPath("docker")}))
 			})
 		})
 	})
 
-	Describe("addLoopbackAddress", func() {
-		It("tries to run the addLoopbackAddress command", func() {
-			Expect(addLoopbackAddress(cmdRunner)).Should(Succeed())
-			Expect(argList[0]).Should(Equal(addLoopbackAddressCmd))
+	Describe("adding the loopback address", func() {
+		var (
+			present    bool
+			hasAddress = func(string, string) (bool, error) { return present, nil }
+			conflict   error
+			conflicts  = func(string) error { return conflict }
+		)
+
+		BeforeEach(func() {
+			present = false
+			conflict = nil
+		})
+
+		It("adds the address to lo0 and records it", func() {
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands, "10.254.254.254")).Should(Equal(journal.Changes{LoopbackAddress: "10.254.254.254"}))
+			Expect(argList[0]).Should(Equal(createAddLoopbackAddressCmd("10.254.254.254")))
+		})
+
+		It("doesn't add or record an address that's already there", func() {
+			present = true
+			conflict = fmt.Errorf("already reachable")
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands, address)).Should(Equal(journal.Changes{}))
+			Expect(argList).Should(BeEmpty())
+		})
+
+		It("doesn't add an address that one of the host's networks uses", func() {
+			conflict = fmt.Errorf("already reachable")
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands, address)).Error().Should(Equal(conflict))
+			Expect(argList).Should(BeEmpty())
 		})
 
 		Describe("an error occurs", func() {
@@ -50,15 +152,34 @@ var _ = Describe("Darwin", func() {
 			})
 
 			It("returns that error", func() {
-				Expect(addLoopbackAddress(cmdRunner)).Should(Equal(err))
+				Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands, address)).Error().Should(Equal(err))
 			})
 		})
 	})
 
-	Describe("removeDockerResolver", func() {
-		It("tries to run the removeResolver command", func() {
-			Expect(removeDockerResolver(cmdRunner)).Should(Succeed())
-			Expect(argList[0]).Should(Equal(removeResolverCmd))
+	Describe("removeResolvers", func() {
+		created := journal.Changes{CreatedFiles: []string{dockerResolverPath, testResolverPath}}
+
+		It("removes every resolver falcon recorded creating", func() {
+			Expect(removeResolvers(cmdRunner, created)).Should(Succeed())
+			Expect(argList).Should(Equal([]string{createRemoveResolverCmd(dockerResolverPath), createRemoveResolverCmd(testResolverPath)}))
+		})
+
+		It("restores the backup if falcon backed up the resolver", func() {
+			changes := journal.Changes{BackedUpFiles: map[string]string{dockerResolverPath: resolverBackupPath("docker")}}
+
+			Expect(removeResolvers(cmdRunner, changes)).Should(Succeed())
+			Expect(argList).Should(Equal([]string{createRestoreResolverCmd(resolverBackupPath("docker"), dockerResolverPath)}))
+		})
+
+		It("leaves the resolvers alone if falcon didn't touch them", func() {
+			Expect(removeResolvers(cmdRunner, journal.Changes{})).Should(Succeed())
+			Expect(argList).Should(BeEmpty())
+		})
+
+		It("ignores recorded files that aren't resolvers", func() {
+			Expect(removeResolvers(cmdRunner, journal.Changes{CreatedFiles: []string{"/etc/hosts"}})).Should(Succeed())
+			Expect(argList).Should(BeEmpty())
 		})
 
 		Describe("an error occurs", func() {
@@ -67,15 +188,28 @@ var _ = Describe("Darwin", func() {
 			})
 
 			It("returns that error", func() {
-				Expect(removeDockerResolver(cmdRunner)).Should(Equal(err))
+				Expect(removeResolvers(cmdRunner, created)).Should(Equal(err))
 			})
 		})
 	})
 
-	Describe("removeLoopbackAddress", func() {
-		It("tries to run the removeLoopbackAddress command", func() {
-			Expect(removeLoopbackAddress(cmdRunner)).Should(Succeed())
-			Expect(argList[0]).Should(Equal(removeLoopbackAddressCmd))
+	Describe("removing the loopback address", func() {
+		added := journal.Changes{LoopbackAddress: "192.168.40.2"}
+
+		It("removes the address that falcon recorded adding", func() {
+			Expect(loopback.Remove(cmdRunner, loopbackCommands, added)).Should(Succeed())
+			Expect(argList).Should(Equal([]string{"sudo ifconfig lo0 -alias 192.168.40.2"}))
+		})
+
+		It("leaves the loopback interface alone if falcon didn't add an address", func() {
+			Expect(loopback.Remove(cmdRunner, loopbackCommands, journal.Changes{})).Should(Succeed())
+			Expect(argList).Should(BeEmpty())
+		})
+
+		It("ignores errors from the address already being gone", func() {
+			err = fmt.Errorf("ifconfig: ioctl (SIOCDIFADDR): Can't assign requested address")
+
+			Expect(loopback.Remove(cmdRunner, loopbackCommands, added)).Should(Succeed())
 		})
 
 		Describe("an error occurs", func() {
@@ -84,8 +218,66 @@ var _ = Describe("Darwin", func() {
 			})
 
 			It("returns that error", func() {
-				Expect(removeLoopbackAddress(cmdRunner)).Should(Equal(err))
+				Expect(loopback.Remove(cmdRunner, loopbackCommands, added)).Should(Equal(err))
 			})
 		})
 	})
+
+	Describe("status", func() {
+		var (
+			resolver   string
+			readErr    error
+			hasAlias   bool
+			readFile   = func(string) ([]byte, error) { return []byte(resolver), readErr }
+			hasAddress = func(string, string) (bool, error) { return hasAlias, nil }
+		)
+
+		BeforeEach(func() {
+			resolver = "nameserver 192.168.40.1\nport 53"
+			readErr = nil
+			hasAlias = true
+		})
+
+		It("reports no problems when everything is in place", func() {
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, port)).To(BeEmpty())
+		})
+
+		It("reports a missing resolver file", func() {
+			readErr = os.ErrNotExist
+
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, port)).To(ConsistOf(ContainSubstring("is missing")))
+		})
+
+		It("reports a resolver file that points somewhere else", func() {
+			resolver = "nameserver 10.0.0.1\nport 53"
+
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, port)).To(ConsistOf(ContainSubstring("doesn't point at")))
+		})
+
+		It("reports a resolver file that uses a different port", func() {
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, 5353)).To(ConsistOf(ContainSubstring("port 5353")))
+		})
+
+		It("reports a missing loopback address", func() {
+			hasAlias = false
+
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, port)).To(ConsistOf(ContainSubstring("loopback address")))
+		})
+
+		It("checks the resolver for every TLD", func() {
+			Expect(status(readFile, hasAddress, []string{"docker", "test"}, address, port)).To(BeEmpty())
+
+			readErr = os.ErrNotExist
+			Expect(status(readFile, hasAddress, []string{"docker", "test"}, address, port)).To(ConsistOf(
+				ContainSubstring("/etc/resolver/docker is missing"),
+				ContainSubstring("/etc/resolver/test is missing"),
+			))
+		})
+
+		It("returns an error if the resolver file can't be read", func() {
+			readErr = fmt.Errorf("permission denied")
+
+			Expect(status(readFile, hasAddress, []string{"docker"}, address, port)).Error().To(HaveOccurred())
+		})
+	})
 })
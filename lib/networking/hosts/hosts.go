@@ -0,0 +1,179 @@
+// The hosts package contains a last-resort backend that points individual hostnames at falcon by
+// adding them to /etc/hosts. The hosts file doesn't support wildcards, so only the hostnames that
+// falcon has been told about will resolve, and containers won't be able to resolve them at all.
+package hosts
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+)
+
+const hostsFilePath = "/etc/hosts"
+
+// Address is the address we point every hostname at. This backend doesn't add falcon's loopback
+// address, so the proxy listens on plain old localhost instead.
+const Address = "127.0.0.1"
+
+const beginMarker = "# BEGIN falcon"
+const endMarker = "# END falcon"
+
+// Matches the block of entries we previously added, including the markers around it.
+var blockRegex *regexp.Regexp = regexp.MustCompile(fmt.Sprintf(`(?ms)^%v$.*?^%v$\n?`,
+	regexp.QuoteMeta(beginMarker), regexp.QuoteMeta(endMarker)))
+
+// Backend points the hostnames we know about at falcon through /etc/hosts.
+type Backend struct{}
+
+// Name returns the name users can use to select this backend.
+func (Backend) Name() string {
+	return "hosts"
+}
+
+// Detect reports whether the machine has a hosts file we can use.
+func (Backend) Detect() (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, nil
+	}
+
+	return files.NewSudoFileSystem().Exists(hostsFilePath)
+}
+
+// Steps adds our block of entries to /etc/hosts.
+func (Backend) Steps(cfg config.Config) []journal.Step {
+	fs := files.NewSudoFileSystem()
+
+	return []journal.Step{
+		{
+			Name:        "hosts-entries",
+			Description: fmt.Sprintf("add falcon's entries to %v", hostsFilePath),
+			Apply: func() (journal.Changes, error) {
+				if err := configure(fs, configuredHostnames(cfg)); err != nil {
+					return journal.Changes{}, err
+				}
+
+				return journal.Changes{ModifiedFiles: []string{hostsFilePath}}, nil
+			},
+			Undo: func(changes journal.Changes) error {
+				if !changes.Modified(hostsFilePath) {
+					return nil
+				}
+
+				return clean(fs)
+			},
+		},
+	}
+}
+
+// Status describes any of our hostnames that are missing from /etc/hosts.
+func (Backend) Status(cfg config.Config) ([]string, error) {
+	return status(files.NewSudoFileSystem(), configuredHostnames(cfg))
+}
+
+// configuredHostnames returns every hostname that should be added to /etc/hosts: the dashboard
+// under each TLD, the host of each route, plus any the user has listed under the "hosts" config key.
+func configuredHostnames(cfg config.Config) []string {
+	hostnames := defaultHostnames(cfg.TLDs)
+
+	for _, route := range cfg.Routes {
+		hostnames = append(hostnames, route.Host)
+	}
+
+	return append(hostnames, cfg.Hosts...)
+}
+
+// defaultHostnames returns the hostnames we always add for the specified TLDs.
+func defaultHostnames(tlds []string) []string {
+	hostnames := make([]string, 0, len(tlds))
+
+	for _, tld := range tlds {
+		hostnames = append(hostnames, proxy.DashboardHostname(tld))
+	}
+
+	return hostnames
+}
+
+// configure replaces any block of entries we previously added with a fresh one for the specified
+// hostnames.
+func configure(fs files.FileSystem, hostnames []string) error {
+	contents, err := fs.ReadFile(hostsFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	newContents := removeBlock(contents)
+	if len(newContents) > 0 && !bytes.HasSuffix(newContents, []byte("\n")) {
+		newContents = append(newContents, '\n')
+	}
+	newContents = append(newContents, createBlock(hostnames)...)
+
+	logger.LogInfo("Requesting sudo to write to %v...", hostsFilePath)
+	return fs.WriteFile(hostsFilePath, newContents)
+}
+
+// clean removes the block of entries we previously added, if there is one.
+func clean(fs files.FileSystem) error {
+	contents, err := fs.ReadFile(hostsFilePath)
+
+	if err != nil {
+		return err
+	} else if !blockRegex.Match(contents) {
+		return nil
+	}
+
+	logger.LogInfo("Requesting sudo to remove falcon's entries from %v...", hostsFilePath)
+	return fs.WriteFile(hostsFilePath, removeBlock(contents))
+}
+
+// status describes each of the specified hostnames that's missing from our block of entries.
+func status(fs files.FileSystem, hostnames []string) ([]string, error) {
+	contents, err := fs.ReadFile(hostsFilePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block := string(blockRegex.Find(contents))
+	problems := make([]string, 0)
+
+	for _, hostname := range hostnames {
+		if !strings.Contains(block, createEntry(hostname)) {
+			problems = append(problems, fmt.Sprintf("%v is missing from %v", hostname, hostsFilePath))
+		}
+	}
+
+	return problems, nil
+}
+
+// createBlock creates the block of entries for the specified hostnames, wrapped in markers so we
+// can find it again later.
+func createBlock(hostnames []string) []byte {
+	var block bytes.Buffer
+
+	block.WriteString(beginMarker + "\n")
+	for _, hostname := range hostnames {
+		block.WriteString(createEntry(hostname))
+	}
+	block.WriteString(endMarker + "\n")
+
+	return block.Bytes()
+}
+
+// createEntry creates the hosts file line for the specified hostname.
+func createEntry(hostname string) string {
+	return fmt.Sprintf("%v %v\n", Address, hostname)
+}
+
+// removeBlock returns the specified hosts file contents without our block of entries.
+func removeBlock(contents []byte) []byte {
+	return blockRegex.ReplaceAll(contents, []byte{})
+}
@@ -0,0 +1,13 @@
+package hosts
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHosts(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hosts Suite")
+}
@@ -0,0 +1,101 @@
+package hosts
+
+import (
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/files"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const originalHosts = "127.0.0.1 localhost\n::1 localhost\n"
+
+var _ = Describe("Hosts", func() {
+	var (
+		fs        files.FileSystem
+		hostnames = []string{"traefik.docker", "app.docker"}
+	)
+
+	readHosts := func() string {
+		contents, err := fs.ReadFile(hostsFilePath)
+		Expect(err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		fs = files.NewRootedFileSystem(GinkgoT().TempDir())
+		Expect(fs.WriteFile(hostsFilePath, []byte(originalHosts))).To(Succeed())
+	})
+
+	Describe("configure", func() {
+		It("adds a block of entries for each hostname", func() {
+			Expect(configure(fs, hostnames)).To(Succeed())
+
+			Expect(readHosts()).To(Equal(originalHosts +
+				"# BEGIN falcon\n127.0.0.1 traefik.docker\n127.0.0.1 app.docker\n# END falcon\n"))
+		})
+
+		It("replaces a previously added block", func() {
+			Expect(configure(fs, hostnames)).To(Succeed())
+			Expect(configure(fs, []string{"other.docker"})).To(Succeed())
+
+			Expect(readHosts()).To(Equal(originalHosts + "# BEGIN falcon\n127.0.0.1 other.docker\n# END falcon\n"))
+		})
+
+		It("handles a hosts file without a trailing newline", func() {
+			Expect(fs.WriteFile(hostsFilePath, []byte("127.0.0.1 localhost"))).To(Succeed())
+
+			Expect(configure(fs, []string{"app.docker"})).To(Succeed())
+			Expect(readHosts()).To(Equal("127.0.0.1 localhost\n# BEGIN falcon\n127.0.0.1 app.docker\n# END falcon\n"))
+		})
+	})
+
+	Describe("clean", func() {
+		It("removes the block of entries", func() {
+			Expect(configure(fs, hostnames)).To(Succeed())
+
+			Expect(clean(fs)).To(Succeed())
+			Expect(readHosts()).To(Equal(originalHosts))
+		})
+
+		It("leaves the hosts file alone if there's no block", func() {
+			Expect(clean(fs)).To(Succeed())
+			Expect(readHosts()).To(Equal(originalHosts))
+		})
+	})
+
+	Describe("status", func() {
+		It("reports no problems when every hostname is present", func() {
+			Expect(configure(fs, hostnames)).To(Succeed())
+
+			Expect(status(fs, hostnames)).To(BeEmpty())
+		})
+
+		It("reports each missing hostname", func() {
+			Expect(configure(fs, hostnames[:1])).To(Succeed())
+
+			Expect(status(fs, hostnames)).To(ConsistOf(ContainSubstring("app.docker")))
+		})
+
+		It("doesn't count entries outside of our block", func() {
+			Expect(fs.WriteFile(hostsFilePath, []byte("127.0.0.1 app.docker\n"))).To(Succeed())
+
+			Expect(status(fs, []string{"app.docker"})).To(HaveLen(1))
+		})
+	})
+
+	Describe("defaultHostnames", func() {
+		It("includes the dashboard under every TLD", func() {
+			Expect(defaultHostnames([]string{"docker", "test"})).To(Equal([]string{"traefik.docker", "traefik.test"}))
+		})
+	})
+
+	Describe("configuredHostnames", func() {
+		It("adds the host of each route and the configured hosts", func() {
+			cfg := config.Defaults()
+			cfg.Routes = []config.Route{{Host: "api.docker", URL: "http://host.docker.internal:3000"}}
+			cfg.Hosts = []string{"app.docker"}
+
+			Expect(configuredHostnames(cfg)).To(Equal([]string{"traefik.docker", "api.docker", "app.docker"}))
+		})
+	})
+})
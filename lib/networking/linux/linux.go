@@ -1,31 +1,133 @@
+// The linux package contains the backends that set up a Linux machine so that falcon's domains
+// resolve to it, either by giving systemd-resolved a link of falcon's own to route them through or
+// by enabling NetworkManager's dnsmasq plugin and letting NetworkManager take over resolv.conf.
 package linux
 
-func Configure() {
-	managerConfigFile := openManagerConfigFile()
+import (
+	"fmt"
+	"runtime"
 
-	addLoopbackAddress()
-	enableDnsmasq(managerConfigFile)
-	// We can't defer this, as we have to save the change we make before we reload NetworkManager.
-	closeConfigFile(managerConfigFile)
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+	"github.com/Hawkbawk/falcon/lib/shell"
+)
 
-	// Move/backup the resolv file, then create the symlink.
-	moveResolvFile()
-	letManagerManageResolv()
+// NetworkManager points *.docker domains at falcon by enabling NetworkManager's dnsmasq plugin and
+// letting NetworkManager manage resolv.conf.
+type NetworkManager struct{}
 
-	createDockerConfFile()
-	reloadNetworkManager()
+// Name returns the name users can use to select this backend.
+func (NetworkManager) Name() string {
+	return "networkmanager"
 }
 
-func Restore() {
-	managerConfigFile := openManagerConfigFile()
+// Detect reports whether we're running on Linux with NetworkManager installed.
+func (NetworkManager) Detect() (bool, error) {
+	if runtime.GOOS != "linux" {
+		return false, nil
+	}
 
-	removeLoopbackAddress()
-	disableDnsmasq(managerConfigFile)
-	// We can't defer this, as we have to save the change we make before we reload NetworkManager.
-	closeConfigFile(managerConfigFile)
-	// First remove the symlink, then restore the resolv file.
-	stopManagerManagingResolv()
-	restoreResolvFile()
-	deleteDockerConfFile()
-	reloadNetworkManager()
+	return files.NewSudoFileSystem().Exists(managerConfigFilePath)
+}
+
+// Steps enables dnsmasq in NetworkManager and adds our loopback address.
+func (NetworkManager) Steps(cfg config.Config) []journal.Step {
+	return networkManagerSteps(files.NewSudoFileSystem(), shell.RunCommand, loopback.HasAddress, loopback.Conflicts, cfg.TLDs, cfg.LoopbackAddress)
+}
+
+// Status describes any part of the NetworkManager setup that's missing.
+func (NetworkManager) Status(cfg config.Config) ([]string, error) {
+	return networkManagerStatus(files.NewSudoFileSystem(), loopback.HasAddress, cfg.TLDs, cfg.LoopbackAddress)
+}
+
+// Resolved points *.docker domains at falcon through a systemd-resolved link of falcon's own.
+type Resolved struct{}
+
+// Name returns the name users can use to select this backend.
+func (Resolved) Name() string {
+	return "resolved"
+}
+
+// Detect reports whether we're running on Linux with systemd-resolved running.
+func (Resolved) Detect() (bool, error) {
+	if runtime.GOOS != "linux" {
+		return false, nil
+	}
+
+	return usingResolved(files.NewSudoFileSystem())
+}
+
+// Steps installs the config for our link, creates it, adds our loopback address to it and points
+// systemd-resolved at it.
+func (Resolved) Steps(cfg config.Config) []journal.Step {
+	return resolvedSteps(files.NewSudoFileSystem(), shell.RunCommand, loopback.HasAddress, loopback.HasInterface, loopback.Conflicts, cfg.TLDs, cfg.LoopbackAddress, cfg.DNSPort)
+}
+
+// Status describes any part of the systemd-resolved setup that's missing.
+func (Resolved) Status(cfg config.Config) ([]string, error) {
+	return resolvedStatus(files.NewSudoFileSystem(), loopback.HasAddress, loopback.HasInterface, shell.CommandOutput, cfg.TLDs, cfg.LoopbackAddress, cfg.DNSPort)
+}
+
+// networkManagerSteps adds the specified loopback address, enables dnsmasq, lets NetworkManager
+// manage resolv.conf and then adds the config for the specified TLDs, reloading NetworkManager once
+// everything's in place.
+func networkManagerSteps(fs files.FileSystem, cmdRunner func(string) error, hasAddress func(string, string) (bool, error), conflicts func(string) error, tlds []string, address string) []journal.Step {
+	return []journal.Step{
+		{
+			Name:        "loopback",
+			Description: "add loopback address",
+			Apply: func() (journal.Changes, error) {
+				return loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), address)
+			},
+			Undo: func(changes journal.Changes) error {
+				return loopback.Remove(cmdRunner, loopbackCommands(loopbackInterface), changes)
+			},
+		},
+		{
+			Name:        "dnsmasq",
+			Description: "enable NetworkManager's dnsmasq plugin",
+			Apply:       func() (journal.Changes, error) { return enableDnsmasq(fs) },
+			// This is the last step to be undone, so it's our chance to make NetworkManager pick
+			// up everything we've reverted.
+			Undo: func(changes journal.Changes) error {
+				if err := disableDnsmasq(fs, changes); err != nil {
+					return err
+				}
+
+				return reloadNetworkManager(cmdRunner)
+			},
+		},
+		{
+			Name:        "resolv",
+			Description: fmt.Sprintf("let NetworkManager manage %v", resolvFilePath),
+			// Backup the resolv file first, then create the symlink.
+			Apply: func() (journal.Changes, error) {
+				changes, err := backupResolvFile(fs)
+
+				if err != nil {
+					return journal.Changes{}, err
+				}
+
+				return changes, letManagerManageResolv(fs)
+			},
+			// Restoring the backup replaces the symlink NetworkManager was managing.
+			Undo: func(changes journal.Changes) error { return restoreResolvFile(fs, changes) },
+		},
+		{
+			Name:        "docker-conf",
+			Description: "add the dnsmasq config for falcon's domains",
+			Apply: func() (journal.Changes, error) {
+				changes, err := createDockerConfFile(fs, tlds, address)
+
+				if err != nil {
+					return journal.Changes{}, err
+				}
+
+				return changes, reloadNetworkManager(cmdRunner)
+			},
+			Undo: func(changes journal.Changes) error { return deleteDockerConfFile(fs, changes) },
+		},
+	}
 }
@@ -0,0 +1,13 @@
+package linux
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLinux(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Linux Suite")
+}
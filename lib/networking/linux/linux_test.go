@@ -0,0 +1,302 @@
+package linux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const originalManagerConfig = "[main]\nplugins=ifupdown,keyfile\n\n[ifupdown]\nmanaged=false\n"
+const enabledManagerConfig = "[main]\ndns=dnsmasq\nplugins=ifupdown,keyfile\n\n[ifupdown]\nmanaged=false\n"
+const originalResolv = "nameserver 1.1.1.1\n"
+
+var _ = Describe("Linux", func() {
+	var (
+		root      string
+		fs        files.FileSystem
+		argList   []string
+		err       error
+		present   bool
+		cmdRunner = func(cmd string) error {
+			argList = append(argList, cmd)
+			return err
+		}
+		hasAddress = func(string, string) (bool, error) { return present, nil }
+		conflict   error
+		conflicts  = func(string) error { return conflict }
+		address    = "192.168.40.1"
+	)
+
+	readFile := func(path string) string {
+		contents, err := fs.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	exists := func(path string) bool {
+		present, err := fs.Exists(path)
+		Expect(err).NotTo(HaveOccurred())
+		return present
+	}
+
+	BeforeEach(func() {
+		root = GinkgoT().TempDir()
+		fs = files.NewRootedFileSystem(root)
+		argList = make([]string, 0)
+		err = nil
+		present = false
+		conflict = nil
+
+		Expect(fs.WriteFile(managerConfigFilePath, []byte(originalManagerConfig))).To(Succeed())
+		Expect(fs.WriteFile(managerResolvFilePath, []byte("nameserver 127.0.0.1\n"))).To(Succeed())
+		Expect(fs.WriteFile(resolvFilePath, []byte(originalResolv))).To(Succeed())
+	})
+
+	Describe("enableDnsmasq", func() {
+		It("adds the dnsmasq line to the main section and records the change", func() {
+			Expect(enableDnsmasq(fs)).To(Equal(journal.Changes{ModifiedFiles: []string{managerConfigFilePath}}))
+			Expect(readFile(managerConfigFilePath)).To(Equal(enabledManagerConfig))
+		})
+
+		It("doesn't add or record the line twice", func() {
+			Expect(enableDnsmasq(fs)).Error().NotTo(HaveOccurred())
+			Expect(enableDnsmasq(fs)).To(Equal(journal.Changes{}))
+			Expect(readFile(managerConfigFilePath)).To(Equal(enabledManagerConfig))
+		})
+
+		It("handles a main section without a trailing newline", func() {
+			Expect(fs.WriteFile(managerConfigFilePath, []byte("[main]"))).To(Succeed())
+
+			Expect(enableDnsmasq(fs)).Error().NotTo(HaveOccurred())
+			Expect(readFile(managerConfigFilePath)).To(Equal("[main]\ndns=dnsmasq\n"))
+		})
+
+		It("returns an error if there's no main section", func() {
+			Expect(fs.WriteFile(managerConfigFilePath, []byte("[ifupdown]\nmanaged=false\n"))).To(Succeed())
+
+			Expect(enableDnsmasq(fs)).Error().To(HaveOccurred())
+		})
+
+		It("returns an error if the config file doesn't exist", func() {
+			Expect(fs.Remove(managerConfigFilePath)).To(Succeed())
+
+			Expect(enableDnsmasq(fs)).Error().To(HaveOccurred())
+		})
+	})
+
+	Describe("disableDnsmasq", func() {
+		modified := journal.Changes{ModifiedFiles: []string{managerConfigFilePath}}
+
+		It("removes the dnsmasq line", func() {
+			Expect(fs.WriteFile(managerConfigFilePath, []byte(enabledManagerConfig))).To(Succeed())
+
+			Expect(disableDnsmasq(fs, modified)).To(Succeed())
+			Expect(readFile(managerConfigFilePath)).To(Equal(originalManagerConfig))
+		})
+
+		It("leaves the file alone if dnsmasq isn't enabled", func() {
+			Expect(disableDnsmasq(fs, modified)).To(Succeed())
+			Expect(readFile(managerConfigFilePath)).To(Equal(originalManagerConfig))
+		})
+
+		It("leaves the line alone if falcon didn't add it", func() {
+			Expect(fs.WriteFile(managerConfigFilePath, []byte(enabledManagerConfig))).To(Succeed())
+
+			Expect(disableDnsmasq(fs, journal.Changes{})).To(Succeed())
+			Expect(readFile(managerConfigFilePath)).To(Equal(enabledManagerConfig))
+		})
+	})
+
+	Describe("backupResolvFile", func() {
+		It("moves resolv.conf into the backup location and records the backup", func() {
+			changes, err := backupResolvFile(fs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.BackedUpFiles).To(HaveKeyWithValue(resolvFilePath, backupFilePath))
+
+			Expect(exists(resolvFilePath)).To(BeFalse())
+			Expect(readFile(backupFilePath)).To(Equal(originalResolv))
+		})
+
+		It("doesn't overwrite an existing backup", func() {
+			Expect(backupResolvFile(fs)).Error().NotTo(HaveOccurred())
+			Expect(fs.WriteFile(resolvFilePath, []byte("nameserver 127.0.0.1\n"))).To(Succeed())
+
+			Expect(backupResolvFile(fs)).Error().NotTo(HaveOccurred())
+			Expect(readFile(backupFilePath)).To(Equal(originalResolv))
+		})
+
+		It("records that resolv.conf will be created if there isn't one", func() {
+			Expect(fs.Remove(resolvFilePath)).To(Succeed())
+
+			Expect(backupResolvFile(fs)).To(Equal(journal.Changes{CreatedFiles: []string{resolvFilePath}}))
+		})
+	})
+
+	Describe("restoreResolvFile", func() {
+		It("moves the backup back into place", func() {
+			changes, err := backupResolvFile(fs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(letManagerManageResolv(fs)).To(Succeed())
+
+			Expect(restoreResolvFile(fs, changes)).To(Succeed())
+
+			Expect(readFile(resolvFilePath)).To(Equal(originalResolv))
+			Expect(exists(backupFilePath)).To(BeFalse())
+		})
+
+		It("removes the symlink if there was no resolv.conf to backup", func() {
+			Expect(fs.Remove(resolvFilePath)).To(Succeed())
+			changes, err := backupResolvFile(fs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(letManagerManageResolv(fs)).To(Succeed())
+
+			Expect(restoreResolvFile(fs, changes)).To(Succeed())
+			Expect(exists(resolvFilePath)).To(BeFalse())
+		})
+
+		It("returns an error if the recorded backup is missing", func() {
+			changes := journal.Changes{BackedUpFiles: map[string]string{resolvFilePath: backupFilePath}}
+
+			Expect(restoreResolvFile(fs, changes)).NotTo(Succeed())
+			Expect(readFile(resolvFilePath)).To(Equal(originalResolv))
+		})
+
+		It("does nothing if falcon didn't touch resolv.conf", func() {
+			Expect(restoreResolvFile(fs, journal.Changes{})).To(Succeed())
+			Expect(readFile(resolvFilePath)).To(Equal(originalResolv))
+		})
+	})
+
+	Describe("letManagerManageResolv", func() {
+		It("links resolv.conf to NetworkManager's resolv.conf", func() {
+			Expect(backupResolvFile(fs)).Error().NotTo(HaveOccurred())
+			Expect(letManagerManageResolv(fs)).To(Succeed())
+
+			target, err := os.Readlink(filepath.Join(root, resolvFilePath))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(Equal(filepath.Join(root, managerResolvFilePath)))
+		})
+	})
+
+	Describe("createDockerConfFile", func() {
+		It("writes the address line for every TLD", func() {
+			Expect(createDockerConfFile(fs, []string{"docker", "test"}, address)).To(Equal(journal.Changes{CreatedFiles: []string{dockerConfFilePath}}))
+			Expect(readFile(dockerConfFilePath)).To(Equal("address=/docker/test/192.168.40.1\n"))
+		})
+	})
+
+	Describe("deleteDockerConfFile", func() {
+		It("removes the config", func() {
+			changes, err := createDockerConfFile(fs, []string{"docker"}, address)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(deleteDockerConfFile(fs, changes)).To(Succeed())
+			Expect(exists(dockerConfFilePath)).To(BeFalse())
+		})
+
+		It("doesn't error if the config doesn't exist", func() {
+			Expect(deleteDockerConfFile(fs, journal.Changes{CreatedFiles: []string{dockerConfFilePath}})).To(Succeed())
+		})
+	})
+
+	Describe("adding the loopback address", func() {
+		It("adds the address to lo and records it", func() {
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), "10.254.254.254")).To(Equal(journal.Changes{LoopbackAddress: "10.254.254.254"}))
+			Expect(argList[0]).To(Equal(createAddLoopbackAddressCmd(loopbackInterface, "10.254.254.254")))
+		})
+
+		It("doesn't add or record an address that's already there", func() {
+			present = true
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), address)).To(Equal(journal.Changes{}))
+			Expect(argList).To(BeEmpty())
+		})
+
+		It("doesn't add an address that one of the host's networks uses", func() {
+			conflict = fmt.Errorf("already reachable")
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), address)).Error().To(Equal(conflict))
+			Expect(argList).To(BeEmpty())
+		})
+
+		It("ignores errors from the address already existing", func() {
+			err = fmt.Errorf("RTNETLINK answers: File exists")
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), address)).Error().NotTo(HaveOccurred())
+		})
+
+		It("returns any other error", func() {
+			err = fmt.Errorf("didn't work :(")
+
+			Expect(loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(loopbackInterface), address)).Error().To(Equal(err))
+		})
+	})
+
+	Describe("removing the loopback address", func() {
+		added := journal.Changes{LoopbackAddress: "192.168.40.2"}
+
+		It("removes the address that falcon recorded adding", func() {
+			Expect(loopback.Remove(cmdRunner, loopbackCommands(loopbackInterface), added)).To(Succeed())
+			Expect(argList).To(Equal([]string{"sudo ip addr del 192.168.40.2/32 dev lo"}))
+		})
+
+		It("leaves the loopback interface alone if falcon didn't add an address", func() {
+			Expect(loopback.Remove(cmdRunner, loopbackCommands(loopbackInterface), journal.Changes{})).To(Succeed())
+			Expect(argList).To(BeEmpty())
+		})
+
+		It("ignores errors from the address already being gone", func() {
+			err = fmt.Errorf("RTNETLINK answers: Cannot assign requested address")
+
+			Expect(loopback.Remove(cmdRunner, loopbackCommands(loopbackInterface), added)).To(Succeed())
+		})
+
+		It("returns any other error", func() {
+			err = fmt.Errorf("didn't work :(")
+
+			Expect(loopback.Remove(cmdRunner, loopbackCommands(loopbackInterface), added)).To(Equal(err))
+		})
+	})
+
+	Describe("networkManagerSteps", func() {
+		var j *journal.Journal
+
+		BeforeEach(func() {
+			var openErr error
+			j, openErr = journal.Open(filepath.Join(GinkgoT().TempDir(), "journal.json"))
+			Expect(openErr).NotTo(HaveOccurred())
+		})
+
+		It("configures NetworkManager and then restores everything", func() {
+			Expect(j.Apply(networkManagerSteps(fs, cmdRunner, hasAddress, conflicts, []string{"docker"}, address))).To(Succeed())
+
+			Expect(readFile(managerConfigFilePath)).To(Equal(enabledManagerConfig))
+			Expect(readFile(dockerConfFilePath)).To(Equal(createDockerConfLine([]string{"docker"}, address)))
+			Expect(readFile(backupFilePath)).To(Equal(originalResolv))
+			Expect(argList).To(Equal([]string{createAddLoopbackAddressCmd(loopbackInterface, address), reloadNetworkManagerCmd}))
+
+			argList = make([]string, 0)
+			Expect(j.Undo(networkManagerSteps(fs, cmdRunner, hasAddress, conflicts, []string{"docker"}, address))).To(Succeed())
+
+			Expect(readFile(managerConfigFilePath)).To(Equal(originalManagerConfig))
+			Expect(readFile(resolvFilePath)).To(Equal(originalResolv))
+			Expect(exists(dockerConfFilePath)).To(BeFalse())
+			Expect(exists(backupFilePath)).To(BeFalse())
+			Expect(argList).To(Equal([]string{reloadNetworkManagerCmd, createRemoveLoopbackAddressCmd(loopbackInterface, "192.168.40.1")}))
+		})
+
+		It("rolls back the loopback address if dnsmasq can't be enabled", func() {
+			Expect(fs.WriteFile(managerConfigFilePath, []byte("[ifupdown]\nmanaged=false\n"))).To(Succeed())
+
+			Expect(j.Apply(networkManagerSteps(fs, cmdRunner, hasAddress, conflicts, []string{"docker"}, address))).NotTo(Succeed())
+			Expect(argList).To(Equal([]string{createAddLoopbackAddressCmd(loopbackInterface, address), createRemoveLoopbackAddressCmd(loopbackInterface, "192.168.40.1")}))
+			Expect(j.Empty()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,42 @@
+package linux
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+)
+
+const netmask = "32"
+
+// The interface we add our loopback address to, unless the backend needs it on a link of its own.
+const loopbackInterface = "lo"
+
+// Allows us to check whether the loopback address was already added or already deleted, so that
+// running falcon up or falcon down multiple times in a row doesn't error.
+var loopbackAlreadyAddedRegex = regexp.MustCompile("(File exists)")
+var loopbackAlreadyDeletedRegex = regexp.MustCompile("(Cannot assign requested address)")
+
+// loopbackCommands returns the commands that add falcon's loopback address to the specified device
+// and remove it again.
+func loopbackCommands(device string) loopback.Commands {
+	return loopback.Commands{
+		Interface:      device,
+		Add:            func(address string) string { return createAddLoopbackAddressCmd(device, address) },
+		Remove:         func(address string) string { return createRemoveLoopbackAddressCmd(device, address) },
+		AlreadyAdded:   loopbackAlreadyAddedRegex,
+		AlreadyRemoved: loopbackAlreadyDeletedRegex,
+	}
+}
+
+// createAddLoopbackAddressCmd creates the command that adds the specified loopback address to the
+// specified device.
+func createAddLoopbackAddressCmd(device string, address string) string {
+	return fmt.Sprintf("sudo ip addr add %v/%v dev %v", address, netmask, device)
+}
+
+// createRemoveLoopbackAddressCmd creates the command that removes the specified loopback address
+// from the specified device.
+func createRemoveLoopbackAddressCmd(device string, address string) string {
+	return fmt.Sprintf("sudo ip addr del %v/%v dev %v", address, netmask, device)
+}
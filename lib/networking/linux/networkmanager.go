@@ -1,17 +1,16 @@
-// The networkmanager package contains all of the functions necessary to set up a Linux machine
-// to enable NetworkManager dnsmasq and let NetworkManager control resolv.conf
 package linux
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"regexp"
+	"strings"
 
-	"github.com/Hawkbawk/falcon/lib/dnsmasq"
 	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
 	"github.com/Hawkbawk/falcon/lib/logger"
-	"github.com/Hawkbawk/falcon/lib/shell"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
 )
 
 const managerConfigFilePath = "/etc/NetworkManager/NetworkManager.conf"
@@ -19,130 +18,134 @@ const managerResolvFilePath = "/var/run/NetworkManager/resolv.conf"
 const dockerConfFilePath = "/etc/NetworkManager/dnsmasq.d/docker.conf"
 const dnsmasqLine = "dns=dnsmasq\n"
 
-// This is the secret sauce that allows falcon to work better than dory. This new
-// loopback address ensures that intercontainer communication works properly, as requests
-// to *.docker will resolve to this address, rather than 127.0.0.1. This ensures that all
-// requests from one container to another actually go through the proxy and don't get stuck
-// inside the container itself.
-const loopbackAddress = "192.168.40.1"
-const netmask = "32"
-
-// This line will need to be updated, as we no longer set a static IP for the proxy, which means
-// we need to dynamically determine the IP address.
-var dockerConfLine string = fmt.Sprint("address=/docker/", loopbackAddress)
-var mainSectionRegex *regexp.Regexp = regexp.MustCompile(`\[main\]`)
-var dnsmasqEnabledRegex *regexp.Regexp = regexp.MustCompile(`^dns=dnsmasq$`)
-
-// OpenManagerConfigFile gets a file handler for the NetworkManager config file.
-func openManagerConfigFile() *os.File {
-	return files.OpenFile(managerConfigFilePath)
-}
+var mainSectionRegex *regexp.Regexp = regexp.MustCompile(`(?m)^\[main\][ \t]*\n?`)
+var dnsmasqEnabledRegex *regexp.Regexp = regexp.MustCompile(`(?m)^dns=dnsmasq[ \t]*\n?`)
+var reloadNetworkManagerCmd string = "sudo systemctl reload NetworkManager"
 
-// closeConfigFile closes the file handler for the configFile. It's a useful helper method
-// for deferring cleanup to. If it encounters any errors, it ends the program.
-func closeConfigFile(configFile *os.File) {
-	err := configFile.Close()
+// enableDnsmasq writes the necessary line to the NetworkManager.conf file to enable dnsmasq
+// for the machine, unless it's already enabled.
+func enableDnsmasq(fs files.FileSystem) (journal.Changes, error) {
+	contents, err := fs.ReadFile(managerConfigFilePath)
 
 	if err != nil {
-		logger.LogError("Unable to close config file. This is likely due to a bug.")
-	}
-}
-
-// EnableDnsmasq writes the necessary line to the NetworkManager.conf file to enable dnsmasq
-// for the machine.
-func enableDnsmasq(configFile *os.File) {
-	if dnsMasqEnabled(configFile) {
-		return
+		return journal.Changes{}, err
+	} else if dnsmasqEnabledRegex.Match(contents) {
+		return journal.Changes{}, nil
 	}
 
-	previousContents := files.ReadFile(configFile)
-	newConfigFile := make([]byte, files.FileSize(configFile)+int64(len(dnsmasqLine)))
-
-	indices := mainSectionRegex.FindIndex(previousContents)
+	indices := mainSectionRegex.FindIndex(contents)
 
 	if indices == nil {
-		logger.LogError("You don't have a main section in your NetworkManager.conf! You should probably add one.")
+		return journal.Changes{}, fmt.Errorf("you don't have a [main] section in %v. You should probably add one", managerConfigFilePath)
 	}
 
-	beforeMain := previousContents[:indices[0]]
-	mainLine := previousContents[indices[0]:indices[1]]
-	afterMain := previousContents[indices[1]:]
+	var newContents bytes.Buffer
+	newContents.Write(contents[:indices[1]])
+	// The [main] header might be the last line of the file without a trailing newline.
+	if !bytes.HasSuffix(contents[:indices[1]], []byte("\n")) {
+		newContents.WriteString("\n")
+	}
+	newContents.WriteString(dnsmasqLine)
+	newContents.Write(contents[indices[1]:])
 
-	// Copy everything into this new config file, including the line that adds dnsmasq.
-	copy(newConfigFile, beforeMain)
-	copy(newConfigFile, mainLine)
-	copy(newConfigFile, []byte(dnsmasqLine))
-	copy(newConfigFile, afterMain)
+	logger.LogInfo("Requesting sudo to enable dnsmasq in %v...", managerConfigFilePath)
+	if err := fs.WriteFile(managerConfigFilePath, newContents.Bytes()); err != nil {
+		return journal.Changes{}, err
+	}
 
-	files.OverwriteFile(configFile, newConfigFile)
+	return journal.Changes{ModifiedFiles: []string{managerConfigFilePath}}, nil
 }
 
-// DisableDnsmasq removes the line necessary in NetworkManager.conf to enable dnsmasq for
-// the system.
-func disableDnsmasq(configFile *os.File) {
-	if !dnsMasqEnabled(configFile) {
-		return
+// disableDnsmasq removes the line necessary in NetworkManager.conf to enable dnsmasq for
+// the system, if falcon was the one that added it.
+func disableDnsmasq(fs files.FileSystem, changes journal.Changes) error {
+	if !changes.Modified(managerConfigFilePath) {
+		return nil
 	}
 
-	previousContents := files.ReadFile(configFile)
-	restoredConfigFile := make([]byte, files.FileSize(configFile)-int64(len(dnsmasqLine)))
-	// Indices cannot be nil, as we have already checked to see if dnsmasq was disabled and
-	// both methods use the same regex.
-	indices := dnsmasqEnabledRegex.FindIndex(previousContents)
+	contents, err := fs.ReadFile(managerConfigFilePath)
 
-	beforeDnsmasq := previousContents[:indices[0]]
-	afterDnsmasq := previousContents[indices[1]:]
+	if err != nil {
+		return err
+	}
 
-	copy(restoredConfigFile, beforeDnsmasq)
-	copy(restoredConfigFile, afterDnsmasq)
+	indices := dnsmasqEnabledRegex.FindIndex(contents)
 
-	files.OverwriteFile(configFile, restoredConfigFile)
-}
+	if indices == nil {
+		return nil
+	}
 
-// dnsmasqEnabled determines whether dnsmasq through NetworkManager has already been enabled.
-func dnsMasqEnabled(configFile *os.File) bool {
-	fileContents := files.ReadFile(configFile)
+	var newContents bytes.Buffer
+	newContents.Write(contents[:indices[0]])
+	newContents.Write(contents[indices[1]:])
 
-	return dnsmasqEnabledRegex.Match(fileContents)
+	logger.LogInfo("Requesting sudo to disable dnsmasq in %v...", managerConfigFilePath)
+	return fs.WriteFile(managerConfigFilePath, newContents.Bytes())
 }
 
-func letManagerManageResolv() {
-	files.Symlink(managerResolvFilePath, resolvFilePath)
+// letManagerManageResolv replaces /etc/resolv.conf with a symlink to the resolv.conf that
+// NetworkManager generates, which points at its dnsmasq instance.
+func letManagerManageResolv(fs files.FileSystem) error {
+	logger.LogInfo("Requesting sudo to link %v to %v...", resolvFilePath, managerResolvFilePath)
+	return fs.Symlink(managerResolvFilePath, resolvFilePath)
 }
 
-func stopManagerManagingResolv() {
-	files.DeleteFile(resolvFilePath)
+// createDockerConfLine creates the line that tells NetworkManager's dnsmasq to resolve all
+// requests for domains under the specified TLDs to the specified loopback address.
+func createDockerConfLine(tlds []string, address string) string {
+	return fmt.Sprintf("address=/%v/%v\n", strings.Join(tlds, "/"), address)
 }
 
-func createDockerConfFile() {
-	dockerConfFile := files.CreateFile(dockerConfFilePath)
+// createDockerConfFile adds the dnsmasq config that resolves the specified TLDs to the specified
+// loopback address. The file is only ever used by falcon, so anything that's already there is
+// simply replaced, including the config for any TLDs that are no longer configured.
+func createDockerConfFile(fs files.FileSystem, tlds []string, address string) (journal.Changes, error) {
+	logger.LogInfo("Requesting sudo to write to %v...", dockerConfFilePath)
+	if err := fs.WriteFile(dockerConfFilePath, []byte(createDockerConfLine(tlds, address))); err != nil {
+		return journal.Changes{}, err
+	}
 
-	io.WriteString(dockerConfFile, dockerConfLine)
+	return journal.Changes{CreatedFiles: []string{dockerConfFilePath}}, nil
+}
 
-	if err := dockerConfFile.Close(); err != nil {
-		logger.LogError("Unable to create dnsmasq docker.conf file. Error: ", err.Error())
+// deleteDockerConfFile removes the previously added dnsmasq config, if falcon created it.
+func deleteDockerConfFile(fs files.FileSystem, changes journal.Changes) error {
+	if !changes.Created(dockerConfFilePath) {
+		return nil
 	}
-}
 
-func deleteDockerConfFile() {
-	files.DeleteFile(dockerConfFilePath)
+	logger.LogInfo("Requesting sudo to remove %v...", dockerConfFilePath)
+	return fs.Remove(dockerConfFilePath)
 }
 
-func addLoopbackAddress() {
-	if err := shell.RunCommand(fmt.Sprintf("sudo ip addr add %v/%v dev lo", dnsmasq.LoopbackAddress, netmask)); err != nil {
-		logger.LogError("Unable to add the loopback address required by falcon. ERROR: ", err)
-	}
+// reloadNetworkManager tells NetworkManager to pick up the changes we've made to its config.
+func reloadNetworkManager(cmdRunner func(string) error) error {
+	logger.LogInfo("Requesting sudo to reload NetworkManager...")
+	return cmdRunner(reloadNetworkManagerCmd)
 }
 
-func removeLoopbackAddress() {
-	if err := shell.RunCommand(fmt.Sprintf("sudo ip addr del %v/%v dev lo", dnsmasq.LoopbackAddress, netmask)); err != nil {
-		logger.LogError("Unable to remove the loopback address added by falcon. ERROR: ", err)
+// networkManagerStatus checks that dnsmasq is enabled, the config for the specified TLDs is in
+// place and the loopback address exists, returning a description of each one that isn't.
+func networkManagerStatus(fs files.FileSystem, hasAddress func(string, string) (bool, error), tlds []string, address string) ([]string, error) {
+	problems, err := loopback.Status(hasAddress, loopbackInterface, address)
 
+	if err != nil {
+		return nil, err
 	}
-}
 
-func reloadNetworkManager() {
-	if err := shell.RunCommand("sudo systemctl reload NetworkManager"); err != nil {
-		logger.LogError("Unable to restart NetworkManager. Error: ", err.Error())
+	if contents, err := fs.ReadFile(managerConfigFilePath); err != nil {
+		return nil, err
+	} else if !dnsmasqEnabledRegex.Match(contents) {
+		problems = append(problems, fmt.Sprintf("dnsmasq isn't enabled in %v", managerConfigFilePath))
 	}
+
+	if contents, err := fs.ReadFile(dockerConfFilePath); os.IsNotExist(err) {
+		problems = append(problems, fmt.Sprintf("%v is missing", dockerConfFilePath))
+	} else if err != nil {
+		return nil, err
+	} else if string(contents) != createDockerConfLine(tlds, address) {
+		problems = append(problems, fmt.Sprintf("%v doesn't point %v at %v", dockerConfFilePath, strings.Join(tlds, ", "), address))
+	}
+
+	return problems, nil
 }
@@ -1,30 +1,71 @@
 package linux
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
 	"github.com/Hawkbawk/falcon/lib/logger"
 )
 
 const resolvFilePath = "/etc/resolv.conf"
-const backupFilePath = "~/go/github.com/falcon/backups"
 
-// Moves the current resolv.conf file into the backup directory located at
-// ~/go/github.com/falcon/backups. In the future, a more universal backup location will likely
-// be added.
-func moveResolvFile() {
-	err := os.Rename(resolvFilePath, backupFilePath)
+// Where we keep the original resolv.conf while NetworkManager is managing it. This lives next to
+// the rest of falcon's files in ~/.falcon.
+var backupFilePath = fmt.Sprintf("%v/.falcon/backups/resolv.conf", os.Getenv("HOME"))
 
-	if err != nil {
-		logger.LogError("Unable to backup your resolv file.")
+// backupResolvFile moves the current resolv.conf file into the backup directory. If a backup
+// already exists, falcon up has already been run, so the current resolv.conf is the one that
+// NetworkManager manages and we leave the original backup alone.
+func backupResolvFile(fs files.FileSystem) (journal.Changes, error) {
+	backedUp := journal.Changes{BackedUpFiles: map[string]string{resolvFilePath: backupFilePath}}
+
+	if exists, err := fs.Exists(backupFilePath); err != nil {
+		return journal.Changes{}, err
+	} else if exists {
+		return backedUp, nil
+	}
+
+	if present, err := fs.Exists(resolvFilePath); err != nil {
+		return journal.Changes{}, err
+	} else if !present {
+		return journal.Changes{CreatedFiles: []string{resolvFilePath}}, nil
 	}
+
+	logger.LogInfo("Requesting sudo to backup %v to %v...", resolvFilePath, backupFilePath)
+	if err := fs.Rename(resolvFilePath, backupFilePath); err != nil {
+		return journal.Changes{}, err
+	}
+
+	return backedUp, nil
 }
 
-// Moves the backed up resolv.conf file back to it's usual spot at /etc/resolv.conf.
-func restoreResolvFile() {
-	err := os.Rename(backupFilePath, resolvFilePath)
+// restoreResolvFile moves the backed up resolv.conf file back to it's usual spot at
+// /etc/resolv.conf. If there wasn't a resolv.conf to backup in the first place, the one falcon
+// created is removed instead.
+func restoreResolvFile(fs files.FileSystem, changes journal.Changes) error {
+	backupPath, ok := changes.BackedUpFiles[resolvFilePath]
 
-	if err != nil {
-		logger.LogError("Unable to restore your backed up resolve file. It may have been deleted.")
+	if !ok {
+		if changes.Created(resolvFilePath) {
+			logger.LogInfo("Requesting sudo to remove %v...", resolvFilePath)
+			return fs.Remove(resolvFilePath)
+		}
+
+		return nil
+	}
+
+	if backedUp, err := fs.Exists(backupPath); err != nil {
+		return err
+	} else if !backedUp {
+		return fmt.Errorf("the backup of %v at %v is missing, so it can't be restored", resolvFilePath, backupPath)
 	}
+
+	logger.LogInfo("Requesting sudo to restore %v from %v...", resolvFilePath, backupPath)
+	if err := fs.Remove(resolvFilePath); err != nil {
+		return err
+	}
+
+	return fs.Rename(backupPath, resolvFilePath)
 }
@@ -0,0 +1,312 @@
+package linux
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/logger"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+)
+
+// systemd-resolved creates this directory whenever it's running, which gives us a cheap way to
+// detect it without having to ask systemd.
+const resolvedRuntimeDir = "/run/systemd/resolve"
+
+// The dummy link systemd-resolved sends queries for falcon's domains through. DNS servers configured
+// globally, like in a resolved.conf drop-in, are asked about every name, whatever their routing
+// domains, so falcon's server is configured on a link of its own instead. systemd-resolved ignores
+// the loopback interface, and only uses a link with an address on it, so the loopback address is
+// added to this link rather than to lo.
+const resolvedLink = loopback.FalconInterface
+
+// The systemd-networkd config we install for falcon's link, so that machines running networkd
+// create the link and point systemd-resolved at it again after a reboot or a restart of resolved.
+// falcon sets the link up itself too, since networkd isn't running everywhere resolved is.
+var (
+	resolvedNetDevPath  = fmt.Sprintf("/etc/systemd/network/50-%v.netdev", resolvedLink)
+	resolvedNetworkPath = fmt.Sprintf("/etc/systemd/network/50-%v.network", resolvedLink)
+	resolvedConfigPaths = []string{resolvedNetDevPath, resolvedNetworkPath}
+)
+
+// Allows us to check whether the link was already deleted, so that running falcon down twice in a
+// row doesn't error.
+var linkAlreadyDeletedRegex = regexp.MustCompile(`(Cannot find device)`)
+
+// usingResolved determines whether systemd-resolved is managing DNS on the machine.
+func usingResolved(fs files.FileSystem) (bool, error) {
+	return fs.Exists(resolvedRuntimeDir)
+}
+
+// resolvedSteps installs the networkd config for falcon's link, creates the link, adds the
+// specified loopback address to it and then points systemd-resolved at the DNS server on that
+// address and the specified port for domains under the specified TLDs only. Each step does one
+// thing, so that a failure partway through is undone exactly.
+func resolvedSteps(fs files.FileSystem, cmdRunner func(string) error, hasAddress func(string, string) (bool, error), hasLink func(string) (bool, error), conflicts func(string) error, tlds []string, address string, port int) []journal.Step {
+	return []journal.Step{
+		{
+			Name:        "resolved-config",
+			Description: fmt.Sprintf("add the systemd-networkd config for the %v link", resolvedLink),
+			Apply:       func() (journal.Changes, error) { return addResolvedConfig(fs, tlds, address, port) },
+			Undo:        func(changes journal.Changes) error { return removeResolvedConfig(fs, changes) },
+		},
+		{
+			Name:        "resolved-link",
+			Description: fmt.Sprintf("create the %v link", resolvedLink),
+			Apply:       func() (journal.Changes, error) { return addResolvedLink(cmdRunner, hasLink) },
+			Undo:        func(changes journal.Changes) error { return removeResolvedLink(cmdRunner, changes) },
+		},
+		{
+			Name:        "loopback",
+			Description: "add loopback address",
+			Apply: func() (journal.Changes, error) {
+				return loopback.Add(cmdRunner, hasAddress, conflicts, loopbackCommands(resolvedLink), address)
+			},
+			Undo: func(changes journal.Changes) error {
+				return loopback.Remove(cmdRunner, loopbackCommands(resolvedLink), changes)
+			},
+		},
+		{
+			Name:        "resolved-dns",
+			Description: fmt.Sprintf("point systemd-resolved at falcon's DNS server for %v", strings.Join(tlds, ", ")),
+			Apply: func() (journal.Changes, error) {
+				logger.LogInfo("Requesting sudo to point systemd-resolved at falcon's DNS server...")
+				return journal.Changes{}, cmdRunner(createSetLinkDNSCmd(tlds, address, port))
+			},
+			Undo: func(journal.Changes) error { return revertResolvedLink(cmdRunner, hasLink) },
+		},
+	}
+}
+
+// addResolvedConfig writes the networkd config for falcon's link, which points systemd-resolved at
+// the DNS server on the specified address and port for the specified TLDs.
+func addResolvedConfig(fs files.FileSystem, tlds []string, address string, port int) (journal.Changes, error) {
+	changes := journal.Changes{}
+
+	config := resolvedConfig(tlds, address, port)
+	for _, path := range resolvedConfigPaths {
+		logger.LogInfo("Requesting sudo to write to %v...", path)
+		if err := fs.WriteFile(path, []byte(config[path])); err != nil {
+			// Any file already written is returned with the error to be removed again.
+			return changes, err
+		}
+		changes.CreatedFiles = append(changes.CreatedFiles, path)
+	}
+
+	return changes, nil
+}
+
+// removeResolvedConfig removes the networkd config for falcon's link, if falcon created it.
+func removeResolvedConfig(fs files.FileSystem, changes journal.Changes) error {
+	for _, path := range resolvedConfigPaths {
+		if !changes.Created(path) {
+			continue
+		}
+
+		logger.LogInfo("Requesting sudo to remove %v...", path)
+		if err := fs.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolvedConfig maps the path of each networkd config file for falcon's link to its contents.
+// DNSDefaultRoute=no keeps systemd-resolved from asking the server about any other names.
+func resolvedConfig(tlds []string, address string, port int) map[string]string {
+	return map[string]string{
+		resolvedNetDevPath: fmt.Sprintf(`# Added by falcon. This file is removed when you run falcon down.
+[NetDev]
+Name=%v
+Kind=dummy
+`, resolvedLink),
+		resolvedNetworkPath: fmt.Sprintf(`# Added by falcon. This file is removed when you run falcon down.
+[Match]
+Name=%v
+
+[Network]
+Address=%v/32
+DNS=%v
+Domains=%v
+DNSDefaultRoute=no
+`, resolvedLink, address, resolvedServer(address, port), strings.Join(routingDomains(tlds), " ")),
+	}
+}
+
+// addResolvedLink creates falcon's dummy link, unless it's already there, and brings it up.
+func addResolvedLink(cmdRunner func(string) error, hasLink func(string) (bool, error)) (journal.Changes, error) {
+	present, err := hasLink(resolvedLink)
+	if err != nil {
+		return journal.Changes{}, err
+	}
+
+	changes := journal.Changes{}
+	if !present {
+		logger.LogInfo("Requesting sudo to create the %v link...", resolvedLink)
+		if err := cmdRunner(createAddLinkCmd()); err != nil {
+			return journal.Changes{}, err
+		}
+		changes.Link = resolvedLink
+	}
+
+	// The link has been created, so it's returned with the error to be removed again.
+	return changes, cmdRunner(createLinkUpCmd())
+}
+
+// removeResolvedLink removes falcon's link, if falcon was the one that created it. Removing the
+// link also removes any DNS settings systemd-resolved has for it.
+func removeResolvedLink(cmdRunner func(string) error, changes journal.Changes) error {
+	if changes.Link == "" {
+		return nil
+	}
+
+	logger.LogInfo("Requesting sudo to remove the %v link...", changes.Link)
+	err := cmdRunner(createRemoveLinkCmd(changes.Link))
+
+	if err != nil && !linkAlreadyDeletedRegex.MatchString(err.Error()) {
+		return err
+	}
+
+	return nil
+}
+
+// revertResolvedLink drops the DNS settings falcon gave systemd-resolved for its link, if the link
+// is still there.
+func revertResolvedLink(cmdRunner func(string) error, hasLink func(string) (bool, error)) error {
+	if present, err := hasLink(resolvedLink); err != nil || !present {
+		return err
+	}
+
+	logger.LogInfo("Requesting sudo to stop systemd-resolved using falcon's DNS server...")
+	return cmdRunner(createRevertLinkDNSCmd())
+}
+
+// createAddLinkCmd creates the command that creates falcon's dummy link.
+func createAddLinkCmd() string {
+	return fmt.Sprintf("sudo ip link add %v type dummy", resolvedLink)
+}
+
+// createLinkUpCmd creates the command that brings falcon's link up.
+func createLinkUpCmd() string {
+	return fmt.Sprintf("sudo ip link set %v up", resolvedLink)
+}
+
+// createRemoveLinkCmd creates the command that removes the link with the specified name.
+func createRemoveLinkCmd(link string) string {
+	return fmt.Sprintf("sudo ip link del %v", link)
+}
+
+// createSetLinkDNSCmd creates the command that points systemd-resolved at the DNS server on the
+// specified address and port for falcon's link, with each of the specified TLDs as a routing-only
+// domain. The "~" keeps resolved from using the TLDs as search domains, and a link with only
+// routing-only domains isn't used for any other names.
+func createSetLinkDNSCmd(tlds []string, address string, port int) string {
+	return fmt.Sprintf("sudo resolvectl dns %v %v && sudo resolvectl domain %v %v", resolvedLink, resolvedServer(address, port), resolvedLink, strings.Join(routingDomains(tlds), " "))
+}
+
+// createRevertLinkDNSCmd creates the command that drops the DNS settings for falcon's link.
+func createRevertLinkDNSCmd() string {
+	return fmt.Sprintf("sudo resolvectl revert %v", resolvedLink)
+}
+
+// createShowLinkDNSCmd creates the command that prints the DNS servers and domains systemd-resolved
+// has for falcon's link.
+func createShowLinkDNSCmd() string {
+	return fmt.Sprintf("resolvectl dns %v && resolvectl domain %v", resolvedLink, resolvedLink)
+}
+
+// resolvedServer returns the DNS server on the specified address and port the way resolvectl
+// expects it. systemd-resolved only understands a port after the address in version 246 and newer,
+// so we leave it off unless it's needed.
+func resolvedServer(address string, port int) string {
+	if port != listen.DefaultDNSPort {
+		return fmt.Sprintf("%v:%v", address, port)
+	}
+	return address
+}
+
+// routingDomains returns the routing-only domain for each of the specified TLDs.
+func routingDomains(tlds []string) []string {
+	domains := make([]string, 0, len(tlds))
+
+	for _, tld := range tlds {
+		domains = append(domains, "~"+tld)
+	}
+
+	return domains
+}
+
+// resolvedStatus checks that the networkd config, falcon's link, the loopback address on it and its
+// DNS settings are in place, returning a description of each one that isn't. The DNS settings are
+// read with output, which runs a command and returns what it printed.
+func resolvedStatus(fs files.FileSystem, hasAddress func(string, string) (bool, error), hasLink func(string) (bool, error), output func(string) (string, error), tlds []string, address string, port int) ([]string, error) {
+	problems := make([]string, 0)
+
+	for _, path := range resolvedConfigPaths {
+		if contents, err := fs.ReadFile(path); os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%v is missing", path))
+		} else if err != nil {
+			return nil, err
+		} else if string(contents) != resolvedConfig(tlds, address, port)[path] {
+			problems = append(problems, fmt.Sprintf("%v doesn't point %v at %v", path, strings.Join(tlds, ", "), resolvedServer(address, port)))
+		}
+	}
+
+	if present, err := hasLink(resolvedLink); err != nil {
+		return nil, err
+	} else if !present {
+		return append(problems, fmt.Sprintf("the %v link is missing", resolvedLink)), nil
+	}
+
+	addressProblems, err := loopback.Status(hasAddress, resolvedLink, address)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, addressProblems...)
+
+	settings, err := output(createShowLinkDNSCmd())
+	if err != nil {
+		return nil, err
+	}
+
+	// resolvectl prints each setting as "Link 5 (falcon0): value ...".
+	lines := strings.Split(strings.TrimSpace(settings), "\n")
+	if len(lines) != 2 || !sameFields(linkSetting(lines[0]), []string{resolvedServer(address, port)}) || !sameFields(linkSetting(lines[1]), routingDomains(tlds)) {
+		problems = append(problems, fmt.Sprintf("systemd-resolved doesn't send %v to %v through %v", strings.Join(tlds, ", "), resolvedServer(address, port), resolvedLink))
+	}
+
+	return problems, nil
+}
+
+// linkSetting returns the values resolvectl printed for a link on the specified line.
+func linkSetting(line string) []string {
+	if i := strings.Index(line, "):"); i >= 0 {
+		return strings.Fields(line[i+2:])
+	}
+	return nil
+}
+
+// sameFields reports whether the two lists contain the same values, in any order.
+func sameFields(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, value := range a {
+		counts[value]++
+	}
+	for _, value := range b {
+		if counts[value] == 0 {
+			return false
+		}
+		counts[value]--
+	}
+
+	return true
+}
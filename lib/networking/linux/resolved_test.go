@@ -0,0 +1,226 @@
+package linux
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Hawkbawk/falcon/lib/files"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolved", func() {
+	var (
+		argList   []string
+		err       error
+		cmdRunner = func(cmd string) error {
+			argList = append(argList, cmd)
+			return err
+		}
+		link       bool
+		hasLink    = func(string) (bool, error) { return link, nil }
+		hasAddress = func(string, string) (bool, error) { return false, nil }
+		conflicts  = func(string) error { return nil }
+		address    = "192.168.40.1"
+		port       = 53
+		fs         files.FileSystem
+	)
+
+	BeforeEach(func() {
+		argList = make([]string, 0)
+		err = nil
+		link = false
+		fs = files.NewRootedFileSystem(GinkgoT().TempDir())
+	})
+
+	Describe("usingResolved", func() {
+		It("returns true if resolved's runtime directory exists", func() {
+			Expect(fs.WriteFile(resolvedRuntimeDir+"/stub-resolv.conf", []byte{})).To(Succeed())
+
+			Expect(usingResolved(fs)).To(BeTrue())
+		})
+
+		It("returns false if resolved's runtime directory doesn't exist", func() {
+			Expect(usingResolved(fs)).To(BeFalse())
+		})
+	})
+
+	Describe("createSetLinkDNSCmd", func() {
+		It("routes only falcon's TLDs to the server, through falcon's link", func() {
+			Expect(createSetLinkDNSCmd([]string{"docker", "test"}, address, port)).To(Equal(
+				"sudo resolvectl dns falcon0 192.168.40.1 && sudo resolvectl domain falcon0 ~docker ~test"))
+		})
+
+		It("adds the port to the address if the server doesn't use the standard one", func() {
+			Expect(createSetLinkDNSCmd([]string{"docker"}, address, 5353)).To(ContainSubstring("resolvectl dns falcon0 192.168.40.1:5353 "))
+		})
+	})
+
+	Describe("addResolvedConfig", func() {
+		It("writes the networkd config for the link and records that it did", func() {
+			Expect(addResolvedConfig(fs, []string{"docker", "test"}, address, port)).To(Equal(journal.Changes{CreatedFiles: []string{resolvedNetDevPath, resolvedNetworkPath}}))
+
+			Expect(fs.ReadFile(resolvedNetDevPath)).To(ContainSubstring("Name=falcon0\nKind=dummy\n"))
+			Expect(fs.ReadFile(resolvedNetworkPath)).To(And(
+				ContainSubstring("Address=192.168.40.1/32\n"),
+				ContainSubstring("DNS=192.168.40.1\n"),
+				ContainSubstring("Domains=~docker ~test\n"),
+				ContainSubstring("DNSDefaultRoute=no\n"),
+			))
+		})
+	})
+
+	Describe("removeResolvedConfig", func() {
+		It("removes the config falcon created", func() {
+			changes, addErr := addResolvedConfig(fs, []string{"docker"}, address, port)
+			Expect(addErr).NotTo(HaveOccurred())
+
+			Expect(removeResolvedConfig(fs, changes)).To(Succeed())
+			Expect(fs.Exists(resolvedNetDevPath)).To(BeFalse())
+			Expect(fs.Exists(resolvedNetworkPath)).To(BeFalse())
+		})
+
+		It("leaves config falcon didn't create alone", func() {
+			Expect(fs.WriteFile(resolvedNetworkPath, []byte("mine"))).To(Succeed())
+
+			Expect(removeResolvedConfig(fs, journal.Changes{})).To(Succeed())
+			Expect(fs.ReadFile(resolvedNetworkPath)).To(Equal([]byte("mine")))
+		})
+	})
+
+	Describe("addResolvedLink", func() {
+		It("creates the link and records that it did", func() {
+			Expect(addResolvedLink(cmdRunner, hasLink)).To(Equal(journal.Changes{Link: resolvedLink}))
+			Expect(argList).To(Equal([]string{createAddLinkCmd(), createLinkUpCmd()}))
+		})
+
+		It("only brings up a link that's already there", func() {
+			link = true
+
+			Expect(addResolvedLink(cmdRunner, hasLink)).To(Equal(journal.Changes{}))
+			Expect(argList).To(Equal([]string{createLinkUpCmd()}))
+		})
+
+		It("returns the link it created along with the error if it can't bring it up", func() {
+			failure := fmt.Errorf("didn't work :(")
+			failingRunner := func(cmd string) error {
+				if cmd == createLinkUpCmd() {
+					return failure
+				}
+				return cmdRunner(cmd)
+			}
+
+			changes, addErr := addResolvedLink(failingRunner, hasLink)
+			Expect(addErr).To(Equal(failure))
+			Expect(changes).To(Equal(journal.Changes{Link: resolvedLink}))
+		})
+	})
+
+	Describe("removeResolvedLink", func() {
+		It("removes the link falcon created", func() {
+			Expect(removeResolvedLink(cmdRunner, journal.Changes{Link: resolvedLink})).To(Succeed())
+			Expect(argList).To(Equal([]string{createRemoveLinkCmd(resolvedLink)}))
+		})
+
+		It("leaves a link falcon didn't create alone", func() {
+			Expect(removeResolvedLink(cmdRunner, journal.Changes{})).To(Succeed())
+			Expect(argList).To(BeEmpty())
+		})
+
+		It("doesn't error if the link was already removed", func() {
+			err = fmt.Errorf(`Cannot find device "falcon0"`)
+
+			Expect(removeResolvedLink(cmdRunner, journal.Changes{Link: resolvedLink})).To(Succeed())
+		})
+	})
+
+	Describe("resolvedStatus", func() {
+		present := func(string, string) (bool, error) { return true, nil }
+		settings := func(dns string, domains string) func(string) (string, error) {
+			return func(string) (string, error) {
+				return fmt.Sprintf("Link 5 (falcon0): %v\nLink 5 (falcon0): %v\n", dns, domains), nil
+			}
+		}
+
+		BeforeEach(func() {
+			link = true
+			_, addErr := addResolvedConfig(fs, []string{"docker", "test"}, address, port)
+			Expect(addErr).NotTo(HaveOccurred())
+		})
+
+		It("reports no problems when the link routes every TLD to the server", func() {
+			Expect(resolvedStatus(fs, present, hasLink, settings("192.168.40.1", "~test ~docker"), []string{"docker", "test"}, address, port)).To(BeEmpty())
+		})
+
+		It("reports a link that routes different TLDs", func() {
+			Expect(resolvedStatus(fs, present, hasLink, settings("192.168.40.1", "~docker"), []string{"test"}, address, port)).To(ContainElement(ContainSubstring("doesn't send test to 192.168.40.1")))
+		})
+
+		It("reports a link without DNS settings, like after systemd-resolved restarts", func() {
+			Expect(resolvedStatus(fs, present, hasLink, settings("", ""), []string{"docker", "test"}, address, port)).To(ConsistOf(ContainSubstring("doesn't send docker, test")))
+		})
+
+		It("reports a missing link, like after a reboot", func() {
+			link = false
+
+			Expect(resolvedStatus(fs, present, hasLink, settings("", ""), []string{"docker", "test"}, address, port)).To(ConsistOf("the falcon0 link is missing"))
+		})
+
+		It("reports missing networkd config", func() {
+			Expect(fs.Remove(resolvedNetworkPath)).To(Succeed())
+
+			Expect(resolvedStatus(fs, present, hasLink, settings("192.168.40.1", "~test ~docker"), []string{"docker", "test"}, address, port)).To(ConsistOf(resolvedNetworkPath + " is missing"))
+		})
+	})
+
+	Describe("resolvedSteps", func() {
+		var j *journal.Journal
+
+		BeforeEach(func() {
+			var openErr error
+			j, openErr = journal.Open(filepath.Join(GinkgoT().TempDir(), "journal.json"))
+			Expect(openErr).NotTo(HaveOccurred())
+		})
+
+		It("sets up the link and then removes it", func() {
+			Expect(j.Apply(resolvedSteps(fs, cmdRunner, hasAddress, hasLink, conflicts, []string{"docker"}, address, port))).To(Succeed())
+
+			Expect(argList).To(Equal([]string{
+				createAddLinkCmd(),
+				createLinkUpCmd(),
+				createAddLoopbackAddressCmd(resolvedLink, address),
+				createSetLinkDNSCmd([]string{"docker"}, address, port),
+			}))
+			Expect(fs.Exists(resolvedNetworkPath)).To(BeTrue())
+
+			link = true
+			argList = make([]string, 0)
+			Expect(j.Undo(resolvedSteps(fs, cmdRunner, hasAddress, hasLink, conflicts, []string{"docker"}, address, port))).To(Succeed())
+
+			Expect(argList).To(Equal([]string{
+				createRevertLinkDNSCmd(),
+				createRemoveLoopbackAddressCmd(resolvedLink, address),
+				createRemoveLinkCmd(resolvedLink),
+			}))
+			Expect(fs.Exists(resolvedNetDevPath)).To(BeFalse())
+			Expect(fs.Exists(resolvedNetworkPath)).To(BeFalse())
+		})
+
+		It("removes the link again if a later step fails", func() {
+			failure := fmt.Errorf("didn't work :(")
+			failingRunner := func(cmd string) error {
+				argList = append(argList, cmd)
+				if cmd == createSetLinkDNSCmd([]string{"docker"}, address, port) {
+					return failure
+				}
+				return nil
+			}
+
+			Expect(j.Apply(resolvedSteps(fs, failingRunner, hasAddress, hasLink, conflicts, []string{"docker"}, address, port))).NotTo(Succeed())
+			Expect(argList[len(argList)-2:]).To(Equal([]string{createRemoveLoopbackAddressCmd(resolvedLink, address), createRemoveLinkCmd(resolvedLink)}))
+			Expect(fs.Exists(resolvedNetworkPath)).To(BeFalse())
+			Expect(j.Empty()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package loopback
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultAddress is the loopback address falcon uses when none is configured. It's the address that
+// makes us better than dory: every request to falcon's domains resolves to it, which containers send
+// back out through the host networking to the falcon-proxy container, rather than just sending all
+// traffic for falcon's domains back to themselves.
+const DefaultAddress = "192.168.40.1"
+
+// Validate returns an error if the specified address can't be used as falcon's loopback address.
+// It has to be a private IPv4 address, since it's only ever used on the host machine, and it can't
+// be in 127.0.0.0/8, since containers send requests for those addresses back to themselves.
+func Validate(address string) error {
+	ip := net.ParseIP(address)
+
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("%q isn't a valid IPv4 address", address)
+	} else if ip.IsLoopback() {
+		return fmt.Errorf("%v can't be used as the loopback address, since containers resolve it to themselves. Use a private address like %v instead", address, DefaultAddress)
+	} else if !ip.IsPrivate() {
+		return fmt.Errorf("%v isn't a private address. Use one from 10.0.0.0/8, 172.16.0.0/12 or 192.168.0.0/16 that none of your networks use", address)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package loopback
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/logger"
+)
+
+// Commands describes how a platform adds falcon's loopback address to an interface and removes it
+// again.
+type Commands struct {
+	// Interface is the name of the interface the address is added to.
+	Interface string
+	// Add and Remove create the commands that add and remove the specified address.
+	Add    func(address string) string
+	Remove func(address string) string
+	// AlreadyAdded and AlreadyRemoved match the errors those commands fail with when there's
+	// nothing for them to do, so that running falcon up or falcon down twice doesn't error. Either
+	// may be nil.
+	AlreadyAdded   *regexp.Regexp
+	AlreadyRemoved *regexp.Regexp
+}
+
+// Add adds the specified address to the interface with the specified commands, unless it's already
+// there. The address is checked against the host's networks first, since adding an address that one
+// of them uses would break access to it.
+func Add(cmdRunner func(string) error, hasAddress func(string, string) (bool, error), conflicts func(string) error, commands Commands, address string) (journal.Changes, error) {
+	if present, err := hasAddress(commands.Interface, address); err != nil {
+		return journal.Changes{}, err
+	} else if present {
+		return journal.Changes{}, nil
+	}
+
+	if err := conflicts(address); err != nil {
+		return journal.Changes{}, err
+	}
+
+	logger.LogInfo("Requesting sudo to add a new loopback address...")
+	if err := cmdRunner(commands.Add(address)); err != nil && !matches(commands.AlreadyAdded, err) {
+		return journal.Changes{}, err
+	}
+
+	return journal.Changes{LoopbackAddress: address}, nil
+}
+
+// Remove removes the loopback address recorded in the specified changes with the specified
+// commands, if falcon was the one that added it.
+func Remove(cmdRunner func(string) error, commands Commands, changes journal.Changes) error {
+	if changes.LoopbackAddress == "" {
+		return nil
+	}
+
+	logger.LogInfo("Requesting sudo to remove the added loopback address...")
+	if err := cmdRunner(commands.Remove(changes.LoopbackAddress)); err != nil && !matches(commands.AlreadyRemoved, err) {
+		return err
+	}
+
+	return nil
+}
+
+// Status describes the specified address if it's missing from the specified interface.
+func Status(hasAddress func(string, string) (bool, error), interfaceName string, address string) ([]string, error) {
+	if present, err := hasAddress(interfaceName, address); err != nil {
+		return nil, err
+	} else if !present {
+		return []string{fmt.Sprintf("%v doesn't have the loopback address %v", interfaceName, address)}, nil
+	}
+
+	return []string{}, nil
+}
+
+// matches reports whether the specified error matches the specified regex, if there is one.
+func matches(regex *regexp.Regexp, err error) bool {
+	return regex != nil && regex.MatchString(err.Error())
+}
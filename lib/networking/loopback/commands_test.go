@@ -0,0 +1,59 @@
+package loopback
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Hawkbawk/falcon/lib/journal"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Commands", func() {
+	var (
+		ran        []string
+		err        error
+		cmdRunner  = func(cmd string) error { ran = append(ran, cmd); return err }
+		hasAddress = func(string, string) (bool, error) { return false, nil }
+		conflicts  = func(string) error { return nil }
+		commands   = Commands{
+			Interface:      "lo",
+			Add:            func(address string) string { return "add " + address },
+			Remove:         func(address string) string { return "remove " + address },
+			AlreadyRemoved: regexp.MustCompile("gone"),
+		}
+	)
+
+	BeforeEach(func() {
+		ran = make([]string, 0)
+		err = nil
+	})
+
+	Describe("Add", func() {
+		It("runs the add command and records the address", func() {
+			Expect(Add(cmdRunner, hasAddress, conflicts, commands, "10.254.254.254")).To(Equal(journal.Changes{LoopbackAddress: "10.254.254.254"}))
+			Expect(ran).To(Equal([]string{"add 10.254.254.254"}))
+		})
+
+		It("returns every error if the commands don't say what an address that's already there looks like", func() {
+			err = fmt.Errorf("File exists")
+
+			Expect(Add(cmdRunner, hasAddress, conflicts, commands, "10.254.254.254")).Error().To(Equal(err))
+		})
+	})
+
+	Describe("Remove", func() {
+		It("ignores errors that say the address is already gone", func() {
+			err = fmt.Errorf("it's gone")
+
+			Expect(Remove(cmdRunner, commands, journal.Changes{LoopbackAddress: "10.254.254.254"})).To(Succeed())
+			Expect(ran).To(Equal([]string{"remove 10.254.254.254"}))
+		})
+	})
+
+	Describe("Status", func() {
+		It("describes a missing address", func() {
+			Expect(Status(hasAddress, "lo", "10.254.254.254")).To(Equal([]string{"lo doesn't have the loopback address 10.254.254.254"}))
+		})
+	})
+})
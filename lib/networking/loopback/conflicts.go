@@ -0,0 +1,225 @@
+package loopback
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Linux lists the routing table in this file, which saves us from parsing the output of ip route.
+const procRoutePath = "/proc/net/route"
+
+// Routes shorter than this are catch-alls, like the 0.0.0.0/1 and 128.0.0.0/1 routes VPNs add to
+// send all traffic through themselves, rather than networks that actually use the address.
+const minimumPrefixLength = 8
+
+// FalconInterface is the dummy link falcon adds its loopback address to on machines using
+// systemd-resolved. The address on it is falcon's own, so it never conflicts with itself.
+const FalconInterface = "falcon0"
+
+// Network is a range of addresses the host machine already reaches through one of its interfaces,
+// either because the interface has an address in it or because the routing table says so.
+type Network struct {
+	Interface string
+	Net       *net.IPNet
+}
+
+// Conflicts returns an error if the specified address is already used by any of the host
+// machine's networks, like an office subnet that's routed through a VPN. Adding the address to the
+// loopback interface would silently break access to that network.
+func Conflicts(address string) error {
+	networks, err := Networks()
+
+	if err != nil {
+		return fmt.Errorf("Unable to check whether %v is already in use due to the following error:\n%v", address, err)
+	}
+
+	return Conflict(address, networks)
+}
+
+// Conflict returns an error if the specified address is inside any of the specified networks.
+func Conflict(address string, networks []Network) error {
+	ip := net.ParseIP(address)
+
+	for _, network := range networks {
+		if network.Net.Contains(ip) {
+			return fmt.Errorf("%v is inside %v, which is already reachable through %v. Set loopback-address in ~/.falcon.yaml to a private address that none of your networks use", address, network.Net, network.Interface)
+		}
+	}
+
+	return nil
+}
+
+// Networks returns every IPv4 network the host machine reaches through an interface other than
+// the loopback interface and falcon's own link: the networks of each interface's addresses and
+// every route in the routing table, except catch-alls like the default route.
+func Networks() ([]Network, error) {
+	ifaces, err := net.Interfaces()
+
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]Network, 0)
+	skipped := map[string]bool{FalconInterface: true}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			skipped[iface.Name] = true
+			continue
+		} else if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				addresses = append(addresses, Network{Interface: iface.Name, Net: ipNet})
+			}
+		}
+	}
+
+	routes, err := routes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hostNetworks(addresses, routes, skipped), nil
+}
+
+// hostNetworks returns the networks of the specified interface addresses and routes, leaving out
+// those on any of the skipped interfaces and routes that are catch-alls.
+func hostNetworks(addresses []Network, routes []Network, skipped map[string]bool) []Network {
+	networks := make([]Network, 0, len(addresses)+len(routes))
+
+	for _, address := range addresses {
+		if !skipped[address.Interface] {
+			networks = append(networks, address)
+		}
+	}
+
+	for _, route := range routes {
+		if prefixLength, _ := route.Net.Mask.Size(); !skipped[route.Interface] && prefixLength >= minimumPrefixLength {
+			networks = append(networks, route)
+		}
+	}
+
+	return networks
+}
+
+// routes reads the host machine's IPv4 routing table.
+func routes() ([]Network, error) {
+	switch runtime.GOOS {
+	case "linux":
+		contents, err := os.ReadFile(procRoutePath)
+		if err != nil {
+			return nil, err
+		}
+		return parseProcRoutes(string(contents)), nil
+	case "darwin":
+		output, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+		if err != nil {
+			return nil, err
+		}
+		return parseNetstatRoutes(string(output)), nil
+	default:
+		return []Network{}, nil
+	}
+}
+
+// parseProcRoutes parses Linux's /proc/net/route, where each route's destination and mask are
+// written as little-endian hex, like "0002A8C0" for 192.168.2.0.
+func parseProcRoutes(contents string) []Network {
+	networks := make([]Network, 0)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 8 || fields[0] == "Iface" {
+			continue
+		}
+
+		destination, destinationErr := strconv.ParseUint(fields[1], 16, 32)
+		mask, maskErr := strconv.ParseUint(fields[7], 16, 32)
+
+		if destinationErr != nil || maskErr != nil {
+			continue
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		binary.LittleEndian.PutUint32(ip, uint32(destination))
+		ipMask := make(net.IPMask, net.IPv4len)
+		binary.LittleEndian.PutUint32(ipMask, uint32(mask))
+
+		networks = append(networks, Network{Interface: fields[0], Net: &net.IPNet{IP: ip, Mask: ipMask}})
+	}
+
+	return networks
+}
+
+// parseNetstatRoutes parses the output of netstat -rn -f inet on macOS. netstat leaves off the
+// trailing zero octets of each destination, and only includes the prefix length when it can't be
+// worked out from the number of octets, so "10.8/16", "192.168.1" and "192.168.1.1" are all valid.
+func parseNetstatRoutes(output string) []Network {
+	networks := make([]Network, 0)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 4 {
+			continue
+		}
+
+		if ipNet := parseNetstatDestination(fields[0]); ipNet != nil {
+			networks = append(networks, Network{Interface: fields[3], Net: ipNet})
+		}
+	}
+
+	return networks
+}
+
+// parseNetstatDestination parses a single destination from netstat's output, returning nil if it
+// isn't an IPv4 network, like "default" or the header.
+func parseNetstatDestination(destination string) *net.IPNet {
+	parts := strings.SplitN(destination, "/", 2)
+	octets := strings.Split(parts[0], ".")
+
+	if len(octets) > net.IPv4len {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv4len)
+	for i, octet := range octets {
+		value, err := strconv.ParseUint(octet, 10, 8)
+		if err != nil {
+			return nil
+		}
+		ip[i] = byte(value)
+	}
+
+	prefixLength := 8 * len(octets)
+	if len(parts) == 2 {
+		value, err := strconv.Atoi(parts[1])
+		if err != nil || value < 0 || value > 32 {
+			return nil
+		}
+		prefixLength = value
+	}
+
+	mask := net.CIDRMask(prefixLength, 32)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
@@ -0,0 +1,48 @@
+// The loopback package works out which special loopback address falcon adds to the host machine,
+// and contains helpers for inspecting it and making sure it doesn't clash with the host's networks.
+package loopback
+
+import "net"
+
+// HasAddress reports whether the network interface with the specified name has been assigned the
+// specified IP address.
+func HasAddress(interfaceName string, address string) (bool, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+
+	if err != nil {
+		return false, err
+	}
+
+	addrs, err := iface.Addrs()
+
+	if err != nil {
+		return false, err
+	}
+
+	ip := net.ParseIP(address)
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// HasInterface reports whether the host has a network interface with the specified name.
+func HasInterface(interfaceName string) (bool, error) {
+	ifaces, err := net.Interfaces()
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, iface := range ifaces {
+		if iface.Name == interfaceName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package loopback
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Loopback", func() {
+	Describe("HasAddress", func() {
+		var loopbackName string
+
+		BeforeEach(func() {
+			ifaces, err := net.Interfaces()
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, iface := range ifaces {
+				if iface.Flags&net.FlagLoopback != 0 {
+					loopbackName = iface.Name
+				}
+			}
+
+			if loopbackName == "" {
+				Skip("no loopback interface on this machine")
+			}
+		})
+
+		It("finds an address that's assigned to the interface", func() {
+			Expect(HasAddress(loopbackName, "127.0.0.1")).To(BeTrue())
+		})
+
+		It("doesn't find an address that isn't assigned to the interface", func() {
+			Expect(HasAddress(loopbackName, "192.0.2.1")).To(BeFalse())
+		})
+
+		It("returns an error if the interface doesn't exist", func() {
+			Expect(HasAddress("falcon-does-not-exist", "127.0.0.1")).Error().To(HaveOccurred())
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts private IPv4 addresses", func() {
+			Expect(Validate("192.168.40.1")).To(Succeed())
+			Expect(Validate("10.254.254.254")).To(Succeed())
+			Expect(Validate("172.16.0.1")).To(Succeed())
+		})
+
+		It("rejects anything else", func() {
+			Expect(Validate("localhost")).To(MatchError(ContainSubstring("isn't a valid IPv4 address")))
+			Expect(Validate("fd00::1")).To(MatchError(ContainSubstring("isn't a valid IPv4 address")))
+			Expect(Validate("127.0.0.2")).To(MatchError(ContainSubstring("resolve it to themselves")))
+			Expect(Validate("8.8.8.8")).To(MatchError(ContainSubstring("isn't a private address")))
+		})
+	})
+
+	Describe("Conflict", func() {
+		_, office, _ := net.ParseCIDR("192.168.40.0/24")
+		_, home, _ := net.ParseCIDR("192.168.1.0/24")
+		networks := []Network{{Interface: "en0", Net: home}, {Interface: "utun3", Net: office}}
+
+		It("returns an error if the address is inside one of the networks", func() {
+			Expect(Conflict("192.168.40.1", networks)).To(MatchError(ContainSubstring("192.168.40.0/24, which is already reachable through utun3")))
+		})
+
+		It("succeeds if the address isn't inside any of the networks", func() {
+			Expect(Conflict("10.254.254.254", networks)).To(Succeed())
+		})
+	})
+
+	Describe("hostNetworks", func() {
+		_, home, _ := net.ParseCIDR("192.168.1.0/24")
+		_, falcon, _ := net.ParseCIDR("192.168.40.1/32")
+		_, vpn, _ := net.ParseCIDR("0.0.0.0/1")
+
+		It("leaves out falcon's own link, so an address that's already on it doesn't conflict", func() {
+			networks := hostNetworks([]Network{{Interface: "en0", Net: home}, {Interface: FalconInterface, Net: falcon}}, []Network{{Interface: FalconInterface, Net: falcon}}, map[string]bool{FalconInterface: true})
+
+			Expect(networks).To(Equal([]Network{{Interface: "en0", Net: home}}))
+			Expect(Conflict("192.168.40.1", networks)).To(Succeed())
+		})
+
+		It("leaves out catch-all routes", func() {
+			Expect(hostNetworks(nil, []Network{{Interface: "utun3", Net: vpn}, {Interface: "en0", Net: home}}, map[string]bool{})).To(Equal([]Network{{Interface: "en0", Net: home}}))
+		})
+	})
+
+	Describe("parseProcRoutes", func() {
+		It("parses each route's interface, destination and mask", func() {
+			routes := parseProcRoutes(`Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0102A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+tun0	0028A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`)
+
+			Expect(routes).To(HaveLen(3))
+			Expect(routes[0].Net.String()).To(Equal("0.0.0.0/0"))
+			Expect(routes[1].Net.String()).To(Equal("192.168.2.0/24"))
+			Expect(routes[2].Interface).To(Equal("tun0"))
+			Expect(routes[2].Net.String()).To(Equal("192.168.40.0/24"))
+		})
+	})
+
+	Describe("parseNetstatRoutes", func() {
+		It("parses abbreviated destinations and skips everything else", func() {
+			routes := parseNetstatRoutes(`Routing tables
+
+Internet:
+Destination        Gateway            Flags        Netif Expire
+default            192.168.1.1        UGScg          en0
+10.8/16            10.8.0.1           UGSc         utun3
+127                127.0.0.1          UCS            lo0
+192.168.1          link#6             UCS            en0      !
+192.168.1.1/32     link#6             UCS            en0      !
+192.168.40.1       192.168.40.1       UH             lo0
+`)
+
+			names := make([]string, 0, len(routes))
+			for _, route := range routes {
+				names = append(names, route.Interface+" "+route.Net.String())
+			}
+
+			Expect(names).To(Equal([]string{
+				"utun3 10.8.0.0/16",
+				"lo0 127.0.0.0/8",
+				"en0 192.168.1.0/24",
+				"en0 192.168.1.1/32",
+				"lo0 192.168.40.1/32",
+			}))
+		})
+	})
+})
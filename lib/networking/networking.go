@@ -1,36 +1,93 @@
+// The networking package picks the backend falcon uses to point *.docker domains at the host
+// machine's loopback address.
 package networking
 
 import (
 	"fmt"
-	"runtime"
+	"strings"
 
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/journal"
 	"github.com/Hawkbawk/falcon/lib/networking/darwin"
+	"github.com/Hawkbawk/falcon/lib/networking/hosts"
+	"github.com/Hawkbawk/falcon/lib/networking/linux"
 )
 
-// Configure sets up all networking on the machine for proxying.
-func Configure() error {
-	os := runtime.GOOS
-	switch os {
-	// Linux support is disabled currently, but will be fixed later
-	// case "linux":
-	// 	return linux.Configure()
-	case "darwin":
-		return darwin.Configure()
-	default:
-		return fmt.Errorf("we only support macOS currently")
+// Backend is a strategy for configuring the host machine's networking so that falcon works.
+type Backend interface {
+	// Name returns the name users can use to select this backend.
+	Name() string
+	// Detect reports whether this backend can be used on the current machine.
+	Detect() (bool, error)
+	// Steps returns each change needed to set up networking on the machine for proxying with the
+	// specified config, in the order they should be applied. Undoing them in reverse order returns
+	// all networking on the machine back to it's original state (hopefully).
+	Steps(cfg config.Config) []journal.Step
+	// Status describes each change made by Steps for the specified config that isn't currently in
+	// place. If nothing is missing, an empty list is returned.
+	Status(cfg config.Config) ([]string, error)
+}
+
+// Every backend falcon knows about, in the order we try them when detecting which one to use.
+// The hosts backend can't do wildcards, so it's only ever used as a last resort.
+var backends = []Backend{
+	darwin.Backend{},
+	linux.Resolved{},
+	linux.NetworkManager{},
+	hosts.Backend{},
+}
+
+// Address returns the address the specified backend points falcon's domains at, which is where the
+// proxy and dnsmasq listen unless they're configured otherwise. Every backend uses falcon's loopback
+// address, except the hosts backend, which points hostnames at localhost.
+func Address(backend Backend, loopbackAddress string) string {
+	if _, ok := backend.(hosts.Backend); ok {
+		return hosts.Address
+	}
+
+	return loopbackAddress
+}
+
+// Backends returns every backend falcon knows about.
+func Backends() []Backend {
+	return backends
+}
+
+// Select returns the backend with the specified name. If no name is specified, the first backend
+// that can be used on the current machine is returned instead.
+func Select(name string) (Backend, error) {
+	return selectFrom(backends, name)
+}
+
+func selectFrom(backends []Backend, name string) (Backend, error) {
+	if name != "" {
+		for _, backend := range backends {
+			if backend.Name() == name {
+				return backend, nil
+			}
+		}
+
+		return nil, fmt.Errorf("there's no networking backend named %q. Choose one of: %v", name, strings.Join(names(backends), ", "))
 	}
+
+	for _, backend := range backends {
+		if usable, err := backend.Detect(); err != nil {
+			return nil, fmt.Errorf("Unable to detect whether the %v networking backend can be used:\n%v", backend.Name(), err)
+		} else if usable {
+			return backend, nil
+		}
+	}
+
+	return nil, fmt.Errorf("none of the networking backends (%v) can be used on this machine", strings.Join(names(backends), ", "))
 }
 
-// Clean returns all networking on the machine back to it's original state (hopefully)
-func Clean() error {
-	os := runtime.GOOS
-	switch os {
-	// Linux support is disabled currently, but will be fixed later
-	// case "linux":
-	// 	return linux.Restore()
-	case "darwin":
-		return darwin.Clean()
-	default:
-		return fmt.Errorf("we only support macOS currently")
+// names returns the name of each of the specified backends.
+func names(backends []Backend) []string {
+	result := make([]string, 0, len(backends))
+
+	for _, backend := range backends {
+		result = append(result, backend.Name())
 	}
+
+	return result
 }
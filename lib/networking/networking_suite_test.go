@@ -0,0 +1,13 @@
+package networking
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestNetworking(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Networking Suite")
+}
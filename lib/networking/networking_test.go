@@ -0,0 +1,78 @@
+package networking
+
+import (
+	"fmt"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/networking/hosts"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBackend is a Backend whose detection result we control.
+type fakeBackend struct {
+	name      string
+	usable    bool
+	detectErr error
+}
+
+func (f fakeBackend) Name() string                           { return f.name }
+func (f fakeBackend) Detect() (bool, error)                  { return f.usable, f.detectErr }
+func (f fakeBackend) Steps(config.Config) []journal.Step     { return []journal.Step{} }
+func (f fakeBackend) Status(config.Config) ([]string, error) { return []string{}, nil }
+
+var _ = Describe("Networking", func() {
+	var (
+		first  fakeBackend
+		second fakeBackend
+	)
+
+	BeforeEach(func() {
+		first = fakeBackend{name: "first"}
+		second = fakeBackend{name: "second", usable: true}
+	})
+
+	Describe("selectFrom", func() {
+		It("returns the backend with the specified name, even if it isn't detected", func() {
+			Expect(selectFrom([]Backend{first, second}, "first")).To(Equal(first))
+		})
+
+		It("returns an error if no backend has the specified name", func() {
+			_, err := selectFrom([]Backend{first, second}, "third")
+			Expect(err).To(MatchError(ContainSubstring("first, second")))
+		})
+
+		It("returns the first usable backend if no name is specified", func() {
+			Expect(selectFrom([]Backend{first, second}, "")).To(Equal(second))
+		})
+
+		It("returns an error if no backend is usable", func() {
+			second.usable = false
+
+			Expect(selectFrom([]Backend{first, second}, "")).Error().To(HaveOccurred())
+		})
+
+		It("returns an error if detection fails", func() {
+			first.detectErr = fmt.Errorf("problems!")
+
+			Expect(selectFrom([]Backend{first, second}, "")).Error().To(MatchError(ContainSubstring("problems!")))
+		})
+	})
+
+	Describe("Address", func() {
+		It("uses the loopback address for most backends", func() {
+			Expect(Address(first, "192.168.40.1")).To(Equal("192.168.40.1"))
+		})
+
+		It("uses localhost for the hosts backend", func() {
+			Expect(Address(hosts.Backend{}, "192.168.40.1")).To(Equal("127.0.0.1"))
+		})
+	})
+
+	Describe("Backends", func() {
+		It("gives every backend a unique name", func() {
+			Expect(names(Backends())).To(ConsistOf("macos", "resolved", "networkmanager", "hosts"))
+		})
+	})
+})
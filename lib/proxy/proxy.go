@@ -1,19 +1,44 @@
 package proxy
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/Hawkbawk/falcon/lib/config"
 	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/domains"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/ready"
 	"github.com/Hawkbawk/falcon/lib/shell"
+	"github.com/Hawkbawk/falcon/lib/traefik"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
 	"gopkg.in/yaml.v2"
 )
 
-const proxyImageName = "hawkbawk/falcon-proxy"
-const proxyContainerName = "falcon-proxy"
+// The name Traefik's dashboard is served under, like traefik.docker.
+const dashboardName = "traefik"
+
+// ContainerName is the name of the falcon-proxy container when it's running.
+const ContainerName = "falcon-proxy"
+
+// BridgeNetwork is Docker's default network, which containers run on unless they ask for another.
+const BridgeNetwork = "bridge"
+
+// Where the TLS directory is mounted inside the proxy container.
 const proxyConfigDir = "/usr/src/app/config"
+
+// Where the static config falcon writes is mounted inside the proxy container.
+const staticConfigFile = "/etc/falcon/traefik.yml"
+
+// StaticConfigLabel is the label holding a hash of the proxy's static config, so that the container
+// is recreated when the config changes, even though the file it's read from doesn't.
+const StaticConfigLabel = "falcon.static-config-hash"
+
 const defaultConfig = `
 # This is where falcon will add any info about any certificates that it creates for you.
 # Alternatively, you can put any info about your own certificates here.
@@ -22,46 +47,106 @@ tls:
   certificates:
 `
 
-var configDir = fmt.Sprintf("%v/.falcon", os.Getenv("HOME"))
-var certificatesDir = fmt.Sprintf("%v/certs", configDir)
-var dynamicConfigPath = fmt.Sprintf("%v/dynamic.yml", configDir)
-
-var containerConfig *container.Config = &container.Config{
-	Image: proxyImageName,
-	ExposedPorts: nat.PortSet{
-		"80":  struct{}{},
-		"443": struct{}{},
-	},
-	Labels: map[string]string{
-		"traefik.enable":                                         "true",
-		"traefik.http.routers.traefik.rule":                      "Host(`traefik.docker`)",
-		"traefik.http.services.traefik.loadbalancer.server.port": "8080",
-	},
-}
-
-var hostConfig *container.HostConfig = &container.HostConfig{
-	Binds: []string{
-		"/var/run/docker.sock:/var/run/docker.sock:ro",
-		// We have to bind-mount the entire config directory instead of just the
-		// certificates and dynamic config due to some fsnotify issues that happen
-		// when you mount specific files. This ensures Traefik picks up on our
-		// changes to the dynamic config.
-		fmt.Sprintf("%v:%v", configDir, proxyConfigDir),
-	},
-	PortBindings: nat.PortMap{
-		"80": []nat.PortBinding{
-			{
-				HostIP:   "0.0.0.0",
-				HostPort: "80",
-			},
+// certificatesDir returns where the certificates are kept in the specified TLS directory.
+func certificatesDir(dir string) string {
+	return filepath.Join(dir, "certs")
+}
+
+// dynamicConfigPath returns the path of the Traefik dynamic config that lists the certificates.
+func dynamicConfigPath(dir string) string {
+	return filepath.Join(dir, "dynamic.yml")
+}
+
+// staticConfigPath returns the path of the Traefik static config falcon writes. It's named without a
+// YAML extension, so that Traefik's file provider, which watches the whole TLS directory for dynamic
+// config, leaves it alone.
+func staticConfigPath(dir string) string {
+	return filepath.Join(dir, "traefik.static")
+}
+
+// routesConfigPath returns the path of the Traefik dynamic config for the configured routes.
+func routesConfigPath(dir string) string {
+	return filepath.Join(dir, "routes.yml")
+}
+
+// createContainerConfig creates the config for the proxy container, which runs the specified image
+// with the specified static config and serves Traefik's dashboard under each of the specified TLDs.
+func createContainerConfig(image string, tlds []string, staticConfig []byte) *container.Config {
+	return &container.Config{
+		Image: image,
+		// Traefik only reads its static config from one place, and prefers a file to flags, so any
+		// traefik.yml baked into the image would win over flags. Naming the file outright means
+		// falcon's config is always the one that's used.
+		Cmd: []string{fmt.Sprintf("--configFile=%v", staticConfigFile)},
+		ExposedPorts: nat.PortSet{
+			"80":  struct{}{},
+			"443": struct{}{},
 		},
-		"443": []nat.PortBinding{
-			{
-				HostIP:   "0.0.0.0",
-				HostPort: "443",
+		Labels: map[string]string{
+			"traefik.enable":                                         "true",
+			"traefik.http.routers.traefik.rule":                      createHostRule(domains.Hostnames(dashboardName, tlds)),
+			"traefik.http.services.traefik.loadbalancer.server.port": "8080",
+			StaticConfigLabel:                                        fmt.Sprintf("%x", sha256.Sum256(staticConfig)),
+		},
+	}
+}
+
+// createStaticConfig creates Traefik's static configuration. If automatic hostnames are enabled,
+// Traefik routes every container, even those without any labels, using its default rule for the
+// specified TLDs.
+func createStaticConfig(autoHostnames bool, tlds []string, network string) ([]byte, error) {
+	staticConfig := StaticConfig{}
+	staticConfig.EntryPoints = map[string]EntryPointConfig{
+		"web":       {Address: ":80"},
+		"websecure": {Address: ":443"},
+	}
+	staticConfig.Api.Insecure = true
+	// Containers connected to falcon's network are reached on it, rather than on whichever of
+	// their networks Docker lists first.
+	staticConfig.Providers.Docker.Network = network
+	staticConfig.Providers.Docker.ExposedByDefault = autoHostnames
+	if autoHostnames {
+		staticConfig.Providers.Docker.DefaultRule = traefik.DefaultRule(tlds)
+	}
+	staticConfig.Providers.File.Directory = proxyConfigDir
+	staticConfig.Providers.File.Watch = true
+
+	return yaml.Marshal(&staticConfig)
+}
+
+// createHostConfig creates the host config for the proxy container, which mounts the specified TLS
+// directory and the static config in it, runs on the specified network and publishes Traefik's HTTP
+// and HTTPS entrypoints on the specified addresses.
+func createHostConfig(tlsDir string, network string, addresses listen.Addresses) *container.HostConfig {
+	return &container.HostConfig{
+		NetworkMode: container.NetworkMode(network),
+		Binds: []string{
+			"/var/run/docker.sock:/var/run/docker.sock:ro",
+			// We have to bind-mount the entire config directory instead of just the
+			// certificates and dynamic config due to some fsnotify issues that happen
+			// when you mount specific files. This ensures Traefik picks up on our
+			// changes to the dynamic config.
+			fmt.Sprintf("%v:%v", tlsDir, proxyConfigDir),
+			fmt.Sprintf("%v:%v:ro", staticConfigPath(tlsDir), staticConfigFile),
+		},
+		// Lets routes reach services on the host machine on Linux too, where Docker doesn't add
+		// host.docker.internal by itself.
+		ExtraHosts: []string{"host.docker.internal:host-gateway"},
+		PortBindings: nat.PortMap{
+			"80": []nat.PortBinding{
+				{
+					HostIP:   addresses.ProxyIP,
+					HostPort: fmt.Sprint(addresses.HTTPPort),
+				},
+			},
+			"443": []nat.PortBinding{
+				{
+					HostIP:   addresses.ProxyIP,
+					HostPort: fmt.Sprint(addresses.HTTPSPort),
+				},
 			},
 		},
-	},
+	}
 }
 
 type TlsFilesConfig struct {
@@ -75,21 +160,198 @@ type DynamicConfig struct {
 	} `yaml:"tls,omitempty"`
 }
 
-// Start starts up the falcon-proxy so that it can start forwarding requests.
-func Start(client docker.DockerClient) error {
-	return client.StartContainer(proxyImageName, hostConfig, containerConfig, proxyContainerName)
+type EntryPointConfig struct {
+	Address string `yaml:"address"`
+}
+
+type StaticConfig struct {
+	EntryPoints map[string]EntryPointConfig `yaml:"entryPoints"`
+	Api         struct {
+		Insecure bool `yaml:"insecure"`
+	} `yaml:"api"`
+	// Serves /ping alongside the API, so that falcon up can tell when Traefik is ready.
+	Ping      struct{} `yaml:"ping"`
+	Providers struct {
+		Docker struct {
+			Network          string `yaml:"network"`
+			ExposedByDefault bool   `yaml:"exposedByDefault"`
+			DefaultRule      string `yaml:"defaultRule,omitempty"`
+		} `yaml:"docker"`
+		File struct {
+			Directory string `yaml:"directory"`
+			Watch     bool   `yaml:"watch"`
+		} `yaml:"file"`
+	} `yaml:"providers"`
+}
+
+type RouterConfig struct {
+	Rule    string `yaml:"rule"`
+	Service string `yaml:"service"`
+}
+
+type ServerConfig struct {
+	URL string `yaml:"url"`
+}
+
+type ServiceConfig struct {
+	LoadBalancer struct {
+		Servers []ServerConfig `yaml:"servers"`
+	} `yaml:"loadBalancer"`
+}
+
+type RoutesConfig struct {
+	Http struct {
+		Routers  map[string]RouterConfig  `yaml:"routers"`
+		Services map[string]ServiceConfig `yaml:"services"`
+	} `yaml:"http"`
+}
+
+// Start starts up the falcon-proxy from the configured image on the configured network so that it
+// can start forwarding requests it receives on the specified addresses. An existing proxy container
+// is recreated if its configuration has changed, or if recreate is true.
+func Start(ctx context.Context, client docker.DockerClient, cfg config.Config, addresses listen.Addresses, recreate bool) error {
+	staticConfig, err := writeStaticConfig(cfg.TLS.Dir, cfg.AutoHostnames, cfg.TLDs, cfg.Network.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := writeRoutes(cfg.TLS.Dir, cfg.Routes); err != nil {
+		return err
+	}
+
+	if err := client.StartContainer(ctx, cfg.Images.Proxy, cfg.Pull, recreate, createHostConfig(cfg.TLS.Dir, cfg.Network.Name, addresses), createContainerConfig(cfg.Images.Proxy, cfg.TLDs, staticConfig), ContainerName); err != nil {
+		return err
+	}
+
+	return connectToBridge(ctx, client, cfg.Network.Name)
+}
+
+// connectToBridge connects the proxy container to Docker's default bridge network, unless it's
+// already on it, so that it can still reach containers there that haven't been connected to
+// falcon's network, like when network.connect is none. Traefik reaches a container on its first
+// network when it isn't on falcon's.
+func connectToBridge(ctx context.Context, client docker.DockerClient, network string) error {
+	if network == BridgeNetwork {
+		return nil
+	}
+
+	container, err := client.GetContainer(ctx, ContainerName)
+	if err != nil {
+		return err
+	} else if container == nil {
+		return fmt.Errorf("the %v container isn't running after being started", ContainerName)
+	} else if container.NetworkSettings != nil {
+		if _, ok := container.NetworkSettings.Networks[BridgeNetwork]; ok {
+			return nil
+		}
+	}
+
+	return client.ConnectContainer(ctx, BridgeNetwork, container.ID)
+}
+
+// WaitUntilReady waits, for up to the configured ready-timeout, until the proxy container listening
+// on the specified address answers on Traefik's ping endpoint. The endpoint is requested using get,
+// which is normally traefik.Get. Since the ping endpoint is served through the dashboard's router,
+// this also waits until Traefik has picked up the routes from the Docker provider.
+func WaitUntilReady(ctx context.Context, cfg config.Config, address string, get func(ctx context.Context, address string, hostname string, path string) ([]byte, error)) error {
+	err := ready.Wait(ctx, cfg.ReadyTimeout, func(ctx context.Context) error {
+		_, err := get(ctx, address, DashboardHostname(cfg.TLDs[0]), "/ping")
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("the proxy didn't answer on %v: %v. Run docker logs %v to see why", address, err, ContainerName)
+	}
+	return nil
+}
+
+// writeStaticConfig writes Traefik's static config to the TLS directory, returning what it wrote.
+func writeStaticConfig(dir string, autoHostnames bool, tlds []string, network string) ([]byte, error) {
+	contents, err := createStaticConfig(autoHostnames, tlds, network)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(staticConfigPath(dir), contents, 0644); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// writeRoutes writes the Traefik dynamic config for the specified routes to the TLS directory,
+// removing it instead if there aren't any routes.
+func writeRoutes(dir string, routes []config.Route) error {
+	path := routesConfigPath(dir)
+
+	if len(routes) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	contents, err := createRoutesConfig(routes)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, contents, 0644)
+}
+
+// createRoutesConfig creates the Traefik dynamic config that sends requests for each route's host
+// to its URL.
+func createRoutesConfig(routes []config.Route) ([]byte, error) {
+	routesConfig := RoutesConfig{}
+	routesConfig.Http.Routers = make(map[string]RouterConfig)
+	routesConfig.Http.Services = make(map[string]ServiceConfig)
+
+	for _, route := range routes {
+		name := fmt.Sprintf("falcon-%v", strings.ReplaceAll(route.Host, ".", "-"))
+
+		service := ServiceConfig{}
+		service.LoadBalancer.Servers = []ServerConfig{{URL: route.URL}}
+
+		routesConfig.Http.Routers[name] = RouterConfig{Rule: createHostRule([]string{route.Host}), Service: name}
+		routesConfig.Http.Services[name] = service
+	}
+
+	return yaml.Marshal(&routesConfig)
+}
+
+// DashboardHostname returns the hostname of Traefik's dashboard and API under the specified TLD.
+func DashboardHostname(tld string) string {
+	return fmt.Sprintf("%v.%v", dashboardName, tld)
+}
+
+// createHostRule creates a Traefik rule that matches any of the specified hostnames.
+func createHostRule(hostnames []string) string {
+	quoted := make([]string, 0, len(hostnames))
+
+	for _, hostname := range hostnames {
+		quoted = append(quoted, fmt.Sprintf("`%v`", hostname))
+	}
+
+	return fmt.Sprintf("Host(%v)", strings.Join(quoted, ", "))
 }
 
 // Stop stops the falcon-proxy container.
-func Stop(client docker.DockerClient) error {
-	return client.StopAndRemoveContainer(proxyContainerName)
+func Stop(ctx context.Context, client docker.DockerClient) error {
+	return client.StopAndRemoveContainer(ctx, ContainerName)
 }
 
 // EnableTlsForHost creates the certificate files necessary for the specified
-// hostname in the falcon certs directory and adds them to the Traefik dynamic
-// config that gets mounted inside the falcon-proxy container.
-func EnableTlsForHost(hostname string) error {
-	if err := ensureTlsConfig(); err != nil {
+// hostname in the certs directory of the specified TLS directory and adds them
+// to the Traefik dynamic config that gets mounted inside the falcon-proxy container.
+func EnableTlsForHost(dir string, hostname string) error {
+	if err := ensureTlsConfig(dir); err != nil {
 		return err
 	}
 
@@ -97,7 +359,7 @@ func EnableTlsForHost(hostname string) error {
 	// we want the certificates, but due to a bug in mkcerts arg parsing code,
 	// we can't do that. That would be much easier to test, but this works for
 	// now.
-	if err := os.Chdir(certificatesDir); err != nil {
+	if err := os.Chdir(certificatesDir(dir)); err != nil {
 		return err
 	}
 
@@ -105,7 +367,7 @@ func EnableTlsForHost(hostname string) error {
 		return err
 	}
 
-	config, err := os.ReadFile(dynamicConfigPath)
+	config, err := os.ReadFile(dynamicConfigPath(dir))
 
 	if err != nil {
 		return err
@@ -117,7 +379,7 @@ func EnableTlsForHost(hostname string) error {
 		return err
 	}
 
-	if err := os.WriteFile(dynamicConfigPath, []byte(newConfig), 0755); err != nil {
+	if err := os.WriteFile(dynamicConfigPath(dir), []byte(newConfig), 0755); err != nil {
 		return err
 	}
 
@@ -167,13 +429,13 @@ func createKeyFileName(hostname string) string {
 
 // ensureTlsConfig ensures both that the certificates directory exists,
 // and that the Traefik dynamic config exists as well.
-func ensureTlsConfig() error {
-	if err := os.MkdirAll(certificatesDir, 0755); err != nil {
+func ensureTlsConfig(dir string) error {
+	if err := os.MkdirAll(certificatesDir(dir), 0755); err != nil {
 		return err
 	}
 
-	if _, err := os.Stat(dynamicConfigPath); os.IsNotExist(err) {
-		if err := os.WriteFile(dynamicConfigPath, []byte(defaultConfig), 0755); err != nil {
+	if _, err := os.Stat(dynamicConfigPath(dir)); os.IsNotExist(err) {
+		if err := os.WriteFile(dynamicConfigPath(dir), []byte(defaultConfig), 0755); err != nil {
 			return err
 		} else {
 			return err
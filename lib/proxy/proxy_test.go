@@ -1,9 +1,18 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/listen"
+	"github.com/Hawkbawk/falcon/lib/traefik"
 	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -15,40 +24,235 @@ var _ = Describe("Proxy", func() {
 		ctrl       *gomock.Controller
 		mockClient *mock_docker.MockDockerClient
 		hostname   = "example.com"
+		cfg        config.Config
+		addresses  listen.Addresses
 	)
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
 		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		cfg = config.Defaults()
+		cfg.TLS.Dir = GinkgoT().TempDir()
+		addresses = cfg.Addresses(cfg.LoopbackAddress)
 	})
 
+	// staticConfig parses the static config created with the specified settings.
+	staticConfig := func(autoHostnames bool, tlds []string, network string) StaticConfig {
+		contents, err := createStaticConfig(autoHostnames, tlds, network)
+		Expect(err).NotTo(HaveOccurred())
+
+		parsed := StaticConfig{}
+		Expect(yaml.Unmarshal(contents, &parsed)).To(Succeed())
+		return parsed
+	}
+
 	Describe("Start", func() {
+		var defaultStaticConfig []byte
+
+		BeforeEach(func() {
+			var err error
+			defaultStaticConfig, err = createStaticConfig(false, []string{"docker"}, "falcon")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// started describes the proxy container once it's started, on each of the specified networks.
+		started := func(networks ...string) *types.Container {
+			container := &types.Container{ID: "proxy", Names: []string{"/" + ContainerName}, State: "running"}
+			container.NetworkSettings = &types.SummaryNetworkSettings{Networks: map[string]*networktypes.EndpointSettings{}}
+			for _, network := range networks {
+				container.NetworkSettings.Networks[network] = &networktypes.EndpointSettings{}
+			}
+			return container
+		}
+
 		It("tries to start the proxy container and returns no errors", func() {
-			mockClient.EXPECT().StartContainer(proxyImageName, hostConfig, containerConfig, proxyContainerName).Return(nil)
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(nil)
+			mockClient.EXPECT().GetContainer(context.Background(), ContainerName).Return(started("falcon", BridgeNetwork), nil)
 
-			Expect(Start(mockClient)).To(Succeed())
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Succeed())
+			Expect(routesConfigPath(cfg.TLS.Dir)).NotTo(BeAnExistingFile())
+		})
+
+		It("connects the proxy to the bridge network too with the default config, so containers there can be reached", func() {
+			Expect(cfg.Network.Connect).To(Equal(config.ConnectNone))
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(nil)
+			mockClient.EXPECT().GetContainer(context.Background(), ContainerName).Return(started("falcon"), nil)
+			mockClient.EXPECT().ConnectContainer(context.Background(), BridgeNetwork, "proxy").Return(nil)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Succeed())
+		})
+
+		It("returns an error if the proxy can't be connected to the bridge network", func() {
+			err := fmt.Errorf("problems!")
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(nil)
+			mockClient.EXPECT().GetContainer(context.Background(), ContainerName).Return(started("falcon"), nil)
+			mockClient.EXPECT().ConnectContainer(context.Background(), BridgeNetwork, "proxy").Return(err)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Equal(err))
 		})
 
 		It("returns an error if the container can't be started", func() {
 			err := fmt.Errorf("problems!")
-			mockClient.EXPECT().StartContainer(proxyImageName, hostConfig, containerConfig, proxyContainerName).Return(err)
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(err)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Equal(err))
+		})
+
+		It("writes the static config the container reads", func() {
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(nil)
+			mockClient.EXPECT().GetContainer(context.Background(), ContainerName).Return(started("falcon", BridgeNetwork), nil)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Succeed())
+			Expect(os.ReadFile(staticConfigPath(cfg.TLS.Dir))).To(Equal(defaultStaticConfig))
+		})
+
+		It("writes the configured routes for Traefik", func() {
+			cfg.Routes = []config.Route{{Host: "api.docker", URL: "http://host.docker.internal:3000"}}
+			mockClient.EXPECT().StartContainer(context.Background(), config.DefaultProxyImage, config.PullAlways, false, createHostConfig(cfg.TLS.Dir, "falcon", addresses), createContainerConfig(config.DefaultProxyImage, []string{"docker"}, defaultStaticConfig), ContainerName).Return(nil)
+			mockClient.EXPECT().GetContainer(context.Background(), ContainerName).Return(started("falcon", BridgeNetwork), nil)
+
+			Expect(Start(context.Background(), mockClient, cfg, addresses, false)).To(Succeed())
+			Expect(os.ReadFile(routesConfigPath(cfg.TLS.Dir))).To(ContainSubstring("api.docker"))
+		})
+	})
+
+	Describe("createRoutesConfig", func() {
+		It("sends each route's host to its URL", func() {
+			contents, err := createRoutesConfig([]config.Route{{Host: "api.docker", URL: "http://host.docker.internal:3000"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			routesConfig := RoutesConfig{}
+			Expect(yaml.Unmarshal(contents, &routesConfig)).To(Succeed())
+			Expect(routesConfig.Http.Routers).To(HaveKeyWithValue("falcon-api-docker", RouterConfig{Rule: "Host(`api.docker`)", Service: "falcon-api-docker"}))
+			Expect(routesConfig.Http.Services["falcon-api-docker"].LoadBalancer.Servers).To(Equal([]ServerConfig{{URL: "http://host.docker.internal:3000"}}))
+		})
+	})
+
+	Describe("createHostConfig", func() {
+		It("runs the proxy on the specified network", func() {
+			Expect(createHostConfig(cfg.TLS.Dir, "dev", addresses).NetworkMode).To(Equal(container.NetworkMode("dev")))
+		})
+
+		It("mounts the TLS directory", func() {
+			Expect(createHostConfig("/home/falcon/.falcon", "falcon", addresses).Binds).To(ContainElement("/home/falcon/.falcon:" + proxyConfigDir))
+		})
+
+		It("mounts the static config where the container reads it", func() {
+			Expect(createHostConfig("/home/falcon/.falcon", "falcon", addresses).Binds).To(ContainElement("/home/falcon/.falcon/traefik.static:" + staticConfigFile + ":ro"))
+		})
+
+		It("publishes HTTP and HTTPS on the configured addresses", func() {
+			addresses := listen.Addresses{ProxyIP: "127.0.0.1", HTTPPort: 8080, HTTPSPort: 8443}
+			bindings := createHostConfig(cfg.TLS.Dir, "falcon", addresses).PortBindings
+
+			Expect(bindings["80"][0].HostIP).To(Equal("127.0.0.1"))
+			Expect(bindings["80"][0].HostPort).To(Equal("8080"))
+			Expect(bindings["443"][0].HostIP).To(Equal("127.0.0.1"))
+			Expect(bindings["443"][0].HostPort).To(Equal("8443"))
+		})
+	})
+
+	Describe("createContainerConfig", func() {
+		It("serves the dashboard under every TLD", func() {
+			containerConfig := createContainerConfig(config.DefaultProxyImage, []string{"docker", "test"}, nil)
+
+			Expect(containerConfig.Labels).To(HaveKeyWithValue("traefik.http.routers.traefik.rule", "Host(`traefik.docker`, `traefik.test`)"))
+		})
+
+		It("tells Traefik to read falcon's static config, rather than any baked into the image", func() {
+			Expect(createContainerConfig(config.DefaultProxyImage, []string{"docker"}, nil).Cmd).To(ConsistOf("--configFile=" + staticConfigFile))
+		})
+
+		It("changes when the static config does, so the container is recreated", func() {
+			before := createContainerConfig(config.DefaultProxyImage, []string{"docker"}, []byte("before"))
+			after := createContainerConfig(config.DefaultProxyImage, []string{"docker"}, []byte("after"))
+
+			Expect(before.Labels[StaticConfigLabel]).NotTo(Equal(after.Labels[StaticConfigLabel]))
+		})
+	})
+
+	Describe("createStaticConfig", func() {
+		It("only routes containers that ask for it by default", func() {
+			docker := staticConfig(false, []string{"docker"}, "falcon").Providers.Docker
+
+			Expect(docker.ExposedByDefault).To(BeFalse())
+			Expect(docker.DefaultRule).To(BeEmpty())
+		})
+
+		It("says not to route every container, since Traefik does unless it's told otherwise", func() {
+			contents, err := createStaticConfig(false, []string{"docker"}, "falcon")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(contents)).To(ContainSubstring("exposedByDefault: false"))
+		})
+
+		It("routes every container using the default rule with automatic hostnames", func() {
+			docker := staticConfig(true, []string{"docker"}, "falcon").Providers.Docker
+
+			Expect(docker.ExposedByDefault).To(BeTrue())
+			Expect(docker.DefaultRule).To(Equal(traefik.DefaultRule([]string{"docker"})))
+		})
+
+		It("enables the ping endpoint", func() {
+			contents, err := createStaticConfig(false, []string{"docker"}, "falcon")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(contents)).To(ContainSubstring("ping: {}"))
+		})
+
+		It("reaches containers on falcon's network", func() {
+			Expect(staticConfig(false, []string{"docker"}, "dev").Providers.Docker.Network).To(Equal("dev"))
+		})
+
+		It("watches the mounted TLS directory for dynamic config", func() {
+			file := staticConfig(false, []string{"docker"}, "falcon").Providers.File
+
+			Expect(file.Directory).To(Equal(proxyConfigDir))
+			Expect(file.Watch).To(BeTrue())
+		})
+	})
+
+	Describe("WaitUntilReady", func() {
+		BeforeEach(func() {
+			cfg.ReadyTimeout = 50 * time.Millisecond
+		})
+
+		It("returns once Traefik answers on its ping endpoint", func() {
+			var requested []string
+			get := func(_ context.Context, address string, hostname string, path string) ([]byte, error) {
+				requested = append(requested, address, hostname, path)
+				return []byte("OK"), nil
+			}
+
+			Expect(WaitUntilReady(context.Background(), cfg, addresses.HTTP(), get)).To(Succeed())
+			Expect(requested).To(Equal([]string{addresses.HTTP(), "traefik.docker", "/ping"}))
+		})
+
+		It("gives up if Traefik never answers", func() {
+			get := func(context.Context, string, string, string) ([]byte, error) {
+				return nil, fmt.Errorf("Traefik's API responded to /ping with 404 Not Found")
+			}
+
+			err := WaitUntilReady(context.Background(), cfg, addresses.HTTP(), get)
 
-			Expect(Start(mockClient)).To(Equal(err))
+			Expect(err).To(MatchError(ContainSubstring("the proxy didn't answer on " + addresses.HTTP())))
+			Expect(err).To(MatchError(ContainSubstring("still not ready after 50ms: Traefik's API responded to /ping with 404 Not Found")))
+			Expect(err).To(MatchError(HaveSuffix("Run docker logs falcon-proxy to see why")))
 		})
 	})
 
 	Describe("Stop", func() {
 		It("tries to stop the proxy container and returns no errors", func() {
-			mockClient.EXPECT().StopAndRemoveContainer(proxyContainerName).Return(nil)
+			mockClient.EXPECT().StopAndRemoveContainer(context.Background(), ContainerName).Return(nil)
 
-			Expect(Stop(mockClient)).To(Succeed())
+			Expect(Stop(context.Background(), mockClient)).To(Succeed())
 		})
 
 		It("returns an error if the container can't be stopped", func() {
 			err := fmt.Errorf("problems!")
-			mockClient.EXPECT().StopAndRemoveContainer(proxyContainerName).Return(err)
+			mockClient.EXPECT().StopAndRemoveContainer(context.Background(), ContainerName).Return(err)
 
-			Expect(Stop(mockClient)).To(Equal(err))
+			Expect(Stop(context.Background(), mockClient)).To(Equal(err))
 		})
 	})
 
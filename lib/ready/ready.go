@@ -0,0 +1,39 @@
+// The ready package waits for falcon's containers to start answering requests, since a container
+// that Docker says is running may still be starting up.
+package ready
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How long we wait between checks.
+var interval = 250 * time.Millisecond
+
+// Wait runs check until it succeeds, giving up once the specified timeout runs out or ctx is done.
+// The error check returned last is included when Wait gives up, since it's usually the best hint as
+// to what's wrong.
+func Wait(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) error {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := check(waitCtx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("still not ready after %v: %w", timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
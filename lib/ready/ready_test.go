@@ -0,0 +1,61 @@
+package ready
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wait", func() {
+	BeforeEach(func() {
+		original := interval
+		interval = time.Millisecond
+		DeferCleanup(func() { interval = original })
+	})
+
+	It("returns once the check succeeds", func() {
+		calls := 0
+		check := func(context.Context) error {
+			calls++
+			if calls < 3 {
+				return fmt.Errorf("not yet")
+			}
+			return nil
+		}
+
+		Expect(Wait(context.Background(), time.Second, check)).To(Succeed())
+		Expect(calls).To(Equal(3))
+	})
+
+	It("gives up with the last error once the timeout runs out", func() {
+		check := func(context.Context) error { return fmt.Errorf("connection refused") }
+
+		err := Wait(context.Background(), 20*time.Millisecond, check)
+
+		Expect(err).To(MatchError("still not ready after 20ms: connection refused"))
+	})
+
+	It("gives up as soon as ctx is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		check := func(context.Context) error {
+			cancel()
+			return fmt.Errorf("connection refused")
+		}
+
+		Expect(Wait(ctx, time.Minute, check)).To(MatchError("connection refused"))
+	})
+
+	It("passes a context that's done once the timeout runs out to the check", func() {
+		var deadline time.Time
+		check := func(ctx context.Context) error {
+			deadline, _ = ctx.Deadline()
+			return nil
+		}
+
+		Expect(Wait(context.Background(), time.Minute, check)).To(Succeed())
+		Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+	})
+})
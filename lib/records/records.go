@@ -0,0 +1,326 @@
+// The records package keeps DNS records for individual containers, which resolve straight to the
+// container's own address rather than to the proxy, so that services that don't speak HTTP, like
+// Postgres or Redis, can be reached by name too. The records are written to a hosts file that both
+// dnsmasq and falcon's own DNS server read, and that wins over the wildcard for falcon's TLDs.
+package records
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/docker/docker/api/types"
+)
+
+// DNSLabel is the label a container sets to the hostnames it wants records for, separated by commas,
+// like "db" or "db.docker,postgres.test". Hostnames that aren't under one of falcon's TLDs are put
+// under the primary TLD.
+const DNSLabel = "falcon.dns"
+
+// The labels Docker Compose sets on the containers it starts.
+const (
+	composeServiceLabel = "com.docker.compose.service"
+	composeProjectLabel = "com.docker.compose.project"
+)
+
+// FileName is the name of the hosts file the records are written to, in the configured directory.
+const FileName = "containers"
+
+// Every part of a hostname has to be a valid DNS label.
+var hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Record resolves a hostname to the address of the container that asked for it.
+type Record struct {
+	Hostname  string
+	Address   string
+	Container string
+}
+
+// Path returns the path of the hosts file the records are written to with the specified config.
+func Path(cfg config.Config) string {
+	return filepath.Join(cfg.Records.Dir, FileName)
+}
+
+// Update works out the records for the running containers the configured record policy selects and
+// writes them to the hosts file, returning them along with a description of each record that had
+// to be left out. With the none policy, the hosts file is removed instead.
+func Update(ctx context.Context, client docker.DockerClient, cfg config.Config) ([]Record, []string, error) {
+	if cfg.Records.Containers == config.RecordsNone {
+		return []Record{}, []string{}, Remove(cfg)
+	}
+
+	containers, err := client.ListContainers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, problems := Collect(containers, cfg)
+	return records, problems, Write(Path(cfg), records)
+}
+
+// Remove removes the hosts file, if there is one.
+func Remove(cfg config.Config) error {
+	if err := os.Remove(Path(cfg)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Watch updates the records whenever a container starts or stops, or is connected to or
+// disconnected from a network, which changes its address, until ctx is done. updated is
+// called each time the records change, or with the error if they couldn't be updated, since one
+// failed update shouldn't stop the next. An error is only returned if Docker stops sending events.
+func Watch(ctx context.Context, client docker.DockerClient, cfg config.Config, updated func(records []Record, problems []string, err error)) error {
+	messages, errs := client.ContainerEvents(ctx)
+	var last []Record
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case message := <-messages:
+			switch message.Action {
+			case "start", "die", "connect", "disconnect":
+			default:
+				continue
+			}
+			records, problems, err := Update(ctx, client, cfg)
+			if err != nil {
+				updated(nil, nil, err)
+			} else if !Same(records, last) {
+				last = records
+				updated(records, problems, nil)
+			}
+		}
+	}
+}
+
+// Collect works out the records for the specified containers with the specified config. Hostnames
+// from a falcon.dns label win over the ones made from Docker Compose service names, but when two
+// containers ask for the same hostname in the same way, neither gets it, since there's no telling
+// which one was meant. Each record left out is described in the returned problems. The records are
+// sorted by hostname.
+func Collect(containers []types.Container, cfg config.Config) ([]Record, []string) {
+	problems := make([]string, 0)
+	labelled := make(map[string][]Record)
+	composed := make(map[string][]Record)
+
+	for _, container := range containers {
+		hostnames, invalid := labelledHostnames(container.Labels[DNSLabel], cfg.TLDs)
+		for _, hostname := range invalid {
+			problems = append(problems, fmt.Sprintf("%q in %v's %v label isn't a valid hostname", hostname, docker.ContainerName(container), DNSLabel))
+		}
+
+		var composeHostnames []string
+		if cfg.Records.Containers == config.RecordsCompose {
+			composeHostnames = serviceHostnames(container.Labels, cfg.TLDs[0])
+		}
+
+		if len(hostnames) == 0 && len(composeHostnames) == 0 {
+			continue
+		}
+
+		address := containerAddress(container, cfg.Network.Name)
+		if address == "" {
+			problems = append(problems, fmt.Sprintf("%v doesn't have an address of its own, so it doesn't get any records", docker.ContainerName(container)))
+			continue
+		}
+
+		for _, hostname := range hostnames {
+			labelled[hostname] = append(labelled[hostname], Record{Hostname: hostname, Address: address, Container: docker.ContainerName(container)})
+		}
+		for _, hostname := range composeHostnames {
+			composed[hostname] = append(composed[hostname], Record{Hostname: hostname, Address: address, Container: docker.ContainerName(container)})
+		}
+	}
+
+	records := make([]Record, 0, len(labelled)+len(composed))
+	for hostname, claims := range composed {
+		if _, ok := labelled[hostname]; !ok {
+			labelled[hostname] = claims
+		}
+	}
+	for hostname, claims := range labelled {
+		if len(claims) == 1 {
+			records = append(records, claims[0])
+			continue
+		}
+
+		names := make([]string, 0, len(claims))
+		for _, claim := range claims {
+			names = append(names, claim.Container)
+		}
+		sort.Strings(names)
+		problems = append(problems, fmt.Sprintf("%v is wanted by %v, so none of them get it", hostname, strings.Join(names, " and ")))
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Hostname < records[j].Hostname })
+	sort.Strings(problems)
+	return records, problems
+}
+
+// labelledHostnames returns the hostnames asked for by the specified falcon.dns label, putting any
+// that aren't under one of the specified TLDs under the first one, along with any that aren't
+// valid hostnames.
+func labelledHostnames(label string, tlds []string) ([]string, []string) {
+	hostnames := make([]string, 0)
+	invalid := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, hostname := range strings.FieldsFunc(label, func(r rune) bool { return r == ',' || r == ' ' }) {
+		hostname = strings.Trim(strings.ToLower(hostname), ".")
+
+		if !validHostname(hostname) {
+			invalid = append(invalid, hostname)
+			continue
+		} else if !underTLD(hostname, tlds) {
+			hostname = fmt.Sprintf("%v.%v", hostname, tlds[0])
+		}
+
+		if !seen[hostname] {
+			seen[hostname] = true
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	return hostnames, invalid
+}
+
+// serviceHostnames returns the hostnames for the Docker Compose service the container with the
+// specified labels belongs to, like "db.docker" and "db.shop.docker" for the db service of the shop
+// project. Service and project names that aren't valid hostnames, like ones with underscores, don't
+// get any.
+func serviceHostnames(labels map[string]string, tld string) []string {
+	service := strings.ToLower(labels[composeServiceLabel])
+	project := strings.ToLower(labels[composeProjectLabel])
+
+	if !hostnameLabel.MatchString(service) {
+		return nil
+	}
+
+	hostnames := []string{fmt.Sprintf("%v.%v", service, tld)}
+	if hostnameLabel.MatchString(project) {
+		hostnames = append(hostnames, fmt.Sprintf("%v.%v.%v", service, project, tld))
+	}
+
+	return hostnames
+}
+
+// validHostname reports whether every part of the specified hostname is a valid DNS label.
+func validHostname(hostname string) bool {
+	for _, part := range strings.Split(hostname, ".") {
+		if !hostnameLabel.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// underTLD reports whether the specified hostname is under one of the specified TLDs.
+func underTLD(hostname string, tlds []string) bool {
+	for _, tld := range tlds {
+		if strings.HasSuffix(hostname, "."+tld) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerAddress returns the specified container's address on falcon's network, or on the first
+// of its other networks if it isn't on falcon's. A container using the host's network or another
+// container's doesn't have an address of its own, so the empty string is returned.
+func containerAddress(container types.Container, network string) string {
+	if container.NetworkSettings == nil {
+		return ""
+	}
+
+	networks := container.NetworkSettings.Networks
+	if settings, ok := networks[network]; ok && settings != nil && settings.IPAddress != "" {
+		return settings.IPAddress
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if settings := networks[name]; settings != nil && settings.IPAddress != "" {
+			return settings.IPAddress
+		}
+	}
+
+	return ""
+}
+
+// Write writes the specified records to the hosts file at the specified path. The file is written
+// to a temporary file first and then moved into place, so that dnsmasq never reads it half-written.
+func Write(path string, records []Record) error {
+	var contents bytes.Buffer
+	contents.WriteString("# DNS records falcon keeps for individual containers. falcon rewrites this file\n")
+	contents.WriteString("# whenever containers start or stop, so don't edit it.\n")
+	for _, record := range records {
+		fmt.Fprintf(&contents, "%v %v # %v\n", record.Address, record.Hostname, record.Container)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(contents.Bytes()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// Log describes each of the specified records, and each record that had to be left out, using log.
+func Log(records []Record, problems []string, log func(format string, args ...interface{})) {
+	for _, record := range records {
+		log("%v resolves to %v (%v).", record.Hostname, record.Address, record.Container)
+	}
+	for _, problem := range problems {
+		log("Left out a DNS record: %v.", problem)
+	}
+}
+
+// Same reports whether the two lists of records are the same, in the same order.
+func Same(a []Record, b []Record) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,13 @@
+package records
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecords(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Records Suite")
+}
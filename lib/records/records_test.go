@@ -0,0 +1,239 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// running describes a running container with the specified name and labels, with the specified
+// address on each of the specified networks.
+func running(name string, labels map[string]string, networks map[string]string) types.Container {
+	container := types.Container{ID: name, Names: []string{"/" + name}, Labels: labels}
+	container.NetworkSettings = &types.SummaryNetworkSettings{Networks: map[string]*networktypes.EndpointSettings{}}
+	for network, address := range networks {
+		container.NetworkSettings.Networks[network] = &networktypes.EndpointSettings{IPAddress: address}
+	}
+	return container
+}
+
+var _ = Describe("Records", func() {
+	var (
+		ctrl       *gomock.Controller
+		mockClient *mock_docker.MockDockerClient
+		cfg        config.Config
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		cfg = config.Defaults()
+		cfg.TLDs = []string{"docker", "test"}
+		cfg.Records.Dir = GinkgoT().TempDir()
+	})
+
+	Describe("Collect", func() {
+		bridge := map[string]string{"bridge": "172.17.0.2"}
+
+		It("puts labelled hostnames under the primary TLD unless they're under one already", func() {
+			records, problems := Collect([]types.Container{
+				running("postgres", map[string]string{DNSLabel: "db, postgres.test"}, bridge),
+			}, cfg)
+
+			Expect(problems).To(BeEmpty())
+			Expect(records).To(Equal([]Record{
+				{Hostname: "db.docker", Address: "172.17.0.2", Container: "postgres"},
+				{Hostname: "postgres.test", Address: "172.17.0.2", Container: "postgres"},
+			}))
+		})
+
+		It("prefers the container's address on falcon's network", func() {
+			records, _ := Collect([]types.Container{
+				running("redis", map[string]string{DNSLabel: "cache"}, map[string]string{"bridge": "172.17.0.3", "falcon": "172.30.0.3"}),
+			}, cfg)
+
+			Expect(records).To(ConsistOf(Record{Hostname: "cache.docker", Address: "172.30.0.3", Container: "redis"}))
+		})
+
+		It("only uses Docker Compose service names with the compose policy", func() {
+			compose := map[string]string{composeServiceLabel: "db", composeProjectLabel: "shop"}
+			containers := []types.Container{running("shop-db-1", compose, bridge)}
+
+			records, _ := Collect(containers, cfg)
+			Expect(records).To(BeEmpty())
+
+			cfg.Records.Containers = config.RecordsCompose
+			records, _ = Collect(containers, cfg)
+			Expect(records).To(Equal([]Record{
+				{Hostname: "db.docker", Address: "172.17.0.2", Container: "shop-db-1"},
+				{Hostname: "db.shop.docker", Address: "172.17.0.2", Container: "shop-db-1"},
+			}))
+		})
+
+		It("leaves out hostnames two containers want, unless only one asked with a label", func() {
+			cfg.Records.Containers = config.RecordsCompose
+			records, problems := Collect([]types.Container{
+				running("shop-db-1", map[string]string{composeServiceLabel: "db", composeProjectLabel: "shop"}, map[string]string{"bridge": "172.17.0.2"}),
+				running("blog-db-1", map[string]string{composeServiceLabel: "db", composeProjectLabel: "blog"}, map[string]string{"bridge": "172.17.0.3"}),
+				running("cache-1", map[string]string{DNSLabel: "cache"}, map[string]string{"bridge": "172.17.0.4"}),
+				running("cache-2", map[string]string{DNSLabel: "cache"}, map[string]string{"bridge": "172.17.0.5"}),
+				running("search", map[string]string{DNSLabel: "db.blog"}, map[string]string{"bridge": "172.17.0.6"}),
+			}, cfg)
+
+			Expect(records).To(Equal([]Record{
+				{Hostname: "db.blog.docker", Address: "172.17.0.6", Container: "search"},
+				{Hostname: "db.shop.docker", Address: "172.17.0.2", Container: "shop-db-1"},
+			}))
+			Expect(problems).To(Equal([]string{
+				"cache.docker is wanted by cache-1 and cache-2, so none of them get it",
+				"db.docker is wanted by blog-db-1 and shop-db-1, so none of them get it",
+			}))
+		})
+
+		It("describes hostnames it can't use", func() {
+			hostNetwork := running("host", map[string]string{DNSLabel: "host"}, nil)
+			records, problems := Collect([]types.Container{
+				running("app", map[string]string{DNSLabel: "bad_name,ok"}, bridge),
+				hostNetwork,
+			}, cfg)
+
+			Expect(records).To(ConsistOf(Record{Hostname: "ok.docker", Address: "172.17.0.2", Container: "app"}))
+			Expect(problems).To(Equal([]string{
+				`"bad_name" in app's falcon.dns label isn't a valid hostname`,
+				"host doesn't have an address of its own, so it doesn't get any records",
+			}))
+		})
+	})
+
+	Describe("Log", func() {
+		It("describes each record and each one that was left out", func() {
+			lines := make([]string, 0)
+			log := func(format string, args ...interface{}) { lines = append(lines, fmt.Sprintf(format, args...)) }
+
+			Log([]Record{{Hostname: "db.docker", Address: "172.17.0.3", Container: "postgres"}}, []string{"db.docker is wanted by a and b, so none of them get it"}, log)
+			Expect(lines).To(Equal([]string{
+				"db.docker resolves to 172.17.0.3 (postgres).",
+				"Left out a DNS record: db.docker is wanted by a and b, so none of them get it.",
+			}))
+		})
+	})
+
+	Describe("Update", func() {
+		It("writes the records to the hosts file", func() {
+			mockClient.EXPECT().ListContainers(context.Background()).Return([]types.Container{
+				running("postgres", map[string]string{DNSLabel: "db"}, map[string]string{"bridge": "172.17.0.2"}),
+			}, nil)
+
+			records, _, err := Update(context.Background(), mockClient, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(records).To(HaveLen(1))
+
+			contents, err := os.ReadFile(Path(cfg))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(HaveSuffix("\n172.17.0.2 db.docker # postgres\n"))
+		})
+
+		It("removes the hosts file with the none policy", func() {
+			Expect(Write(Path(cfg), nil)).To(Succeed())
+			cfg.Records.Containers = config.RecordsNone
+
+			_, _, err := Update(context.Background(), mockClient, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(Path(cfg)).NotTo(BeAnExistingFile())
+		})
+
+		It("returns the error if the containers can't be listed", func() {
+			err := fmt.Errorf("problems!")
+			mockClient.EXPECT().ListContainers(context.Background()).Return(nil, err)
+
+			_, _, updateErr := Update(context.Background(), mockClient, cfg)
+			Expect(updateErr).To(Equal(err))
+		})
+	})
+
+	Describe("Watch", func() {
+		var (
+			ctx      context.Context
+			cancel   context.CancelFunc
+			messages chan events.Message
+			errs     chan error
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			messages = make(chan events.Message)
+			errs = make(chan error, 1)
+			mockClient.EXPECT().ContainerEvents(ctx).Return((<-chan events.Message)(messages), (<-chan error)(errs))
+		})
+
+		It("updates the records as containers start and stop until ctx is done", func() {
+			postgres := running("postgres", map[string]string{DNSLabel: "db"}, map[string]string{"bridge": "172.17.0.2"})
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{postgres}, nil).Times(2)
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{}, nil)
+
+			updates := make([][]Record, 0)
+			done := make(chan error)
+			go func() {
+				done <- Watch(ctx, mockClient, cfg, func(records []Record, problems []string, err error) {
+					Expect(err).NotTo(HaveOccurred())
+					updates = append(updates, records)
+				})
+			}()
+
+			messages <- events.Message{Action: "start", Actor: events.Actor{ID: "postgres"}}
+			messages <- events.Message{Action: "pause", Actor: events.Actor{ID: "postgres"}}
+			messages <- events.Message{Action: "start", Actor: events.Actor{ID: "app"}}
+			messages <- events.Message{Action: "die", Actor: events.Actor{ID: "postgres"}}
+			cancel()
+
+			Expect(<-done).To(Succeed())
+			Expect(updates).To(Equal([][]Record{
+				{{Hostname: "db.docker", Address: "172.17.0.2", Container: "postgres"}},
+				{},
+			}))
+		})
+
+		It("updates the records when a container is connected to a network after it starts", func() {
+			app := running("app", map[string]string{DNSLabel: "app"}, map[string]string{"bridge": "172.17.0.2"})
+			connected := running("app", map[string]string{DNSLabel: "app"}, map[string]string{"bridge": "172.17.0.2", "falcon": "172.30.0.5"})
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{app}, nil)
+			mockClient.EXPECT().ListContainers(ctx).Return([]types.Container{connected}, nil)
+
+			updates := make([][]Record, 0)
+			done := make(chan error)
+			go func() {
+				done <- Watch(ctx, mockClient, cfg, func(records []Record, problems []string, err error) {
+					Expect(err).NotTo(HaveOccurred())
+					updates = append(updates, records)
+				})
+			}()
+
+			messages <- events.Message{Type: events.ContainerEventType, Action: "start", Actor: events.Actor{ID: "app"}}
+			messages <- events.Message{Type: events.NetworkEventType, Action: "connect", Actor: events.Actor{ID: "falcon", Attributes: map[string]string{"container": "app", "name": "falcon"}}}
+			cancel()
+
+			Expect(<-done).To(Succeed())
+			Expect(updates).To(Equal([][]Record{
+				{{Hostname: "app.docker", Address: "172.17.0.2", Container: "app"}},
+				{{Hostname: "app.docker", Address: "172.30.0.5", Container: "app"}},
+			}))
+		})
+
+		It("returns the error if Docker stops sending events", func() {
+			err := fmt.Errorf("connection reset")
+			errs <- err
+
+			Expect(Watch(ctx, mockClient, cfg, func([]Record, []string, error) {})).To(Equal(err))
+		})
+	})
+})
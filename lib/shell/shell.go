@@ -1,7 +1,9 @@
 package shell
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 )
 
@@ -38,3 +40,33 @@ func RunCommand(command string) error {
 	}
 	return nil
 }
+
+// RunCommandWithInput works just like RunCommand, but feeds the specified input to the commands
+// through stdin. This is useful for writing arbitrary contents to a file through something like
+// "sudo tee", without having to worry about escaping those contents for the shell.
+func RunCommandWithInput(command string, input io.Reader) error {
+	cmd := exec.Command("bash", "-c", command)
+	cmd.Stdin = input
+
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("Command(s) failed to run due to the following error: %v with the following output: %v", err, string(output))
+	}
+	return nil
+}
+
+// CommandOutput works just like RunCommand, but returns what the commands wrote to stdout. This is
+// useful for asking a tool about the machine's current state.
+func CommandOutput(command string) (string, error) {
+	cmd := exec.Command("bash", "-c", command)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+
+	if err != nil {
+		return "", fmt.Errorf("Command(s) failed to run due to the following error: %v with the following output: %v", err, stderr.String())
+	}
+	return string(output), nil
+}
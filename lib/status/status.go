@@ -0,0 +1,153 @@
+// The status package checks the health of every part of falcon: the containers it runs, the
+// networking changes it makes to the host machine, and whether falcon's domains actually resolve.
+package status
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/networking"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+)
+
+// Component describes the health of a single part of falcon.
+type Component struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Details explains what state the component is in, and what's wrong with it if it's unhealthy.
+	Details []string `json:"details"`
+	// Digest is the repo digest of the image a container is running, like
+	// "hawkbawk/falcon-proxy@sha256:...", if it has one.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Summary describes the health of every part of falcon.
+type Summary struct {
+	Backend    string      `json:"backend"`
+	Healthy    bool        `json:"healthy"`
+	Components []Component `json:"components"`
+}
+
+// Check checks the health of the proxy container, whatever answers DNS queries for falcon's
+// domains, the networking set up by the specified backend for the specified config, and whether
+// domains under the primary TLD resolve to the host machine using lookupHost. When the config uses
+// falcon's own DNS server, there's no container to check, so it's asked directly using queryDNS.
+func Check(ctx context.Context, client docker.DockerClient, backend networking.Backend, cfg config.Config, lookupHost func(string) ([]string, error), queryDNS func(string) ([]string, error)) Summary {
+	proxyContainer := checkContainer(ctx, client, "proxy container", proxy.ContainerName)
+
+	var dnsServer Component
+	if cfg.DNSServer == config.DNSServerBuiltin {
+		dnsServer = checkDNSServer(queryDNS, cfg.TLDs[0], cfg.LoopbackAddress)
+	} else {
+		dnsServer = checkContainer(ctx, client, "dnsmasq container", dnsmasq.ContainerName)
+	}
+
+	summary := Summary{
+		Backend: backend.Name(),
+		Components: []Component{
+			proxyContainer,
+			dnsServer,
+			checkNetworking(backend, cfg),
+			checkResolution(lookupHost, cfg.TLDs[0], networking.Address(backend, cfg.LoopbackAddress)),
+		},
+	}
+
+	summary.Healthy = true
+	for _, component := range summary.Components {
+		summary.Healthy = summary.Healthy && component.Healthy
+	}
+
+	return summary
+}
+
+// checkContainer checks that the container with the specified name exists and is running, and
+// records the digest of the image it's running, since a tag like latest doesn't say which image
+// that was.
+func checkContainer(ctx context.Context, client docker.DockerClient, name string, containerName string) Component {
+	container, err := client.GetContainer(ctx, containerName)
+
+	if err != nil {
+		return unhealthy(name, fmt.Sprintf("unable to inspect %v: %v", containerName, err))
+	} else if container == nil {
+		return unhealthy(name, fmt.Sprintf("%v doesn't exist", containerName))
+	} else if container.State != "running" {
+		return unhealthy(name, fmt.Sprintf("%v is %v (%v)", containerName, container.State, container.Status))
+	}
+
+	component := healthy(name, fmt.Sprintf("%v is running %v (%v)", containerName, container.Image, container.Status))
+	digests, err := client.ImageDigests(ctx, container.ImageID)
+
+	if err != nil {
+		component.Details = append(component.Details, fmt.Sprintf("unable to find the image's digest: %v", err))
+	} else if len(digests) == 0 {
+		component.Details = append(component.Details, "the image wasn't pulled from a registry, so it has no digest")
+	} else {
+		component.Digest = digests[0]
+		component.Details = append(component.Details, fmt.Sprintf("image digest %v", component.Digest))
+	}
+
+	return component
+}
+
+// checkDNSServer checks that falcon's own DNS server answers queries for domains under the specified
+// TLD with the specified loopback address.
+func checkDNSServer(queryDNS func(string) ([]string, error), tld string, loopbackAddress string) Component {
+	name := "dns server"
+	probeHostname := proxy.DashboardHostname(tld)
+	addrs, err := queryDNS(probeHostname)
+
+	if err != nil {
+		return unhealthy(name, fmt.Sprintf("falcon's DNS server didn't answer: %v", err))
+	} else if len(addrs) == 0 || addrs[0] != loopbackAddress {
+		return unhealthy(name, fmt.Sprintf("falcon's DNS server resolved %v to %v instead of %v", probeHostname, addrs, loopbackAddress))
+	}
+
+	return healthy(name, fmt.Sprintf("falcon's DNS server resolves %v to %v", probeHostname, loopbackAddress))
+}
+
+// checkNetworking checks that every change the backend makes to the host machine for the specified
+// config is in place.
+func checkNetworking(backend networking.Backend, cfg config.Config) Component {
+	name := fmt.Sprintf("%v networking", backend.Name())
+	problems, err := backend.Status(cfg)
+
+	if err != nil {
+		return unhealthy(name, fmt.Sprintf("unable to check networking: %v", err))
+	} else if len(problems) > 0 {
+		return unhealthy(name, problems...)
+	}
+
+	return healthy(name, "resolver and loopback address are in place")
+}
+
+// checkResolution checks that domains under the specified TLD resolve to the specified address,
+// which is the one the backend points them at. We try to resolve the dashboard's hostname, since
+// every backend points it at the host machine, even the hosts backend, which can't do wildcards.
+func checkResolution(lookupHost func(string) ([]string, error), tld string, address string) Component {
+	name := fmt.Sprintf("*.%v resolution", tld)
+	probeHostname := proxy.DashboardHostname(tld)
+	addrs, err := lookupHost(probeHostname)
+
+	if err != nil {
+		return unhealthy(name, fmt.Sprintf("unable to resolve %v: %v", probeHostname, err))
+	}
+
+	for _, addr := range addrs {
+		if addr == address {
+			return healthy(name, fmt.Sprintf("%v resolves to %v", probeHostname, addr))
+		}
+	}
+
+	return unhealthy(name, fmt.Sprintf("%v resolves to %v instead of %v", probeHostname, addrs, address))
+}
+
+func healthy(name string, details ...string) Component {
+	return Component{Name: name, Healthy: true, Details: details}
+}
+
+func unhealthy(name string, details ...string) Component {
+	return Component{Name: name, Healthy: false, Details: details}
+}
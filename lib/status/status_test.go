@@ -0,0 +1,226 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Hawkbawk/falcon/lib/config"
+	"github.com/Hawkbawk/falcon/lib/dnsmasq"
+	"github.com/Hawkbawk/falcon/lib/journal"
+	"github.com/Hawkbawk/falcon/lib/networking/hosts"
+	"github.com/Hawkbawk/falcon/lib/networking/loopback"
+	"github.com/Hawkbawk/falcon/lib/proxy"
+	"github.com/Hawkbawk/falcon/mocks/mock_docker"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBackend is a networking backend whose status is whatever the test says it is.
+type fakeBackend struct {
+	problems []string
+	err      error
+}
+
+func (fakeBackend) Name() string                             { return "fake" }
+func (fakeBackend) Detect() (bool, error)                    { return true, nil }
+func (fakeBackend) Steps(config.Config) []journal.Step       { return nil }
+func (b fakeBackend) Status(config.Config) ([]string, error) { return b.problems, b.err }
+
+var _ = Describe("Status", func() {
+	var (
+		ctrl       *gomock.Controller
+		mockClient *mock_docker.MockDockerClient
+		backend    fakeBackend
+		running    = &types.Container{Image: "image", ImageID: "sha256:abcd", State: "running", Status: "Up 5 minutes"}
+		resolves   = func(string) ([]string, error) { return []string{loopback.DefaultAddress}, nil }
+	)
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		mockClient = mock_docker.NewMockDockerClient(ctrl)
+		backend = fakeBackend{problems: []string{}}
+	})
+
+	Describe("Check", func() {
+		It("reports everything as healthy when falcon is up", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().GetContainer(context.Background(), dnsmasq.ContainerName).Return(running, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return([]string{}, nil).Times(2)
+
+			summary := Check(context.Background(), mockClient, backend, config.Defaults(), resolves, resolves)
+
+			Expect(summary.Healthy).To(BeTrue())
+			Expect(summary.Backend).To(Equal("fake"))
+			Expect(summary.Components).To(HaveLen(4))
+			for _, component := range summary.Components {
+				Expect(component.Healthy).To(BeTrue(), component.Name)
+			}
+		})
+
+		It("is unhealthy if any one component is", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().GetContainer(context.Background(), dnsmasq.ContainerName).Return(nil, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return([]string{}, nil)
+
+			summary := Check(context.Background(), mockClient, backend, config.Defaults(), resolves, resolves)
+
+			Expect(summary.Healthy).To(BeFalse())
+			Expect(summary.Components[1].Healthy).To(BeFalse())
+		})
+	})
+
+	Describe("Check with a backend other than hosts", func() {
+		It("doesn't accept falcon's domains resolving to localhost, like from a stale /etc/hosts entry", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().GetContainer(context.Background(), dnsmasq.ContainerName).Return(running, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return([]string{}, nil).Times(2)
+			localhost := func(string) ([]string, error) { return []string{"127.0.0.1"}, nil }
+
+			summary := Check(context.Background(), mockClient, backend, config.Defaults(), localhost, resolves)
+
+			Expect(summary.Healthy).To(BeFalse())
+			Expect(summary.Components[3].Name).To(Equal("*.docker resolution"))
+			Expect(summary.Components[3].Healthy).To(BeFalse())
+		})
+	})
+
+	Describe("Check with the builtin DNS server", func() {
+		It("asks the DNS server instead of checking the dnsmasq container", func() {
+			cfg := config.Defaults()
+			cfg.DNSServer = config.DNSServerBuiltin
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return([]string{}, nil)
+
+			summary := Check(context.Background(), mockClient, backend, cfg, resolves, resolves)
+
+			Expect(summary.Healthy).To(BeTrue())
+			Expect(summary.Components[1].Name).To(Equal("dns server"))
+		})
+	})
+
+	Describe("checkDNSServer", func() {
+		It("is unhealthy if the DNS server doesn't answer", func() {
+			component := checkDNSServer(func(string) ([]string, error) { return nil, fmt.Errorf("i/o timeout") }, "docker", loopback.DefaultAddress)
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("i/o timeout")))
+		})
+
+		It("is unhealthy if the DNS server answers with another address", func() {
+			component := checkDNSServer(func(string) ([]string, error) { return []string{"10.0.0.1"}, nil }, "docker", loopback.DefaultAddress)
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("instead of " + loopback.DefaultAddress)))
+		})
+	})
+
+	Describe("checkContainer", func() {
+		It("records the digest of the image the container is running", func() {
+			digest := "image@sha256:" + strings.Repeat("a", 64)
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return([]string{digest}, nil)
+
+			component := checkContainer(context.Background(), mockClient, "proxy", proxy.ContainerName)
+			Expect(component.Healthy).To(BeTrue())
+			Expect(component.Digest).To(Equal(digest))
+			Expect(component.Details).To(ContainElement("image digest " + digest))
+		})
+
+		It("stays healthy if the image's digest can't be found", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(running, nil)
+			mockClient.EXPECT().ImageDigests(context.Background(), running.ImageID).Return(nil, fmt.Errorf("no such image"))
+
+			component := checkContainer(context.Background(), mockClient, "proxy", proxy.ContainerName)
+			Expect(component.Healthy).To(BeTrue())
+			Expect(component.Digest).To(BeEmpty())
+		})
+
+		It("is unhealthy if the container doesn't exist", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(nil, nil)
+
+			component := checkContainer(context.Background(), mockClient, "proxy", proxy.ContainerName)
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("doesn't exist")))
+		})
+
+		It("is unhealthy if the container isn't running", func() {
+			exited := &types.Container{State: "exited", Status: "Exited (1) 2 minutes ago"}
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(exited, nil)
+
+			component := checkContainer(context.Background(), mockClient, "proxy", proxy.ContainerName)
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("is exited")))
+		})
+
+		It("is unhealthy if Docker can't be reached", func() {
+			mockClient.EXPECT().GetContainer(context.Background(), proxy.ContainerName).Return(nil, fmt.Errorf("no socket"))
+
+			component := checkContainer(context.Background(), mockClient, "proxy", proxy.ContainerName)
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("no socket")))
+		})
+	})
+
+	Describe("checkNetworking", func() {
+		It("lists every problem the backend found", func() {
+			backend.problems = []string{"resolver is missing", "loopback is missing"}
+
+			component := checkNetworking(backend, config.Defaults())
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(Equal(backend.problems))
+		})
+
+		It("is unhealthy if the backend can't check", func() {
+			backend.err = fmt.Errorf("permission denied")
+
+			Expect(checkNetworking(backend, config.Defaults()).Healthy).To(BeFalse())
+		})
+	})
+
+	Describe("checkResolution", func() {
+		It("is unhealthy if *.docker doesn't resolve", func() {
+			component := checkResolution(func(string) ([]string, error) { return nil, fmt.Errorf("no such host") }, "docker", loopback.DefaultAddress)
+
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("no such host")))
+		})
+
+		It("resolves the dashboard under the specified TLD", func() {
+			var resolved string
+			component := checkResolution(func(host string) ([]string, error) {
+				resolved = host
+				return []string{loopback.DefaultAddress}, nil
+			}, "test", loopback.DefaultAddress)
+
+			Expect(component.Healthy).To(BeTrue())
+			Expect(component.Name).To(Equal("*.test resolution"))
+			Expect(resolved).To(Equal("traefik.test"))
+		})
+
+		It("is healthy if *.docker resolves to localhost for the hosts backend", func() {
+			component := checkResolution(func(string) ([]string, error) { return []string{"127.0.0.1"}, nil }, "docker", hosts.Address)
+
+			Expect(component.Healthy).To(BeTrue())
+		})
+
+		It("is unhealthy if *.docker resolves to localhost rather than the loopback address", func() {
+			component := checkResolution(func(string) ([]string, error) { return []string{"127.0.0.1"}, nil }, "docker", loopback.DefaultAddress)
+
+			Expect(component.Healthy).To(BeFalse())
+			Expect(component.Details).To(ConsistOf(ContainSubstring("instead of " + loopback.DefaultAddress)))
+		})
+
+		It("is healthy if *.docker resolves to the configured loopback address", func() {
+			component := checkResolution(func(string) ([]string, error) { return []string{"10.254.254.254"}, nil }, "docker", "10.254.254.254")
+
+			Expect(component.Healthy).To(BeTrue())
+		})
+
+		It("is unhealthy if *.docker resolves somewhere else", func() {
+			component := checkResolution(func(string) ([]string, error) { return []string{"10.0.0.1"}, nil }, "docker", loopback.DefaultAddress)
+
+			Expect(component.Healthy).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RouterStatus is what Traefik's API says about a single HTTP router.
+type RouterStatus struct {
+	// Name is the name of the router, followed by the provider that defined it, like "app@docker".
+	Name     string `json:"name"`
+	Rule     string `json:"rule"`
+	Provider string `json:"provider"`
+	// Status is "enabled" if Traefik is routing requests using the router.
+	Status string `json:"status"`
+}
+
+// Routers asks Traefik's API, using get, for every HTTP router it knows about, keyed by the
+// router's name without its provider.
+func Routers(get func(path string) ([]byte, error)) (map[string]RouterStatus, error) {
+	data, err := get("/api/http/routers")
+
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]RouterStatus, 0)
+
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, fmt.Errorf("Traefik's API returned routers we couldn't understand: %v", err)
+	}
+
+	routers := make(map[string]RouterStatus, len(statuses))
+
+	for _, status := range statuses {
+		routers[strings.TrimSuffix(status.Name, "@"+status.Provider)] = status
+	}
+
+	return routers, nil
+}
+
+// Get requests the specified path from Traefik's API on the falcon-proxy container, which listens
+// on the specified address and serves the API at the specified hostname. The request goes straight
+// to the address so that it works even when falcon's domains don't resolve. The request is
+// abandoned once ctx is done.
+func Get(ctx context.Context, address string, hostname string, path string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Host = hostname
+
+	response, err := (&http.Client{Timeout: 5 * time.Second}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Traefik's API responded to %v with %v", path, response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
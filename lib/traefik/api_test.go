@@ -0,0 +1,40 @@
+package traefik
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API", func() {
+	Describe("Routers", func() {
+		It("returns every router keyed by name without its provider", func() {
+			get := func(path string) ([]byte, error) {
+				Expect(path).To(Equal("/api/http/routers"))
+				return []byte(`[
+					{"name": "app@docker", "rule": "Host(` + "`app.docker`" + `)", "provider": "docker", "status": "enabled"},
+					{"name": "dashboard@internal", "rule": "PathPrefix(` + "`/`" + `)", "provider": "internal", "status": "disabled"}
+				]`), nil
+			}
+
+			routers, err := Routers(get)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(routers).To(HaveLen(2))
+			Expect(routers["app"].Status).To(Equal("enabled"))
+			Expect(routers["dashboard"].Status).To(Equal("disabled"))
+		})
+
+		It("returns an error if the API can't be reached", func() {
+			get := func(string) ([]byte, error) { return nil, fmt.Errorf("connection refused") }
+
+			Expect(Routers(get)).Error().To(MatchError("connection refused"))
+		})
+
+		It("returns an error if the API returns something unexpected", func() {
+			get := func(string) ([]byte, error) { return []byte("<html>"), nil }
+
+			Expect(Routers(get)).Error().To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package traefik
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/Hawkbawk/falcon/lib/domains"
+	"github.com/docker/docker/api/types"
+)
+
+// The labels Docker Compose adds to every container it starts.
+const composeServiceLabel = "com.docker.compose.service"
+const composeProjectLabel = "com.docker.compose.project"
+
+// DefaultRule creates the rule Traefik uses for containers without a rule of their own when
+// automatic hostnames are enabled. Compose containers get <service>.<project>.<tld> and every
+// other container gets <name>.<tld>, for each of the specified TLDs. It must match what
+// DefaultRoute works out.
+func DefaultRule(tlds []string) string {
+	composeHosts := make([]string, 0, len(tlds))
+	nameHosts := make([]string, 0, len(tlds))
+
+	for _, tld := range tlds {
+		composeHosts = append(composeHosts, fmt.Sprintf("`{{ normalize $service }}.{{ normalize $project }}.%v`", tld))
+		nameHosts = append(nameHosts, fmt.Sprintf("`{{ normalize .Name }}.%v`", tld))
+	}
+
+	return fmt.Sprintf("{{ $service := index .Labels %q }}{{ $project := index .Labels %q }}"+
+		"{{ if and $service $project }}Host(%v){{ else }}Host(%v){{ end }}",
+		composeServiceLabel, composeProjectLabel, strings.Join(composeHosts, ", "), strings.Join(nameHosts, ", "))
+}
+
+// DefaultRoute works out the router name and hostnames Traefik gives a container that doesn't
+// have a rule of its own when automatic hostnames are enabled.
+func DefaultRoute(container types.Container, tlds []string) (string, []string) {
+	service := container.Labels[composeServiceLabel]
+	project := container.Labels[composeProjectLabel]
+
+	if service != "" && project != "" {
+		return Normalize(service + "_" + project), domains.Hostnames(fmt.Sprintf("%v.%v", Normalize(service), Normalize(project)), tlds)
+	}
+
+	name := Normalize(docker.ContainerName(container))
+	return name, domains.Hostnames(name, tlds)
+}
+
+// Normalize works just like Traefik's normalize template function, replacing every run of
+// characters that aren't letters or numbers with a single dash.
+func Normalize(name string) string {
+	return strings.Join(strings.FieldsFunc(name, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	}), "-")
+}
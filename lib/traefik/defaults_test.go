@@ -0,0 +1,45 @@
+package traefik
+
+import (
+	"github.com/docker/docker/api/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Defaults", func() {
+	Describe("DefaultRoute", func() {
+		It("uses the compose service and project", func() {
+			container := types.Container{
+				Names:  []string{"/shop_web_1"},
+				Labels: map[string]string{composeServiceLabel: "web", composeProjectLabel: "my_shop"},
+			}
+
+			router, hosts := DefaultRoute(container, []string{"docker", "test"})
+			Expect(router).To(Equal("web-my-shop"))
+			Expect(hosts).To(Equal([]string{"web.my-shop.docker", "web.my-shop.test"}))
+		})
+
+		It("uses the container name for containers compose didn't start", func() {
+			router, hosts := DefaultRoute(types.Container{Names: []string{"/my_app"}}, []string{"docker"})
+
+			Expect(router).To(Equal("my-app"))
+			Expect(hosts).To(Equal([]string{"my-app.docker"}))
+		})
+	})
+
+	Describe("DefaultRule", func() {
+		It("matches the default hostnames under every TLD", func() {
+			rule := DefaultRule([]string{"docker", "test"})
+
+			Expect(rule).To(ContainSubstring("Host(`{{ normalize $service }}.{{ normalize $project }}.docker`, `{{ normalize $service }}.{{ normalize $project }}.test`)"))
+			Expect(rule).To(ContainSubstring("Host(`{{ normalize .Name }}.docker`, `{{ normalize .Name }}.test`)"))
+		})
+	})
+
+	Describe("Normalize", func() {
+		It("replaces runs of other characters with a dash", func() {
+			Expect(Normalize("my__app.v2")).To(Equal("my-app-v2"))
+			Expect(Normalize("-app-")).To(Equal("app"))
+		})
+	})
+})
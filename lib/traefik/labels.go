@@ -0,0 +1,144 @@
+// The traefik package understands the Traefik labels containers use to tell falcon-proxy how to
+// route requests to them, and can ask Traefik itself which routes it knows about.
+package traefik
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const routerPrefix = "traefik.http.routers."
+const servicePrefix = "traefik.http.services."
+
+// Matches each Host(...) matcher in a router rule, capturing its arguments. HostRegexp and HostSNI
+// aren't matched, since they don't describe a single hostname.
+var hostMatcherRegex = regexp.MustCompile("\\bHost\\(([^)]*)\\)")
+
+// Router is a single HTTP router defined by a container's labels.
+type Router struct {
+	// Name is the name of the router, like "app" for "traefik.http.routers.app.rule".
+	Name string
+	// Rule is the router's rule, exactly as it appears in the label.
+	Rule string
+	// Hosts lists every hostname matched by a Host(...) matcher in the rule.
+	Hosts []string
+	// Service is the name of the service the router sends requests to, if the labels say.
+	Service string
+	// Port is the port the router's service sends requests to, if the labels say.
+	Port string
+	// TLS is true if the router only accepts HTTPS requests.
+	TLS bool
+}
+
+// Enabled reports whether the labels tell Traefik to route requests to the container at all.
+func Enabled(labels map[string]string) bool {
+	return labels["traefik.enable"] == "true"
+}
+
+// Disabled reports whether the labels explicitly tell Traefik not to route requests to the
+// container, even when automatic hostnames are enabled.
+func Disabled(labels map[string]string) bool {
+	return labels["traefik.enable"] == "false"
+}
+
+// ParseRouters returns every HTTP router defined by the specified labels, sorted by name.
+func ParseRouters(labels map[string]string) []Router {
+	routers := make(map[string]*Router)
+	services := make(map[string]string)
+
+	for key, value := range labels {
+		if name, option, ok := splitKey(key, servicePrefix); ok && option == "loadbalancer.server.port" {
+			services[name] = value
+			continue
+		}
+
+		name, option, ok := splitKey(key, routerPrefix)
+
+		if !ok {
+			continue
+		}
+
+		router, exists := routers[name]
+		if !exists {
+			router = &Router{Name: name, Hosts: []string{}}
+			routers[name] = router
+		}
+
+		switch {
+		case option == "rule":
+			router.Rule = value
+			router.Hosts = HostsFromRule(value)
+		case option == "service":
+			router.Service = value
+		case option == "tls":
+			router.TLS = router.TLS || value == "true"
+		case strings.HasPrefix(option, "tls."):
+			// Setting any TLS option, like a certificate resolver, enables TLS for the router.
+			router.TLS = true
+		}
+	}
+
+	result := make([]Router, 0, len(routers))
+
+	for _, router := range routers {
+		router.Port = servicePort(*router, services)
+		result = append(result, *router)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// HostsFromRule returns every hostname matched by a Host(...) matcher in the specified rule, like
+// "Host(`app.docker`) || Host(`api.docker`)", in the order they appear.
+func HostsFromRule(rule string) []string {
+	hosts := make([]string, 0)
+
+	for _, match := range hostMatcherRegex.FindAllStringSubmatch(rule, -1) {
+		for _, arg := range strings.Split(match[1], ",") {
+			if host := strings.Trim(strings.TrimSpace(arg), "`\""); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return hosts
+}
+
+// servicePort finds the port of the service the router uses. Like Traefik, if the router doesn't
+// name its service and the labels only define one, that's the one it uses.
+func servicePort(router Router, services map[string]string) string {
+	if router.Service != "" {
+		return services[router.Service]
+	}
+
+	if port, ok := services[router.Name]; ok {
+		return port
+	}
+
+	if len(services) == 1 {
+		for _, port := range services {
+			return port
+		}
+	}
+
+	return ""
+}
+
+// splitKey splits a label like "traefik.http.routers.app.rule" into the name "app" and the option
+// "rule", if the label starts with the specified prefix.
+func splitKey(key string, prefix string) (string, string, bool) {
+	if !strings.HasPrefix(key, prefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, prefix), ".", 2)
+
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,107 @@
+package traefik
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Labels", func() {
+	Describe("Enabled", func() {
+		It("is true only if traefik.enable is true", func() {
+			Expect(Enabled(map[string]string{"traefik.enable": "true"})).To(BeTrue())
+			Expect(Enabled(map[string]string{"traefik.enable": "false"})).To(BeFalse())
+			Expect(Enabled(map[string]string{})).To(BeFalse())
+		})
+	})
+
+	Describe("Disabled", func() {
+		It("is true only if traefik.enable is false", func() {
+			Expect(Disabled(map[string]string{"traefik.enable": "false"})).To(BeTrue())
+			Expect(Disabled(map[string]string{})).To(BeFalse())
+		})
+	})
+
+	Describe("HostsFromRule", func() {
+		DescribeTable("finds every hostname",
+			func(rule string, hosts []string) {
+				Expect(HostsFromRule(rule)).To(Equal(hosts))
+			},
+			Entry("a single host", "Host(`app.docker`)", []string{"app.docker"}),
+			Entry("double quotes", `Host("app.docker")`, []string{"app.docker"}),
+			Entry("several hosts in one matcher", "Host(`app.docker`, `www.app.docker`)", []string{"app.docker", "www.app.docker"}),
+			Entry("several matchers", "Host(`app.docker`) || Host(`api.docker`)", []string{"app.docker", "api.docker"}),
+			Entry("other matchers", "Host(`app.docker`) && PathPrefix(`/api`)", []string{"app.docker"}),
+			Entry("parentheses around matchers", "(Host(`a.docker`) || Host(`b.docker`)) && Method(`GET`)", []string{"a.docker", "b.docker"}),
+			Entry("host regexps, which aren't a single hostname", "HostRegexp(`{sub:[a-z]+}.docker`)", []string{}),
+			Entry("no host at all", "PathPrefix(`/`)", []string{}),
+			Entry("an empty rule", "", []string{}),
+		)
+	})
+
+	Describe("ParseRouters", func() {
+		It("returns every router with its hosts, port and TLS setting", func() {
+			labels := map[string]string{
+				"traefik.enable":                                     "true",
+				"traefik.http.routers.web.rule":                      "Host(`app.docker`)",
+				"traefik.http.routers.web-secure.rule":               "Host(`app.docker`)",
+				"traefik.http.routers.web-secure.tls":                "true",
+				"traefik.http.services.web.loadbalancer.server.port": "3000",
+			}
+
+			Expect(ParseRouters(labels)).To(Equal([]Router{
+				{Name: "web", Rule: "Host(`app.docker`)", Hosts: []string{"app.docker"}, Port: "3000"},
+				{Name: "web-secure", Rule: "Host(`app.docker`)", Hosts: []string{"app.docker"}, Port: "3000", TLS: true},
+			}))
+		})
+
+		It("uses the service the router names", func() {
+			labels := map[string]string{
+				"traefik.http.routers.api.rule":                           "Host(`api.docker`)",
+				"traefik.http.routers.api.service":                        "backend",
+				"traefik.http.services.backend.loadbalancer.server.port":  "8080",
+				"traefik.http.services.frontend.loadbalancer.server.port": "3000",
+			}
+
+			routers := ParseRouters(labels)
+			Expect(routers).To(HaveLen(1))
+			Expect(routers[0].Service).To(Equal("backend"))
+			Expect(routers[0].Port).To(Equal("8080"))
+		})
+
+		It("uses the service with the router's name when there are several", func() {
+			labels := map[string]string{
+				"traefik.http.routers.api.rule":                      "Host(`api.docker`)",
+				"traefik.http.services.api.loadbalancer.server.port": "8080",
+				"traefik.http.services.web.loadbalancer.server.port": "3000",
+			}
+
+			Expect(ParseRouters(labels)[0].Port).To(Equal("8080"))
+		})
+
+		It("leaves the port empty if the labels don't say which to use", func() {
+			labels := map[string]string{"traefik.http.routers.api.rule": "Host(`api.docker`)"}
+
+			Expect(ParseRouters(labels)[0].Port).To(BeEmpty())
+		})
+
+		It("treats any TLS option as enabling TLS", func() {
+			labels := map[string]string{
+				"traefik.http.routers.api.rule":             "Host(`api.docker`)",
+				"traefik.http.routers.api.tls.certresolver": "letsencrypt",
+			}
+
+			Expect(ParseRouters(labels)[0].TLS).To(BeTrue())
+		})
+
+		It("ignores labels that aren't for HTTP routers", func() {
+			labels := map[string]string{
+				"com.docker.compose.service":   "app",
+				"traefik.tcp.routers.db.rule":  "HostSNI(`*`)",
+				"traefik.http.routers.":        "nonsense",
+				"traefik.http.routers.noparts": "nonsense",
+			}
+
+			Expect(ParseRouters(labels)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package traefik
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Hawkbawk/falcon/lib/docker"
+	"github.com/docker/docker/api/types"
+)
+
+// Route is a single hostname that falcon-proxy sends to a container.
+type Route struct {
+	Hostname  string `json:"hostname"`
+	Router    string `json:"router"`
+	Container string `json:"container"`
+	Port      string `json:"port"`
+	TLS       bool   `json:"tls"`
+	// Status is what Traefik's API says about the route's router, if it was asked.
+	Status string `json:"status,omitempty"`
+}
+
+// Routes returns every route defined by the labels of the specified containers, sorted by
+// hostname. Containers that haven't enabled Traefik are skipped, unless automatic hostnames are
+// enabled, in which case they're given the routes Traefik's default rule gives them under each of
+// the specified TLDs.
+func Routes(containers []types.Container, autoHostnames bool, tlds []string) []Route {
+	routes := make([]Route, 0)
+
+	for _, container := range containers {
+		routers := ParseRouters(container.Labels)
+
+		if autoHostnames && !Disabled(container.Labels) && !hasRule(routers) {
+			name, hosts := DefaultRoute(container, tlds)
+			for _, host := range hosts {
+				routes = append(routes, Route{
+					Hostname:  host,
+					Router:    name,
+					Container: docker.ContainerName(container),
+					Port:      onlyPort(container),
+				})
+			}
+			continue
+		} else if !Enabled(container.Labels) {
+			continue
+		}
+
+		for _, router := range routers {
+			port := router.Port
+			if port == "" {
+				port = onlyPort(container)
+			}
+
+			for _, host := range router.Hosts {
+				routes = append(routes, Route{
+					Hostname:  host,
+					Router:    router.Name,
+					Container: docker.ContainerName(container),
+					Port:      port,
+					TLS:       router.TLS,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Hostname != routes[j].Hostname {
+			return routes[i].Hostname < routes[j].Hostname
+		}
+		return routes[i].Router < routes[j].Router
+	})
+
+	return routes
+}
+
+// WithStatus fills in the status of each route from what Traefik's API says about its router.
+// Routes whose router Traefik doesn't know about are marked as missing.
+func WithStatus(routes []Route, routers map[string]RouterStatus) []Route {
+	result := make([]Route, 0, len(routes))
+
+	for _, route := range routes {
+		if router, ok := routers[route.Router]; ok {
+			route.Status = router.Status
+		} else {
+			route.Status = "missing"
+		}
+		result = append(result, route)
+	}
+
+	return result
+}
+
+// hasRule reports whether any of the routers has a rule of its own.
+func hasRule(routers []Router) bool {
+	for _, router := range routers {
+		if router.Rule != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// onlyPort returns the port the container exposes, if it only exposes one. Traefik uses that port
+// when the labels don't specify one.
+func onlyPort(container types.Container) string {
+	ports := make(map[uint16]bool)
+
+	for _, port := range container.Ports {
+		ports[port.PrivatePort] = true
+	}
+
+	if len(ports) != 1 {
+		return ""
+	}
+
+	for port := range ports {
+		return strconv.Itoa(int(port))
+	}
+
+	return ""
+}
@@ -0,0 +1,85 @@
+package traefik
+
+import (
+	"github.com/docker/docker/api/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes", func() {
+	app := types.Container{
+		ID:    "abc",
+		Names: []string{"/app"},
+		Ports: []types.Port{{PrivatePort: 3000, Type: "tcp"}, {PrivatePort: 3000, Type: "tcp", IP: "::"}},
+		Labels: map[string]string{
+			"traefik.enable":                "true",
+			"traefik.http.routers.app.rule": "Host(`www.app.docker`) || Host(`app.docker`)",
+			"traefik.http.routers.app.tls":  "true",
+		},
+	}
+	api := types.Container{
+		ID: "def",
+		Labels: map[string]string{
+			"traefik.enable":                                     "true",
+			"traefik.http.routers.api.rule":                      "Host(`api.docker`)",
+			"traefik.http.services.api.loadbalancer.server.port": "8080",
+		},
+	}
+	disabled := types.Container{
+		Names: []string{"/disabled"},
+		Labels: map[string]string{
+			"traefik.http.routers.disabled.rule": "Host(`disabled.docker`)",
+		},
+	}
+
+	Describe("Routes", func() {
+		It("returns a route for each hostname of each enabled container, sorted by hostname", func() {
+			Expect(Routes([]types.Container{app, api, disabled}, false, []string{"docker"})).To(Equal([]Route{
+				{Hostname: "api.docker", Router: "api", Container: "def", Port: "8080"},
+				{Hostname: "app.docker", Router: "app", Container: "app", Port: "3000", TLS: true},
+				{Hostname: "www.app.docker", Router: "app", Container: "app", Port: "3000", TLS: true},
+			}))
+		})
+
+		It("leaves the port empty if the container exposes several and the labels don't pick one", func() {
+			several := app
+			several.Ports = []types.Port{{PrivatePort: 3000}, {PrivatePort: 3001}}
+
+			Expect(Routes([]types.Container{several}, false, []string{"docker"})[0].Port).To(BeEmpty())
+		})
+	})
+
+	Describe("Routes with automatic hostnames", func() {
+		unlabeled := types.Container{
+			Names:  []string{"/shop_web_1"},
+			Ports:  []types.Port{{PrivatePort: 8000}},
+			Labels: map[string]string{composeServiceLabel: "web", composeProjectLabel: "shop"},
+		}
+		optedOut := types.Container{
+			Names:  []string{"/db"},
+			Labels: map[string]string{"traefik.enable": "false"},
+		}
+
+		It("gives containers without a rule their default hostname", func() {
+			Expect(Routes([]types.Container{unlabeled, optedOut, api}, true, []string{"docker"})).To(Equal([]Route{
+				{Hostname: "api.docker", Router: "api", Container: "def", Port: "8080"},
+				{Hostname: "web.shop.docker", Router: "web-shop", Container: "shop_web_1", Port: "8000"},
+			}))
+		})
+
+		It("leaves those containers out when it's disabled", func() {
+			Expect(Routes([]types.Container{unlabeled}, false, []string{"docker"})).To(BeEmpty())
+		})
+	})
+
+	Describe("WithStatus", func() {
+		It("fills in each route's status, marking routers Traefik doesn't know about as missing", func() {
+			routes := Routes([]types.Container{app, api}, false, []string{"docker"})
+
+			withStatus := WithStatus(routes, map[string]RouterStatus{"app": {Status: "enabled"}})
+			Expect(withStatus[0].Status).To(Equal("missing"))
+			Expect(withStatus[1].Status).To(Equal("enabled"))
+			Expect(routes[0].Status).To(BeEmpty())
+		})
+	})
+})
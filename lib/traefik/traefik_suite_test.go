@@ -0,0 +1,13 @@
+package traefik
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTraefik(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Traefik Suite")
+}
@@ -5,10 +5,13 @@
 package mock_docker
 
 import (
+	context "context"
 	reflect "reflect"
 
+	config "github.com/Hawkbawk/falcon/lib/config"
 	types "github.com/docker/docker/api/types"
 	container "github.com/docker/docker/api/types/container"
+	events "github.com/docker/docker/api/types/events"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -35,45 +38,147 @@ func (m *MockDockerClient) EXPECT() *MockDockerClientMockRecorder {
 	return m.recorder
 }
 
+// ConnectContainer mocks base method.
+func (m *MockDockerClient) ConnectContainer(arg0 context.Context, arg1 string, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConnectContainer", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ConnectContainer indicates an expected call of ConnectContainer.
+func (mr *MockDockerClientMockRecorder) ConnectContainer(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectContainer", reflect.TypeOf((*MockDockerClient)(nil).ConnectContainer), arg0, arg1, arg2)
+}
+
+// ContainerEvents mocks base method.
+func (m *MockDockerClient) ContainerEvents(arg0 context.Context) (<-chan events.Message, <-chan error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerEvents", arg0)
+	ret0, _ := ret[0].(<-chan events.Message)
+	ret1, _ := ret[1].(<-chan error)
+	return ret0, ret1
+}
+
+// ContainerEvents indicates an expected call of ContainerEvents.
+func (mr *MockDockerClientMockRecorder) ContainerEvents(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerEvents", reflect.TypeOf((*MockDockerClient)(nil).ContainerEvents), arg0)
+}
+
+// EnsureNetwork mocks base method.
+func (m *MockDockerClient) EnsureNetwork(arg0 context.Context, arg1 string, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsureNetwork", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnsureNetwork indicates an expected call of EnsureNetwork.
+func (mr *MockDockerClientMockRecorder) EnsureNetwork(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureNetwork", reflect.TypeOf((*MockDockerClient)(nil).EnsureNetwork), arg0, arg1, arg2)
+}
+
 // GetContainer mocks base method.
-func (m *MockDockerClient) GetContainer(arg0 string) (*types.Container, error) {
+func (m *MockDockerClient) GetContainer(arg0 context.Context, arg1 string) (*types.Container, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetContainer", arg0)
+	ret := m.ctrl.Call(m, "GetContainer", arg0, arg1)
 	ret0, _ := ret[0].(*types.Container)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetContainer indicates an expected call of GetContainer.
-func (mr *MockDockerClientMockRecorder) GetContainer(arg0 interface{}) *gomock.Call {
+func (mr *MockDockerClientMockRecorder) GetContainer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContainer", reflect.TypeOf((*MockDockerClient)(nil).GetContainer), arg0, arg1)
+}
+
+// ImageDigests mocks base method.
+func (m *MockDockerClient) ImageDigests(arg0 context.Context, arg1 string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageDigests", arg0, arg1)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageDigests indicates an expected call of ImageDigests.
+func (mr *MockDockerClientMockRecorder) ImageDigests(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageDigests", reflect.TypeOf((*MockDockerClient)(nil).ImageDigests), arg0, arg1)
+}
+
+// ListContainers mocks base method.
+func (m *MockDockerClient) ListContainers(arg0 context.Context) ([]types.Container, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListContainers", arg0)
+	ret0, _ := ret[0].([]types.Container)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListContainers indicates an expected call of ListContainers.
+func (mr *MockDockerClientMockRecorder) ListContainers(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListContainers", reflect.TypeOf((*MockDockerClient)(nil).ListContainers), arg0)
+}
+
+// RemoveNetwork mocks base method.
+func (m *MockDockerClient) RemoveNetwork(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveNetwork", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveNetwork indicates an expected call of RemoveNetwork.
+func (mr *MockDockerClientMockRecorder) RemoveNetwork(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveNetwork", reflect.TypeOf((*MockDockerClient)(nil).RemoveNetwork), arg0, arg1)
+}
+
+// ServerVersion mocks base method.
+func (m *MockDockerClient) ServerVersion(arg0 context.Context) (types.Version, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ServerVersion", arg0)
+	ret0, _ := ret[0].(types.Version)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ServerVersion indicates an expected call of ServerVersion.
+func (mr *MockDockerClientMockRecorder) ServerVersion(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContainer", reflect.TypeOf((*MockDockerClient)(nil).GetContainer), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ServerVersion", reflect.TypeOf((*MockDockerClient)(nil).ServerVersion), arg0)
 }
 
 // StartContainer mocks base method.
-func (m *MockDockerClient) StartContainer(arg0 string, arg1 *container.HostConfig, arg2 *container.Config, arg3 string) error {
+func (m *MockDockerClient) StartContainer(arg0 context.Context, arg1 string, arg2 config.PullPolicy, arg3 bool, arg4 *container.HostConfig, arg5 *container.Config, arg6 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "StartContainer", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "StartContainer", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // StartContainer indicates an expected call of StartContainer.
-func (mr *MockDockerClientMockRecorder) StartContainer(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockDockerClientMockRecorder) StartContainer(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartContainer", reflect.TypeOf((*MockDockerClient)(nil).StartContainer), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartContainer", reflect.TypeOf((*MockDockerClient)(nil).StartContainer), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
 }
 
 // StopAndRemoveContainer mocks base method.
-func (m *MockDockerClient) StopAndRemoveContainer(arg0 string) error {
+func (m *MockDockerClient) StopAndRemoveContainer(arg0 context.Context, arg1 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "StopAndRemoveContainer", arg0)
+	ret := m.ctrl.Call(m, "StopAndRemoveContainer", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // StopAndRemoveContainer indicates an expected call of StopAndRemoveContainer.
-func (mr *MockDockerClientMockRecorder) StopAndRemoveContainer(arg0 interface{}) *gomock.Call {
+func (mr *MockDockerClientMockRecorder) StopAndRemoveContainer(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StopAndRemoveContainer", reflect.TypeOf((*MockDockerClient)(nil).StopAndRemoveContainer), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StopAndRemoveContainer", reflect.TypeOf((*MockDockerClient)(nil).StopAndRemoveContainer), arg0, arg1)
 }